@@ -0,0 +1,258 @@
+// Package acl – пользователи сервера, команда AUTH и права на команды и ключи.
+//
+// Каждая команда относится к одной из категорий:
+//
+//	read  – чтение ключей (GET, HGETALL, MGET, WATCH, ...)
+//	write – изменение ключей (SET, DEL, LPUSH, MSET, ...)
+//	admin – команды над всем пространством ключей (SCAN, KEYS, DBSIZE, RANGE, PREFIX):
+//	        их нельзя ограничить шаблонами ключей, поэтому они выделены отдельно
+//
+// Команды read и write проверяются ещё и по ключам: каждый ключ команды должен подходить
+// под один из glob-шаблонов пользователя. MULTI, EXEC, DISCARD и UNWATCH доступны любому
+// вошедшему пользователю – команды транзакции проверяются, когда ставятся в очередь.
+package acl
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"imkvdb/compute"
+	"imkvdb/compute/parser"
+	"imkvdb/config"
+	"imkvdb/storage"
+)
+
+// Category – категория команд
+type Category string
+
+const (
+	Read  Category = "read"
+	Write Category = "write"
+	Admin Category = "admin"
+)
+
+// CategoryOf – категория команды; false – команда без категории (управление транзакцией, AUTH)
+func CategoryOf(cmd parser.Command) (Category, bool) {
+	switch cmd.Type {
+	case parser.MULTI, parser.EXEC, parser.DISCARD, parser.UNWATCH, parser.AUTH:
+		return "", false
+	case parser.SCAN, parser.KEYS, parser.DBSIZE, parser.RANGE, parser.PREFIX:
+		return Admin, true
+	}
+	if compute.IsWrite(cmd) {
+		return Write, true
+	}
+	return Read, true
+}
+
+// Keys – ключи, которые читает или меняет команда
+func Keys(cmd parser.Command) []string {
+	var keys []string
+	if cmd.Key != "" {
+		keys = append(keys, cmd.Key)
+	}
+	keys = append(keys, cmd.Keys...)
+	if cmd.Type == parser.MSET {
+		for i := 0; i < len(cmd.Args); i += 2 {
+			keys = append(keys, cmd.Args[i])
+		}
+	}
+	return keys
+}
+
+// user – пользователь из config.AuthConfig
+type user struct {
+	name       string
+	password   passwordHash
+	categories map[Category]bool
+	keys       []string // glob-шаблоны ключей
+}
+
+// allowsKey – подходит ли ключ под один из шаблонов пользователя
+func (u *user) allowsKey(key string) bool {
+	for _, pattern := range u.keys {
+		if storage.MatchPattern(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// ACL – пользователи сервера и журнал аудита. Общий для всех соединений.
+type ACL struct {
+	users map[string]*user
+	// dummy – хеш для проверки пароля неизвестного пользователя; итераций как у самого
+	// медленного из настоящих хешей
+	dummy passwordHash
+	audit *zap.Logger
+	// auditFile – файл auth.audit_log (nil – журнал пишется в общий лог)
+	auditFile *os.File
+}
+
+// New создаёт ACL из cfg. Без пользователей аутентификация выключена: возвращается nil.
+// Неверные хеши паролей, неизвестные категории и повторные имена – ошибка.
+func New(cfg config.AuthConfig, logger *zap.Logger) (*ACL, error) {
+	if len(cfg.Users) == 0 {
+		return nil, nil
+	}
+	a := &ACL{users: make(map[string]*user, len(cfg.Users))}
+	min := cfg.MinPasswordIterations
+	if min <= 0 {
+		min = minIterations
+	}
+	for _, uc := range cfg.Users {
+		if uc.Name == "" {
+			return nil, fmt.Errorf("auth.users: user without a name")
+		}
+		if _, dup := a.users[uc.Name]; dup {
+			return nil, fmt.Errorf("auth.users: duplicate user %q", uc.Name)
+		}
+		hash, err := parsePasswordHash(uc.PasswordHash, min)
+		if err != nil {
+			return nil, fmt.Errorf("auth.users %q: %w", uc.Name, err)
+		}
+		u := &user{name: uc.Name, password: hash, categories: make(map[Category]bool), keys: uc.Keys}
+		for _, c := range uc.Categories {
+			switch category := Category(strings.ToLower(c)); category {
+			case Read, Write, Admin:
+				u.categories[category] = true
+			default:
+				return nil, fmt.Errorf("auth.users %q: unknown category %q (expected read, write or admin)", uc.Name, c)
+			}
+		}
+		a.users[uc.Name] = u
+		a.dummy.iterations = max(a.dummy.iterations, hash.iterations)
+	}
+	dummy, err := dummyPasswordHash(a.dummy.iterations)
+	if err != nil {
+		return nil, fmt.Errorf("auth: %w", err)
+	}
+	a.dummy = dummy
+
+	a.audit = logger.With(zap.String("log", "audit"))
+	if cfg.AuditLog != "" {
+		f, err := os.OpenFile(cfg.AuditLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("open audit log: %w", err)
+		}
+		a.auditFile = f
+		a.audit = zap.New(zapcore.NewCore(
+			zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(f), zapcore.InfoLevel))
+	}
+	return a, nil
+}
+
+// Close закрывает файл журнала аудита
+func (a *ACL) Close() error {
+	if a.auditFile == nil {
+		return nil
+	}
+	_ = a.audit.Sync()
+	return a.auditFile.Close()
+}
+
+// NewConn – состояние аутентификации нового соединения с адресом remote (для журнала)
+func (a *ACL) NewConn(remote string) *Conn {
+	return &Conn{acl: a, remote: remote}
+}
+
+// Conn – аутентификация одного соединения; реализует compute.Access.
+// Как и compute.Session, используется только горутиной своего соединения.
+type Conn struct {
+	acl    *ACL
+	remote string
+	user   *user // nil – AUTH ещё не выполнен
+}
+
+var _ compute.Access = (*Conn)(nil)
+
+var (
+	errNoAuth    = &compute.Error{Code: compute.CodeNoAuth, Msg: "Authentication required."}
+	errWrongPass = &compute.Error{Code: compute.CodeWrongPass, Msg: "invalid username-password pair"}
+)
+
+// Auth выполняет вход. Неудачная попытка сбрасывает прежнего пользователя соединения.
+// Пароль неизвестного пользователя тоже проверяется (по c.acl.dummy), поэтому время
+// ответа одинаково для неизвестного пользователя и неверного пароля.
+func (c *Conn) Auth(name, password string) error {
+	u, ok := c.acl.users[name]
+	hash := c.acl.dummy
+	if ok {
+		hash = u.password
+	}
+	if !hash.matches(password) || !ok {
+		c.user = nil
+		authFailures.Inc()
+		c.acl.audit.Warn("authentication failed",
+			zap.String("remote", c.remote),
+			zap.String("user", name),
+		)
+		return errWrongPass
+	}
+	c.user = u
+	c.acl.audit.Info("authenticated",
+		zap.String("remote", c.remote),
+		zap.String("user", name),
+	)
+	return nil
+}
+
+// Check проверяет категорию и ключи команды; отказ пишется в журнал аудита
+func (c *Conn) Check(cmd parser.Command) error {
+	category, ok := CategoryOf(cmd)
+	if !ok {
+		if c.user == nil {
+			c.deny(cmd.Type.String(), "noauth", "")
+			return errNoAuth
+		}
+		return nil
+	}
+	if err := c.CheckCategory(category, cmd.Type.String()); err != nil {
+		return err
+	}
+	if category == Admin {
+		return nil
+	}
+	for _, key := range Keys(cmd) {
+		if !c.user.allowsKey(key) {
+			c.deny(cmd.Type.String(), "key", key)
+			return &compute.Error{Code: compute.CodeNoPerm,
+				Msg: fmt.Sprintf("User %s has no permissions to access the '%s' key", c.user.name, key)}
+		}
+	}
+	return nil
+}
+
+// CheckCategory проверяет, что пользователь вошёл и ему доступна категория category;
+// command – имя команды для ответа и журнала аудита. Нужна и вне compute (SYNC репликации).
+func (c *Conn) CheckCategory(category Category, command string) error {
+	if c.user == nil {
+		c.deny(command, "noauth", "")
+		return errNoAuth
+	}
+	if !c.user.categories[category] {
+		c.deny(command, "category", string(category))
+		return &compute.Error{Code: compute.CodeNoPerm,
+			Msg: fmt.Sprintf("User %s has no permissions to run the '%s' command", c.user.name, command)}
+	}
+	return nil
+}
+
+// deny пишет отказ в журнал аудита и метрики
+func (c *Conn) deny(command, reason, detail string) {
+	userName := ""
+	if c.user != nil {
+		userName = c.user.name
+	}
+	deniedCommands.WithLabelValues(reason).Inc()
+	c.acl.audit.Warn("command denied",
+		zap.String("remote", c.remote),
+		zap.String("user", userName),
+		zap.String("command", command),
+		zap.String("reason", reason),
+		zap.String("detail", detail),
+	)
+}
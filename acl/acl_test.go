@@ -0,0 +1,198 @@
+package acl_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"imkvdb/acl"
+	"imkvdb/compute"
+	"imkvdb/compute/parser"
+	"imkvdb/config"
+)
+
+func hash(t *testing.T, password string) string {
+	t.Helper()
+	h, err := acl.HashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func newACL(t *testing.T, auditLog string) *acl.ACL {
+	t.Helper()
+	a, err := acl.New(config.AuthConfig{
+		AuditLog: auditLog,
+		Users: []config.UserConfig{
+			{Name: "admin", PasswordHash: hash(t, "root"), Categories: []string{"read", "write", "admin"}, Keys: []string{"*"}},
+			{Name: "reader", PasswordHash: hash(t, "r"), Categories: []string{"read"}, Keys: []string{"cache:*"}},
+			{Name: "default", PasswordHash: hash(t, "pw"), Categories: []string{"READ", "Write"}, Keys: []string{"app:*", "tmp"}},
+		},
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("acl.New: %v", err)
+	}
+	t.Cleanup(func() { _ = a.Close() })
+	return a
+}
+
+// code – код ошибки compute (пусто – ошибки нет)
+func code(err error) compute.ErrorCode {
+	var e *compute.Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	if err != nil {
+		return "?"
+	}
+	return ""
+}
+
+func TestACL_Disabled(t *testing.T) {
+	a, err := acl.New(config.AuthConfig{}, zap.NewNop())
+	if err != nil || a != nil {
+		t.Errorf("expected nil ACL without users, got %v, %v", a, err)
+	}
+}
+
+func TestACL_Check(t *testing.T) {
+	a := newACL(t, "")
+	p := parser.NewParser()
+
+	check := func(c *acl.Conn, input string) compute.ErrorCode {
+		t.Helper()
+		cmd, err := p.Parse(input)
+		if err != nil {
+			t.Fatalf("parse %q: %v", input, err)
+		}
+		return code(c.Check(cmd))
+	}
+
+	conn := a.NewConn("127.0.0.1:1")
+	if got := check(conn, "GET cache:1"); got != compute.CodeNoAuth {
+		t.Errorf("before AUTH: got %q, want NOAUTH", got)
+	}
+	if got := code(conn.Auth("reader", "wrong")); got != compute.CodeWrongPass {
+		t.Errorf("wrong password: got %q", got)
+	}
+	if got := code(conn.Auth("nobody", "r")); got != compute.CodeWrongPass {
+		t.Errorf("unknown user: got %q", got)
+	}
+	if err := conn.Auth("reader", "r"); err != nil {
+		t.Fatalf("AUTH reader: %v", err)
+	}
+
+	tests := []struct {
+		input string
+		want  compute.ErrorCode
+	}{
+		{"GET cache:1", ""},
+		{"MGET cache:1 cache:2", ""},
+		{"WATCH cache:1", ""},
+		{"MULTI", ""},
+		{"GET users:1", compute.CodeNoPerm},
+		{"MGET cache:1 users:1", compute.CodeNoPerm},
+		{"SET cache:1 v", compute.CodeNoPerm},
+		{"KEYS *", compute.CodeNoPerm},
+		{"DBSIZE", compute.CodeNoPerm},
+	}
+	for _, tt := range tests {
+		if got := check(conn, tt.input); got != tt.want {
+			t.Errorf("reader %s: got %q, want %q", tt.input, got, tt.want)
+		}
+	}
+
+	// AUTH без имени – пользователь default; категории без учёта регистра
+	def := a.NewConn("127.0.0.1:2")
+	if err := def.Auth(parser.DefaultUser, "pw"); err != nil {
+		t.Fatalf("AUTH default: %v", err)
+	}
+	for input, want := range map[string]compute.ErrorCode{
+		"MSET app:1 a tmp b":       "",
+		"MSET app:1 a other b":     compute.CodeNoPerm,
+		"SINTERSTORE app:d app:a":  "",
+		"SINTERSTORE other app:a":  compute.CodeNoPerm,
+		"SCAN 0":                   compute.CodeNoPerm,
+		"PREFIX app:":              compute.CodeNoPerm,
+		"BLPOP app:q other:q 1":    compute.CodeNoPerm,
+		"INCRBY app:counter 5":     "",
+		"ZADD app:z 1 m":           "",
+		"HSET secret:h field data": compute.CodeNoPerm,
+	} {
+		if got := check(def, input); got != want {
+			t.Errorf("default %s: got %q, want %q", input, got, want)
+		}
+	}
+
+	// admin: все категории, в том числе команды над всем пространством ключей
+	admin := a.NewConn("127.0.0.1:3")
+	if err := admin.Auth("admin", "root"); err != nil {
+		t.Fatalf("AUTH admin: %v", err)
+	}
+	for _, input := range []string{"KEYS *", "SCAN 0", "RANGE a z", "SET x y", "DBSIZE"} {
+		if got := check(admin, input); got != "" {
+			t.Errorf("admin %s: got %q", input, got)
+		}
+	}
+
+	// Неудачный AUTH сбрасывает прежнего пользователя
+	_ = admin.Auth("admin", "bad")
+	if got := check(admin, "GET x"); got != compute.CodeNoAuth {
+		t.Errorf("after failed AUTH: got %q, want NOAUTH", got)
+	}
+}
+
+func TestACL_AuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	a := newACL(t, path)
+	conn := a.NewConn("10.0.0.7:5000")
+	_ = conn.Auth("reader", "nope")
+	_ = conn.Auth("reader", "r")
+	cmd, _ := parser.NewParser().Parse("SET cache:1 v")
+	_ = conn.Check(cmd)
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := string(data)
+	for _, want := range []string{
+		`"msg":"authentication failed"`,
+		`"msg":"authenticated"`,
+		`"msg":"command denied"`,
+		`"remote":"10.0.0.7:5000"`,
+		`"command":"SET"`,
+		`"reason":"category"`,
+	} {
+		if !strings.Contains(log, want) {
+			t.Errorf("audit log has no %s:\n%s", want, log)
+		}
+	}
+	if strings.Contains(log, "nope") {
+		t.Error("audit log contains a password")
+	}
+}
+
+func TestACL_InvalidConfig(t *testing.T) {
+	good := hash(t, "pw")
+	for name, users := range map[string][]config.UserConfig{
+		"bad hash":         {{Name: "u", PasswordHash: "plain"}},
+		"bad hash hex":     {{Name: "u", PasswordHash: "pbkdf2-sha256:600000:zz:00"}},
+		"fast hash":        {{Name: "u", PasswordHash: "pbkdf2-sha256:1000:00ff:" + strings.Repeat("00", 32)}},
+		"old scheme":       {{Name: "u", PasswordHash: "sha256:00ff:" + strings.Repeat("00", 32)}},
+		"unknown category": {{Name: "u", PasswordHash: good, Categories: []string{"root"}}},
+		"duplicate":        {{Name: "u", PasswordHash: good}, {Name: "u", PasswordHash: good}},
+		"no name":          {{PasswordHash: good}},
+	} {
+		if _, err := acl.New(config.AuthConfig{Users: users}, zap.NewNop()); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
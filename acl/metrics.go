@@ -0,0 +1,10 @@
+package acl
+
+import "imkvdb/metrics"
+
+var (
+	authFailures = metrics.NewCounter("imkvdb_auth_failures_total",
+		"AUTH attempts with an unknown user or a wrong password")
+	deniedCommands = metrics.NewCounterVec("imkvdb_acl_denied_total",
+		"Commands denied by ACL by reason: noauth, category, key", "reason")
+)
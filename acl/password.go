@@ -0,0 +1,124 @@
+package acl
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Хеш пароля в конфиге: "pbkdf2-sha256:<итерации>:<соль hex>:<хеш hex>" – PBKDF2-HMAC-SHA256
+// (RFC 8018). Соль у каждого пользователя своя, а число итераций хранится в строке,
+// поэтому его можно увеличить для новых хешей, не ломая старые. Медленный хеш нужен,
+// чтобы перебор паролей по утёкшему конфигу обходился дорого.
+
+const (
+	hashScheme = "pbkdf2-sha256"
+	saltSize   = 16
+	// hashIterations – число итераций для новых хешей (рекомендация OWASP для PBKDF2-HMAC-SHA256)
+	hashIterations = 600_000
+	// minIterations – меньшее число итераций в конфиге считается ошибкой (см. auth.min_password_iterations)
+	minIterations = 100_000
+)
+
+type passwordHash struct {
+	iterations int
+	salt       []byte
+	sum        []byte
+}
+
+// HashPassword – строка для auth.users[].password_hash со случайной солью
+func HashPassword(password string) (string, error) {
+	return HashPasswordIterations(password, hashIterations)
+}
+
+// HashPasswordIterations – то же, что HashPassword, с заданным числом итераций.
+// Хеш с числом итераций меньше 100000 примет только ACL с меньшим auth.min_password_iterations.
+func HashPasswordIterations(password string, iterations int) (string, error) {
+	if iterations < 1 {
+		return "", errors.New("password hash: iterations must be positive")
+	}
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	sum := pbkdf2SHA256([]byte(password), salt, iterations, sha256.Size)
+	return hashScheme + ":" + strconv.Itoa(iterations) + ":" +
+		hex.EncodeToString(salt) + ":" + hex.EncodeToString(sum), nil
+}
+
+// parsePasswordHash разбирает хеш из конфига; хеш меньше чем с min итерациями – ошибка
+func parsePasswordHash(s string, min int) (passwordHash, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 4 || parts[0] != hashScheme {
+		return passwordHash{}, errors.New(`password_hash must look like "pbkdf2-sha256:<iterations>:<salt hex>:<hash hex>"`)
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < min {
+		return passwordHash{}, errors.New("password_hash: iterations must be a number not less than " + strconv.Itoa(min))
+	}
+	salt, err := hex.DecodeString(parts[2])
+	if err != nil || len(salt) == 0 {
+		return passwordHash{}, errors.New("password_hash: salt is not hex")
+	}
+	sum, err := hex.DecodeString(parts[3])
+	if err != nil || len(sum) != sha256.Size {
+		return passwordHash{}, errors.New("password_hash: hash is not a hex SHA-256")
+	}
+	return passwordHash{iterations: iterations, salt: salt, sum: sum}, nil
+}
+
+// dummyPasswordHash – хеш со случайными солью и суммой, которому не подходит ни один пароль.
+// Им проверяется пароль неизвестного пользователя, чтобы ответ AUTH по времени
+// не выдавал, есть ли такой пользователь.
+func dummyPasswordHash(iterations int) (passwordHash, error) {
+	h := passwordHash{iterations: iterations, salt: make([]byte, saltSize), sum: make([]byte, sha256.Size)}
+	if _, err := rand.Read(h.salt); err != nil {
+		return passwordHash{}, err
+	}
+	if _, err := rand.Read(h.sum); err != nil {
+		return passwordHash{}, err
+	}
+	return h, nil
+}
+
+// matches – совпадает ли пароль; сравнение за постоянное время
+func (h passwordHash) matches(password string) bool {
+	sum := pbkdf2SHA256([]byte(password), h.salt, h.iterations, len(h.sum))
+	return subtle.ConstantTimeCompare(sum, h.sum) == 1
+}
+
+// pbkdf2SHA256 – PBKDF2 с HMAC-SHA256 (RFC 8018, раздел 5.2); ключ длины keyLen
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+
+	var counter [4]byte
+	key := make([]byte, 0, blocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= blocks; block++ {
+		// T_i = U_1 ^ U_2 ^ ... ^ U_c, U_1 = PRF(P, S || INT(i)), U_j = PRF(P, U_{j-1})
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], uint32(block))
+		prf.Write(counter[:])
+		key = prf.Sum(key)
+		t := key[len(key)-hashLen:]
+		copy(u, t)
+		for n := 2; n <= iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+	return key[:keyLen]
+}
@@ -0,0 +1,79 @@
+package acl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+// Векторы PBKDF2-HMAC-SHA256 из RFC 7914, раздел 11
+func TestPBKDF2SHA256(t *testing.T) {
+	for _, tt := range []struct {
+		password, salt string
+		iterations     int
+		want           string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+			"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56" +
+			"a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	} {
+		got := hex.EncodeToString(pbkdf2SHA256([]byte(tt.password), []byte(tt.salt), tt.iterations, 64))
+		if got != tt.want {
+			t.Errorf("pbkdf2(%q, %q, %d) = %s, want %s", tt.password, tt.salt, tt.iterations, got, tt.want)
+		}
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	s, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := parsePasswordHash(s, minIterations)
+	if err != nil {
+		t.Fatalf("parsePasswordHash(%q): %v", s, err)
+	}
+	if h.iterations != hashIterations {
+		t.Errorf("iterations = %d, want %d", h.iterations, hashIterations)
+	}
+	if !h.matches("secret") || h.matches("Secret") {
+		t.Error("hash matches a wrong password or does not match the right one")
+	}
+	if other, _ := HashPassword("secret"); other == s {
+		t.Error("same password gave the same hash: salt is not random")
+	}
+}
+
+func TestHashPasswordIterations(t *testing.T) {
+	s, err := HashPasswordIterations("secret", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parsePasswordHash(s, minIterations); err == nil {
+		t.Error("expected a hash with 1000 iterations to be rejected by default")
+	}
+	h, err := parsePasswordHash(s, 1000)
+	if err != nil {
+		t.Fatalf("parsePasswordHash(%q, 1000): %v", s, err)
+	}
+	if h.iterations != 1000 || !h.matches("secret") {
+		t.Errorf("iterations = %d, matches = %v", h.iterations, h.matches("secret"))
+	}
+	if _, err := HashPasswordIterations("secret", 0); err == nil {
+		t.Error("expected error for zero iterations")
+	}
+}
+
+func TestDummyPasswordHash(t *testing.T) {
+	h, err := dummyPasswordHash(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.iterations != 1000 || len(h.salt) != saltSize || len(h.sum) != sha256.Size {
+		t.Errorf("dummy hash: %d iterations, %d-byte salt, %d-byte sum", h.iterations, len(h.salt), len(h.sum))
+	}
+	if h.matches("") || h.matches("secret") {
+		t.Error("dummy hash matches a password")
+	}
+}
@@ -22,6 +22,8 @@ func main() {
 
 	// Создаем in-memory движок
 	inMemEngine := engine.NewInMemoryEngine(logger)
+	inMemEngine.StartSweeper(engine.DefaultSweepInterval)
+	defer inMemEngine.Close()
 
 	// Так как наш storage.Storage пока совпадает по интерфейсу с engine.Engine,
 	// мы можем его передать напрямую
@@ -33,10 +35,11 @@ func main() {
 	// Создаем слой compute
 	wl := &wal.NoOpWAL{}
 	cmp := compute.NewCompute(p, store, wl, logger)
+	session := cmp.NewSession()
 
 	// Запускаем цикл чтения команд из stdin
 	reader := bufio.NewReader(os.Stdin)
-	fmt.Println("In-memory KV store. Enter command (SET/GET/DEL) or type 'exit' to quit.")
+	fmt.Println("In-memory KV store. Enter command (SET/GET/DEL/EXPIRE/TTL/PERSIST) or type 'exit' to quit.")
 
 	for {
 		fmt.Print("> ")
@@ -57,12 +60,7 @@ func main() {
 			continue
 		}
 
-		result, err := cmp.Process(line)
-		if err != nil {
-			fmt.Println("ERROR:", err)
-		} else {
-			// Показываем результат (например, значение при GET или сообщение при SET/DEL)
-			fmt.Println(result)
-		}
+		// Показываем результат в том же виде, что и текстовый протокол сервера
+		fmt.Println(session.Process(line))
 	}
 }
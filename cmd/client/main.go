@@ -2,24 +2,50 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 
 	"go.uber.org/zap"
+	"imkvdb/tlsutil"
 )
 
 func main() {
 	address := flag.String("address", "127.0.0.1:4000", "Address of the DB server")
+	user := flag.String("user", "", "User name for AUTH (empty – \"default\")")
+	password := flag.String("password", "", "Password for AUTH (empty – do not authenticate)")
+	useTLS := flag.Bool("tls", false, "Connect over TLS")
+	tlsCA := flag.String("tls-ca", "", "CA file to verify the server certificate (default: system roots)")
+	tlsCert := flag.String("tls-cert", "", "Client certificate file for mutual TLS")
+	tlsKey := flag.String("tls-key", "", "Client private key file for mutual TLS")
+	tlsServerName := flag.String("tls-server-name", "", "Expected server name in its certificate (default: host of -address)")
 	flag.Parse()
 
 	logger, _ := zap.NewDevelopment()
 	defer logger.Sync()
 
 	// Подключаемся к серверу
-	conn, err := net.Dial("tcp", *address)
+	var conn net.Conn
+	var err error
+	if *useTLS || *tlsCA != "" || *tlsCert != "" {
+		tlsCfg, cfgErr := tlsutil.NewClientConfig(tlsutil.ClientOptions{
+			CAFile:     *tlsCA,
+			CertFile:   *tlsCert,
+			KeyFile:    *tlsKey,
+			ServerName: *tlsServerName,
+		})
+		if cfgErr != nil {
+			logger.Fatal("Failed to configure TLS", zap.Error(cfgErr))
+		}
+		conn, err = tls.Dial("tcp", *address, tlsCfg)
+	} else {
+		conn, err = net.Dial("tcp", *address)
+	}
 	if err != nil {
 		logger.Fatal("Failed to connect to server", zap.Error(err))
 	}
@@ -29,6 +55,13 @@ func main() {
 	reader := bufio.NewReader(os.Stdin)
 	serverReader := bufio.NewReader(conn)
 
+	// Вход до первой команды, если сервер требует AUTH
+	if *password != "" {
+		if err := authenticate(conn, serverReader, *user, *password); err != nil {
+			logger.Fatal("Failed to authenticate", zap.Error(err))
+		}
+	}
+
 	fmt.Println("Connected to DB server. Enter commands (SET/GET/DEL). Type 'exit' to quit.")
 
 	for {
@@ -60,6 +93,26 @@ func main() {
 			logger.Error("failed to read server response", zap.Error(err))
 			return
 		}
-		fmt.Printf("SERVER: %s", resp)
+		// Сервер уже присылает ответ в общем текстовом виде (compute.Result.String)
+		fmt.Print(resp)
+	}
+}
+
+// authenticate отправляет AUTH [user] password; ответ сервера, отличный от OK, – ошибка
+func authenticate(conn net.Conn, serverReader *bufio.Reader, user, password string) error {
+	args := []string{"AUTH", strconv.Quote(password)}
+	if user != "" {
+		args = []string{"AUTH", strconv.Quote(user), strconv.Quote(password)}
+	}
+	if _, err := conn.Write([]byte(strings.Join(args, " ") + "\n")); err != nil {
+		return err
+	}
+	resp, err := serverReader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if resp = strings.TrimSpace(resp); resp != "OK" {
+		return errors.New(resp)
 	}
+	return nil
 }
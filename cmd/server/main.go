@@ -1,35 +1,71 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"imkvdb/wal"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"go.uber.org/zap"
 
 	// Собственные пакеты (примерно так, либо относительные пути):
+	"imkvdb/acl"
 	"imkvdb/compute"
 	"imkvdb/compute/parser"
 	"imkvdb/config"
+	"imkvdb/metrics"
+	"imkvdb/replication"
+	"imkvdb/resp"
+	"imkvdb/snapshot"
 	"imkvdb/storage"
 	"imkvdb/storage/engine"
 	"imkvdb/tcpserver"
 )
 
+// Коды завершения процесса
+const (
+	exitOK    = 0 // остановлен сигналом, все данные сохранены
+	exitError = 1 // ошибка запуска или сохранения WAL
+	exitForce = 2 // за shutdown_timeout не все соединения завершились, их команды прерваны
+)
+
 func main() {
 	// Флаг для пути к файлу конфигурации
 	configPath := flag.String("config", "config.yaml", "Path to YAML config file (optional)")
+	hashPassword := flag.String("hash-password", "", "Print password_hash for auth.users and exit")
 	flag.Parse()
 
+	if *hashPassword != "" {
+		hash, err := acl.HashPassword(*hashPassword)
+		if err != nil {
+			fmt.Println("Failed to hash password:", err)
+			os.Exit(exitError)
+		}
+		fmt.Println(hash)
+		return
+	}
+
 	// Грузим конфигурацию (с дефолтами, если файл не найден)
 	cfg, err := config.LoadConfig(*configPath)
 	if err != nil {
 		fmt.Println("Failed to load config:", err)
-		os.Exit(1)
+		os.Exit(exitError)
 	}
 
+	// os.Exit не выполняет defer, поэтому вся работа – в run
+	os.Exit(run(cfg))
+}
+
+// run запускает сервер и работает до SIGINT/SIGTERM; возвращает код завершения.
+// Остановка идёт в обратном порядке запуска (defer): сначала серверы доделывают
+// начатые команды, затем останавливаются репликация и снимки, WAL сбрасывает
+// последний батч на диск и закрывается. Ошибки запуска тоже возвращаются кодом,
+// а не через logger.Fatal: os.Exit пропустил бы defer и WAL не был бы закрыт.
+func run(cfg config.Config) (code int) {
 	// Инициализируем логгер (zap)
 	logger, _ := zap.NewProduction() // или NewDevelopment()
 	defer logger.Sync()
@@ -37,53 +73,199 @@ func main() {
 	// Настраиваем уровень логирования, если нужно
 	// (В упрощённом примере пропущено; при желании можно zap.Config сконфигурировать)
 
-	// Создаем in-memory движок (другого типа пока нет)
-	var eng storage.Storage = engine.NewInMemoryEngine(logger)
+	// Создаем движок по engine.type (реализации регистрируются в engine.Register)
+	kvEngine, err := engine.New(cfg.Engine, logger)
+	if err != nil {
+		logger.Error("failed to create engine", zap.Error(err))
+		return exitError
+	}
+	defer kvEngine.Close()
+	engine.ExportMetrics(kvEngine)
+	var eng storage.Storage = kvEngine
 
 	// 4. Создаем parser
 	p := parser.NewParser()
 
+	// Реплика хранит данные только в памяти и получает их от лидера:
+	// свои WAL и снимки ей не нужны
+	isReplica := cfg.Replication.Role == config.RoleReplica
+	if isReplica && (cfg.WAL.Enabled || cfg.Snapshot.Enabled) {
+		logger.Warn("WAL and snapshots are ignored on a replica")
+		cfg.WAL.Enabled = false
+		cfg.Snapshot.Enabled = false
+	}
+
+	// Восстанавливаем данные: сначала последний снимок, затем записи WAL после его LSN
+	var lastLSN uint64
+	if cfg.Snapshot.Enabled {
+		lsn, entries, err := snapshot.Load(cfg.Snapshot.DataDirectory, logger)
+		if err != nil {
+			logger.Error("failed to load snapshot", zap.Error(err))
+			return exitError
+		}
+		kvEngine.Restore(entries)
+		lastLSN = lsn
+	}
+
 	// 5. Инициализируем compute
 	//    (но внутри compute проверяем, включен ли WAL, если да -> FileWAL, иначе NoOpWAL)
 	var wl wal.WAL
+	var truncater snapshot.Truncater
+	var replLog replication.Log
 	if cfg.WAL.Enabled {
-		w, err := wal.NewFileWAL(cfg.WAL, logger)
+		lastLSN, err = wal.ReplayWAL(cfg.WAL.DataDirectory, lastLSN, eng, logger)
 		if err != nil {
-			logger.Fatal("failed to create WAL", zap.Error(err))
+			logger.Error("failed to replay WAL", zap.Error(err))
+			return exitError
+		}
+		w, err := wal.NewFileWAL(cfg.WAL, lastLSN, logger)
+		if err != nil {
+			logger.Error("failed to create WAL", zap.Error(err))
+			return exitError
 		}
 		wl = w
-		// Восстанавливаем данные из WAL:
-		err = wal.ReplayWAL(cfg.WAL.DataDirectory, eng, logger)
+		truncater = w
+		replLog = w
+		// Close дописывает накопленный батч; без этого последние записи терялись бы
+		defer func() {
+			if err := w.Close(); err != nil {
+				logger.Error("failed to close WAL", zap.Error(err))
+				code = exitError
+			}
+		}()
 	} else {
 		wl = &wal.NoOpWAL{}
 	}
 
 	cmp := compute.NewCompute(p, eng, wl, logger)
+
+	// Лимит памяти. Реплика ключи не вытесняет: вытеснения лидера приходят ей
+	// через WAL как удаления, а свои разошлись бы с данными лидера.
+	if cfg.Memory.MaxMemory != "" && isReplica {
+		logger.Warn("memory.max_memory is ignored on a replica")
+	} else {
+		evictor, err := engine.NewEvictor(kvEngine, cfg.Memory, logger)
+		if err != nil {
+			logger.Error("failed to configure memory limit", zap.Error(err))
+			return exitError
+		}
+		if evictor != nil {
+			cmp.SetEvictor(evictor)
+		}
+	}
+
+	if cfg.Snapshot.Enabled {
+		snapshots := snapshot.NewManager(cfg.Snapshot, cmp, truncater, logger)
+		snapshots.Start()
+		defer snapshots.Stop()
+	}
+
+	// Пользователи и права (auth.users); без них аутентификация выключена
+	access, err := acl.New(cfg.Auth, logger)
+	if err != nil {
+		logger.Error("failed to load auth config", zap.Error(err))
+		return exitError
+	}
+	if access != nil {
+		defer access.Close()
+	}
+
+	// Репликация: мастер отдаёт записи своего WAL, реплика их забирает
+	if isReplica {
+		follower := replication.NewFollower(cfg.Replication, eng, logger)
+		// Реплика ходит к лидеру с теми же сертификатами, что и у своих клиентов
+		if cfg.Network.TLS.Enabled() {
+			if err := follower.SetTLS(cfg.Network.TLS); err != nil {
+				logger.Error("failed to configure replication TLS", zap.Error(err))
+				return exitError
+			}
+		} else if cfg.Replication.User != "" {
+			logger.Warn("replication.password is sent to the leader in plain text without network.tls")
+		}
+		follower.Start()
+		defer follower.Stop()
+	} else if cfg.Replication.ListenAddress != "" {
+		if replLog == nil {
+			logger.Error("replication requires wal.enabled=true on the master")
+			return exitError
+		}
+		leader := replication.NewLeader(cfg.Replication, cfg.WAL.DataDirectory, replLog, cmp, logger)
+		leader.SetTLS(cfg.Network.TLS)
+		// С auth.users реплики входят пользователем с категорией admin (replication.user)
+		leader.SetACL(access)
+		if err := leader.Start(); err != nil {
+			logger.Error("failed to start replication leader", zap.Error(err))
+			return exitError
+		}
+		defer leader.Stop()
+	}
+
+	// Сигналы ловим до запуска серверов, чтобы не потерять ранний SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Создаем и запускаем TCP-сервер
 	srv := tcpserver.NewTCPServer(cfg, cmp, logger)
+	srv.SetACL(access)
 	if err := srv.Start(); err != nil {
-		logger.Fatal("Failed to start TCP server", zap.Error(err))
+		logger.Error("Failed to start TCP server", zap.Error(err))
+		return exitError
 	}
+	servers := []server{srv}
 
-	// Чтобы сервер не выходил сразу — читаем команду "exit" из stdin
-	waitForExit(logger)
+	// Фронтенд протокола Redis (для redis-cli, go-redis и т.п.)
+	if cfg.Network.RESPAddress != "" {
+		respSrv := resp.NewServer(cfg, cmp, logger)
+		respSrv.SetACL(access)
+		if err := respSrv.Start(); err != nil {
+			logger.Error("Failed to start RESP server", zap.Error(err))
+			shutdown(servers, cfg.Network.ShutdownTimeout)
+			return exitError
+		}
+		servers = append(servers, respSrv)
+	}
+
+	// Метрики для Prometheus
+	if cfg.Metrics.Address != "" {
+		metricsSrv := metrics.NewServer(cfg.Metrics.Address, nil, logger)
+		if err := metricsSrv.Start(); err != nil {
+			logger.Error("Failed to start metrics server", zap.Error(err))
+			shutdown(servers, cfg.Network.ShutdownTimeout)
+			return exitError
+		}
+		servers = append(servers, metricsSrv)
+	}
+
+	<-ctx.Done()
+	// Повторный сигнал завершит процесс сразу, не дожидаясь плавной остановки
+	stop()
+	logger.Info("Stopping server...", zap.Duration("grace_period", cfg.Network.ShutdownTimeout))
 
-	// Останавливаем сервер
-	srv.Stop()
+	if !shutdown(servers, cfg.Network.ShutdownTimeout) {
+		return exitForce
+	}
+	return exitOK
 }
 
-// waitForExit — простой способ «подождать» до ввода "exit" в консоль
-func waitForExit(logger *zap.Logger) {
-	for {
-		var cmd string
-		fmt.Print("Type 'exit' to stop server: ")
-		_, err := fmt.Scanln(&cmd)
-		if err != nil {
-			logger.Error("Failed to read exit command", zap.Error(err))
-		}
-		if strings.ToLower(cmd) == "exit" {
-			logger.Info("Stopping server...")
-			return
+// server – запущенный сервер, который останавливается плавно
+type server interface {
+	Shutdown(ctx context.Context) error
+}
+
+// shutdown останавливает серверы параллельно, с общим сроком timeout;
+// false – не все успели завершить начатые команды
+func shutdown(servers []server, timeout time.Duration) bool {
+	graceCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func() { errs <- srv.Shutdown(graceCtx) }()
+	}
+	ok := true
+	for range servers {
+		if err := <-errs; err != nil {
+			ok = false
 		}
 	}
+	return ok
 }
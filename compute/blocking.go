@@ -0,0 +1,114 @@
+package compute
+
+import (
+	"sync"
+	"time"
+
+	"imkvdb/compute/parser"
+)
+
+// BlockWatcher следит за соединением, пока блокирующая команда (BLPOP) держит его в ожидании.
+// Возвращает канал, закрытие которого прерывает ожидание (клиент отключился, сервер
+// останавливается), и функцию stop, которую сессия вызывает перед ответом клиенту.
+type BlockWatcher func() (cancel <-chan struct{}, stop func())
+
+// SetBlockWatcher задаёт наблюдение за соединением на время BLPOP.
+// Без него ожидание прерывается только таймаутом команды или появлением данных.
+func (s *Session) SetBlockWatcher(w BlockWatcher) {
+	s.watcher = w
+}
+
+// waiters – ожидающие BLPOP по ключам. Каждому ожидающему соответствует канал
+// с буфером 1: сигнал, пришедший между попыткой забрать элемент и засыпанием, не теряется.
+type waiters struct {
+	mu   sync.Mutex
+	keys map[string]map[chan struct{}]struct{}
+}
+
+// add регистрирует ожидание ключей
+func (w *waiters) add(keys []string) chan struct{} {
+	ch := make(chan struct{}, 1)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.keys == nil {
+		w.keys = make(map[string]map[chan struct{}]struct{})
+	}
+	for _, key := range keys {
+		if w.keys[key] == nil {
+			w.keys[key] = make(map[chan struct{}]struct{})
+		}
+		w.keys[key][ch] = struct{}{}
+	}
+	return ch
+}
+
+// remove снимает ожидание
+func (w *waiters) remove(ch chan struct{}, keys []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, key := range keys {
+		delete(w.keys[key], ch)
+		if len(w.keys[key]) == 0 {
+			delete(w.keys, key)
+		}
+	}
+}
+
+// notify будит всех, кто ждёт ключ. Элемент достанется тому, кто первым возьмёт writeMu,
+// остальные снова уснут.
+func (w *waiters) notify(key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for ch := range w.keys[key] {
+		select {
+		case ch <- struct{}{}:
+		default: // сигнал уже ждёт обработки
+		}
+	}
+}
+
+// wakeBlocked будит ожидающих после команды, которая могла добавить элементы в список
+func (c *compute) wakeBlocked(cmd parser.Command) {
+	switch cmd.Type {
+	case parser.LPUSH, parser.RPUSH:
+		c.blocked.notify(cmd.Key)
+	}
+}
+
+// blockingPop – BLPOP вне транзакции: забирает элемент сразу, а если все списки пусты,
+// ждёт вставки в любой из них, таймаута или отмены от watcher
+func (c *compute) blockingPop(cmd parser.Command, watcher BlockWatcher) Result {
+	// Регистрируемся до первой попытки: вставка между попыткой и ожиданием не потеряется
+	ch := c.blocked.add(cmd.Keys)
+	defer c.blocked.remove(ch, cmd.Keys)
+
+	var timeout <-chan time.Time
+	if cmd.Timeout > 0 {
+		timer := time.NewTimer(cmd.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var (
+		cancel <-chan struct{}
+		parked bool
+	)
+	for {
+		res := c.processCommand(cmd)
+		if res.Kind != KindNil {
+			return res
+		}
+		if !parked && watcher != nil {
+			var stop func()
+			cancel, stop = watcher()
+			defer stop()
+		}
+		parked = true
+		select {
+		case <-ch:
+		case <-timeout:
+			return Nil()
+		case <-cancel:
+			return Nil()
+		}
+	}
+}
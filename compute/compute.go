@@ -1,7 +1,13 @@
 package compute
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 
 	"go.uber.org/zap"
 	"imkvdb/compute/parser"
@@ -11,8 +17,23 @@ import (
 
 // Compute – интерфейс слоя обработки команд
 type Compute interface {
-	Process(input string) (string, error)
-	ProcessReplay(cmd parser.Command) (string, error) // для восстановления
+	Process(input string) Result
+	ProcessReplay(cmd parser.Command) Result // для восстановления
+	// Snapshot возвращает LSN последней записи WAL и согласованную с ним копию данных.
+	// После ошибки WAL снимок не делается: в памяти есть изменения, которых нет в WAL.
+	Snapshot() (uint64, []storage.Entry, error)
+	// NewSession создаёт состояние клиентского соединения (для MULTI/EXEC)
+	NewSession() *Session
+	// SetEvictor включает лимит памяти: перед записями ev освобождает память (см. Evictor).
+	// Вызывается до начала обработки команд.
+	SetEvictor(ev Evictor)
+}
+
+// Evictor освобождает память хранилища, когда она превышает лимит (см. engine.Evictor)
+type Evictor interface {
+	// Evict удаляет ключи, пока память выше лимита, и возвращает их.
+	// storage.ErrOOM – освободить память нельзя; запись, которая могла бы её занять, отклоняется.
+	Evict() ([]string, error)
 }
 
 type compute struct {
@@ -20,6 +41,20 @@ type compute struct {
 	store  storage.Storage
 	logger *zap.Logger
 	wal    wal.WAL
+
+	// writeMu сериализует модифицирующие команды: применение к storage и постановка
+	// записи в WAL идут под одной блокировкой, поэтому порядок LSN совпадает
+	// с порядком применения, а снимок под writeMu соответствует ровно LastLSN
+	writeMu sync.Mutex
+
+	// blocked – соединения, ждущие данных в списках (BLPOP)
+	blocked waiters
+
+	// evictor – лимит памяти; nil – без лимита
+	evictor Evictor
+
+	// walFailed – ошибка WAL уже обнаружена и записана в лог. Под writeMu.
+	walFailed bool
 }
 
 func NewCompute(p parser.Parser, s storage.Storage, w wal.WAL, l *zap.Logger) Compute {
@@ -32,55 +67,711 @@ func NewCompute(p parser.Parser, s storage.Storage, w wal.WAL, l *zap.Logger) Co
 }
 
 // Process – метод, который выполняет парсинг и обработку команды, возвращая результат
-func (c *compute) Process(input string) (string, error) {
+func (c *compute) Process(input string) Result {
+	start := time.Now()
 	cmd, err := c.parser.Parse(input)
 	if err != nil {
 		c.logger.Error("failed to parse command", zap.Error(err))
-		return "", err
+		parseErrors.Inc()
+		return ErrorResult(newError(CodeSyntax, err.Error()))
+	}
+	switch cmd.Type {
+	case parser.MULTI, parser.EXEC, parser.DISCARD, parser.WATCH, parser.UNWATCH:
+		return ErrorResult(errors.New("MULTI/EXEC/DISCARD/WATCH require a client session"))
+	case parser.AUTH:
+		return ErrorResult(errors.New("AUTH requires a client session"))
 	}
-	// Модифицирующие операции -> WAL
-	if cmd.Type == parser.SET || cmd.Type == parser.DEL {
-		// 1. Записываем в WAL
-		op := wal.Record{
-			Op:    wal.OpSet,
-			Key:   cmd.Key,
-			Value: cmd.Value,
+	return observed(cmd, start, c.startCommand(cmd)).Wait()
+}
+
+// processCommand – выполнение одной разобранной команды вне транзакции
+func (c *compute) processCommand(cmd parser.Command) Result {
+	return c.startCommand(cmd).Wait()
+}
+
+// startCommand применяет команду, не дожидаясь fsync её записи WAL (см. Pending)
+func (c *compute) startCommand(cmd parser.Command) Pending {
+	// Относительный TTL переводим в абсолютное время один раз,
+	// чтобы в WAL и в engine попало одно и то же значение
+	now := time.Now()
+
+	if !IsWrite(cmd) {
+		// Читающие команды идут мимо WAL и writeMu
+		result, _, err := c.apply(cmd, now)
+		if err != nil {
+			return Done(ErrorResult(err))
 		}
-		if cmd.Type == parser.DEL {
-			op.Op = wal.OpDel
+		return Done(result)
+	}
+
+	// Модифицирующие операции: 1. применяем к engine, 2. ставим в очередь WAL.
+	// Запись в WAL строится по результату применения (CAS, счётчики, версии), поэтому
+	// отменить изменение при ошибке WAL нечем: она необратима (см. checkWAL).
+	c.writeMu.Lock()
+	if err := c.checkWAL(); err != nil {
+		c.writeMu.Unlock()
+		return Done(ErrorResult(err))
+	}
+	var evicted <-chan error
+	if mayGrow(cmd) {
+		var err error
+		if evicted, err = c.freeMemory(); err != nil {
+			c.writeMu.Unlock()
+			return Pending{result: ErrorResult(err), done: evicted}
+		}
+	}
+	result, changed, err := c.apply(cmd, now)
+	if err != nil || !changed {
+		// Ничего не изменилось (DEL отсутствующего ключа, неудачный CAS) – писать в WAL нечего,
+		// но ответ ждёт записи вытеснений
+		c.writeMu.Unlock()
+		if err != nil {
+			return Pending{result: ErrorResult(err), done: evicted}
 		}
+		return Pending{result: result, done: evicted}
+	}
+	// Запись команды идёт после записи вытеснений, а WAL пишет записи по порядку и после
+	// ошибки не пишет следующие: fsync записи команды подтверждает и вытеснения
+	done := c.wal.Append(walRecord(c.store, cmd, result, now))
+	c.writeMu.Unlock()
+	c.wakeBlocked(cmd)
 
-		if err := c.wal.WriteAndWait(op); err != nil {
-			return "", fmt.Errorf("failed to write WAL: %w", err)
+	// 3. Отвечаем клиенту только после fsync. Ждёт вызывающий (Pending.Wait) – уже без блокировки,
+	// чтобы следующие команды попадали в тот же батч
+	return Pending{result: result, done: done}
+}
+
+// apply применяет команду к storage. Команды над несколькими ключами (SINTER, SUNIONSTORE, MSET и т.п.)
+// выполняются под store.Atomic, чтобы видеть и менять данные одним согласованным срезом.
+func (c *compute) apply(cmd parser.Command, now time.Time) (result Result, changed bool, err error) {
+	if !isMultiKey(cmd) {
+		return c.applyCommand(c.store, cmd, now)
+	}
+	_ = c.store.Atomic(func(tx storage.Tx) error {
+		result, changed, err = c.applyCommand(tx, cmd, now)
+		return nil
+	})
+	return result, changed, err
+}
+
+// exec атомарно выполняет очередь команд транзакции: все команды применяются
+// под одной блокировкой storage, а их изменения пишутся в WAL одной записью OpBatch.
+// Ошибка отдельной команды не откатывает остальные (как в Redis) и попадает в её результат.
+// Если версия какого-либо ключа из watched изменилась, транзакция не выполняется: ответ – nil.
+// Как и startCommand, fsync записи не ждёт.
+func (c *compute) exec(queue []parser.Command, watched map[string]uint64) Pending {
+	now := time.Now()
+	results := make([]Result, len(queue))
+	var recs []wal.Record
+
+	c.writeMu.Lock()
+	if err := c.checkWAL(); err != nil {
+		c.writeMu.Unlock()
+		return Done(ErrorResult(err))
+	}
+	var evicted <-chan error
+	for _, cmd := range queue {
+		if mayGrow(cmd) {
+			var err error
+			if evicted, err = c.freeMemory(); err != nil {
+				c.writeMu.Unlock()
+				return Pending{result: ErrorResult(err), done: evicted}
+			}
+			break
+		}
+	}
+	err := c.store.Atomic(func(tx storage.Tx) error {
+		for key, version := range watched {
+			if tx.Version(key) != version {
+				return errWatchedKeyChanged
+			}
+		}
+		for i, cmd := range queue {
+			res, changed, err := c.applyCommand(tx, cmd, now)
+			if err != nil {
+				results[i] = ErrorResult(err)
+				continue
+			}
+			results[i] = res
+			if changed {
+				recs = append(recs, walRecord(tx, cmd, res, now))
+			}
 		}
+		return nil
+	})
+	done := evicted
+	if err == nil && len(recs) > 0 {
+		done = c.wal.Append(wal.Record{Op: wal.OpBatch, Batch: recs})
+	}
+	c.writeMu.Unlock()
+	if err == nil {
+		for _, cmd := range queue {
+			c.wakeBlocked(cmd)
+		}
+	}
+
+	if errors.Is(err, errWatchedKeyChanged) {
+		return Pending{result: Nil(), done: done}
+	}
+	if err != nil {
+		return Pending{result: ErrorResult(err), done: done}
+	}
+	return Pending{result: Array(results), done: done}
+}
+
+// errWatchedKeyChanged – EXEC отменён: один из ключей WATCH изменился
+var errWatchedKeyChanged = errors.New("watched key changed")
+
+// versions возвращает текущие версии ключей (для WATCH)
+func (c *compute) versions(keys []string) map[string]uint64 {
+	res := make(map[string]uint64, len(keys))
+	for _, key := range keys {
+		res[key] = c.store.Version(key)
+	}
+	return res
+}
+
+func (c *compute) NewSession() *Session {
+	return &Session{c: c}
+}
+
+func (c *compute) SetEvictor(ev Evictor) {
+	c.evictor = ev
+}
+
+// freeMemory вытесняет ключи, если память выше лимита, и пишет их в WAL как удаления –
+// иначе после реплея или на реплике вытесненные ключи остались бы. Возвращает канал fsync
+// записи вытеснений (nil – вытеснять не понадобилось): ответ клиенту ждёт и его.
+// Вызывается под writeMu.
+func (c *compute) freeMemory() (<-chan error, error) {
+	if c.evictor == nil {
+		return nil, nil
+	}
+	keys, err := c.evictor.Evict()
+	if len(keys) == 0 {
+		return nil, err
+	}
+	rec := wal.Record{Op: wal.OpBatch, Batch: make([]wal.Record, 0, len(keys))}
+	for _, key := range keys {
+		rec.Batch = append(rec.Batch, wal.Record{Op: wal.OpDel, Key: key})
 	}
-	// 2. Пишем в engine
-	return c.applyCommand(cmd)
+	return c.wal.Append(rec), err
 }
 
-func (c *compute) ProcessReplay(cmd parser.Command) (string, error) {
+// checkWAL – можно ли принимать записи. После ошибки WAL в памяти остаются изменения,
+// которых нет на диске, и откатить их нельзя, поэтому сервер останавливает запись
+// (fail-stop): все модифицирующие команды и снимки отклоняются до перезапуска,
+// который восстановит данные из снимка и WAL. Чтение продолжает работать.
+// Вызывается под writeMu.
+func (c *compute) checkWAL() error {
+	err := c.wal.Err()
+	if err == nil {
+		return nil
+	}
+	if !c.walFailed {
+		c.walFailed = true
+		c.logger.Error("WAL is broken, writes are disabled until restart", zap.Error(err))
+	}
+	return newError(CodeMisconf, "Errors writing to the WAL, writes are disabled until restart: "+err.Error())
+}
+
+func (c *compute) Snapshot() (uint64, []storage.Entry, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.checkWAL(); err != nil {
+		return 0, nil, err
+	}
+	return c.wal.LastLSN(), c.store.Dump(), nil
+}
+
+func (c *compute) ProcessReplay(cmd parser.Command) Result {
 	// вызывается при реплее WAL (не нужно записывать в WAL заново!)
-	return c.applyCommand(cmd)
+	result, _, err := c.applyCommand(c.store, cmd, time.Now())
+	if err != nil {
+		return ErrorResult(err)
+	}
+	return result
+}
+
+// IsWrite – команда изменяет данные и должна попасть в WAL
+func IsWrite(cmd parser.Command) bool {
+	switch cmd.Type {
+	case parser.SET, parser.DEL, parser.EXPIRE, parser.PERSIST, parser.CAS,
+		parser.HSET, parser.HDEL,
+		parser.LPUSH, parser.RPUSH, parser.LPOP, parser.RPOP, parser.BLPOP,
+		parser.ZADD, parser.ZREM,
+		parser.SADD, parser.SREM, parser.SINTERSTORE, parser.SUNIONSTORE, parser.SDIFFSTORE,
+		parser.INCR, parser.DECR, parser.INCRBY, parser.INCRBYFLOAT,
+		parser.MSET, parser.MDEL:
+		return true
+	default:
+		return false
+	}
+}
+
+// mayGrow – модифицирующая команда может занять память. При достижении лимита перед ней
+// вытесняются ключи, а при noeviction она отклоняется; удаления выполняются всегда.
+func mayGrow(cmd parser.Command) bool {
+	switch cmd.Type {
+	case parser.DEL, parser.MDEL, parser.EXPIRE, parser.PERSIST,
+		parser.HDEL, parser.LPOP, parser.RPOP, parser.BLPOP, parser.ZREM, parser.SREM:
+		return false
+	default:
+		return IsWrite(cmd)
+	}
+}
+
+// isMultiKey – команда читает или меняет несколько ключей сразу
+func isMultiKey(cmd parser.Command) bool {
+	switch cmd.Type {
+	case parser.SINTER, parser.SUNION, parser.SDIFF,
+		parser.SINTERSTORE, parser.SUNIONSTORE, parser.SDIFFSTORE,
+		parser.MSET, parser.MGET, parser.MDEL:
+		return true
+	default:
+		return false
+	}
+}
+
+// walRecord – запись WAL для уже применённой к tx модифицирующей команды с ответом res.
+// В записи фиксируется результат (CAS и счётчики пишутся как SET итогового значения,
+// BLPOP – как LPOP найденного ключа, *STORE – как удаление приёмника и OpSAdd с его новым
+// содержимым, MSET/MDEL – группой OpSet/OpDel) и новая версия ключа, поэтому реплей
+// не зависит от порядка приращений.
+func walRecord(tx storage.Tx, cmd parser.Command, res Result, now time.Time) wal.Record {
+	key := cmd.Key
+	if cmd.Type == parser.BLPOP {
+		key = res.Items[0].Str
+	}
+	rec := wal.Record{Key: key, Version: tx.Version(key)}
+	switch cmd.Type {
+	case parser.SET, parser.CAS:
+		rec.Op = wal.OpSet
+		rec.Value = cmd.Value
+		if cmd.Expire > 0 {
+			rec.ExpireAt = expireAt(now, cmd.Expire).UnixMilli()
+		}
+	case parser.INCR, parser.DECR, parser.INCRBY, parser.INCRBYFLOAT:
+		rec.Op = wal.OpSet
+		rec.Value, _, _ = tx.Get(key)
+		// Счётчик сохраняет TTL ключа, а OpSet без времени истечения сбросил бы его
+		if at, _ := tx.ExpireTime(key); !at.IsZero() {
+			rec.ExpireAt = at.UnixMilli()
+		}
+	case parser.DEL:
+		rec.Op = wal.OpDel
+	case parser.EXPIRE:
+		rec.Op = wal.OpExpire
+		rec.ExpireAt = expireAt(now, cmd.Expire).UnixMilli()
+	case parser.PERSIST:
+		rec.Op = wal.OpPersist
+	case parser.HSET:
+		rec.Op = wal.OpHSet
+		rec.Args = cmd.Args
+	case parser.HDEL:
+		rec.Op = wal.OpHDel
+		rec.Args = cmd.Args
+	case parser.LPUSH:
+		rec.Op = wal.OpLPush
+		rec.Args = cmd.Args
+	case parser.RPUSH:
+		rec.Op = wal.OpRPush
+		rec.Args = cmd.Args
+	case parser.LPOP, parser.BLPOP:
+		rec.Op = wal.OpLPop
+	case parser.RPOP:
+		rec.Op = wal.OpRPop
+	case parser.ZADD:
+		rec.Op = wal.OpZAdd
+		rec.Args = make([]string, 0, 2*len(cmd.Members))
+		for _, m := range cmd.Members {
+			rec.Args = append(rec.Args, strconv.FormatFloat(m.Score, 'g', -1, 64), m.Member)
+		}
+	case parser.ZREM:
+		rec.Op = wal.OpZRem
+		rec.Args = cmd.Args
+	case parser.SADD:
+		rec.Op = wal.OpSAdd
+		rec.Args = cmd.Args
+	case parser.SREM:
+		rec.Op = wal.OpSRem
+		rec.Args = cmd.Args
+	case parser.MSET:
+		rec = wal.Record{Op: wal.OpBatch, Batch: make([]wal.Record, 0, len(cmd.Args)/2)}
+		for i := 0; i+1 < len(cmd.Args); i += 2 {
+			rec.Batch = append(rec.Batch, wal.Record{
+				Op: wal.OpSet, Key: cmd.Args[i], Value: cmd.Args[i+1], Version: tx.Version(cmd.Args[i]),
+			})
+		}
+	case parser.MDEL:
+		// Удаление отсутствующего ключа при реплее ничего не меняет,
+		// поэтому в группу попадают все ключи команды
+		rec = wal.Record{Op: wal.OpBatch, Batch: make([]wal.Record, 0, len(cmd.Keys))}
+		for _, k := range cmd.Keys {
+			rec.Batch = append(rec.Batch, wal.Record{Op: wal.OpDel, Key: k})
+		}
+	case parser.SINTERSTORE, parser.SUNIONSTORE, parser.SDIFFSTORE:
+		rec = wal.Record{Op: wal.OpBatch, Batch: []wal.Record{{Op: wal.OpDel, Key: key}}}
+		if members, _ := tx.SMembers(key); len(members) > 0 {
+			rec.Batch = append(rec.Batch, wal.Record{Op: wal.OpSAdd, Key: key, Args: members, Version: tx.Version(key)})
+		}
+	}
+	return rec
 }
 
-func (c *compute) applyCommand(cmd parser.Command) (string, error) {
+// applyCommand применяет команду к tx: к самому storage или к представлению внутри Atomic.
+// changed=true – данные изменились и команду нужно записать в WAL.
+func (c *compute) applyCommand(tx storage.Tx, cmd parser.Command, now time.Time) (result Result, changed bool, err error) {
 	switch cmd.Type {
 	case parser.SET:
-		err := c.store.Set(cmd.Key, cmd.Value)
-		return "OK: SET", err
+		if err := tx.Set(cmd.Key, cmd.Value); err != nil {
+			return Result{}, false, err
+		}
+		if cmd.Expire > 0 {
+			tx.Expire(cmd.Key, expireAt(now, cmd.Expire))
+		}
+		return OK(), true, nil
 	case parser.DEL:
-		ok := c.store.Del(cmd.Key)
+		// Число удалённых ключей
+		ok := tx.Del(cmd.Key)
+		return Bool(ok), ok, nil
+	case parser.GET:
+		val, ok, err := tx.Get(cmd.Key)
+		if err != nil {
+			return Result{}, false, err
+		}
 		if !ok {
-			return "key not found", nil
+			return Nil(), false, nil
+		}
+		return String(val), false, nil
+	case parser.EXPIRE:
+		ok := tx.Expire(cmd.Key, expireAt(now, cmd.Expire))
+		return Bool(ok), ok, nil
+	case parser.PERSIST:
+		// 0 – ключа нет или у него не было TTL
+		ok := tx.Persist(cmd.Key)
+		return Bool(ok), ok, nil
+	case parser.TTL:
+		// Как в Redis: -2 – ключа нет, -1 – ключ без TTL, иначе оставшиеся секунды
+		at, ok := tx.ExpireTime(cmd.Key)
+		if !ok {
+			return Integer(-2), false, nil
+		}
+		if at.IsZero() {
+			return Integer(-1), false, nil
+		}
+		left := at.Sub(now)
+		return Integer(int64((left + time.Second/2) / time.Second)), false, nil
+	case parser.CAS:
+		// Сравнение и запись атомарны: модифицирующие команды выполняются под writeMu.
+		// 1 – значение заменено, 0 – текущее значение не совпало с ожидаемым.
+		cur, ok, err := tx.Get(cmd.Key)
+		if err != nil {
+			return Result{}, false, err
+		}
+		if !ok {
+			return Result{}, false, newError(CodeNotFound, "key not found")
+		}
+		if cur != cmd.Expected {
+			return Integer(0), false, nil
+		}
+		if err := tx.Set(cmd.Key, cmd.Value); err != nil {
+			return Result{}, false, err
+		}
+		return Integer(1), true, nil
+	case parser.INCR, parser.DECR, parser.INCRBY:
+		n, err := incrBy(tx, cmd.Key, cmd.Increment)
+		if err != nil {
+			return Result{}, false, err
+		}
+		return Integer(n), true, nil
+	case parser.INCRBYFLOAT:
+		val, err := incrByFloat(tx, cmd.Key, cmd.FloatIncrement)
+		if err != nil {
+			return Result{}, false, err
+		}
+		return String(val), true, nil
+	case parser.MSET:
+		for i := 0; i+1 < len(cmd.Args); i += 2 {
+			if err := tx.Set(cmd.Args[i], cmd.Args[i+1]); err != nil {
+				return Result{}, false, err
+			}
+		}
+		return OK(), true, nil
+	case parser.MGET:
+		// Как в Redis: отсутствующий ключ и значение другого типа – nil
+		values := make([]Result, len(cmd.Keys))
+		for i, key := range cmd.Keys {
+			val, ok, err := tx.Get(key)
+			if err != nil || !ok {
+				values[i] = Nil()
+				continue
+			}
+			values[i] = String(val)
+		}
+		return Array(values), false, nil
+	case parser.MDEL:
+		// Число удалённых ключей
+		deleted := 0
+		for _, key := range cmd.Keys {
+			if tx.Del(key) {
+				deleted++
+			}
+		}
+		return Integer(int64(deleted)), deleted > 0, nil
+	case parser.SCAN:
+		// [следующий курсор, ключи порции]. MATCH фильтрует уже выбранную порцию,
+		// поэтому она может оказаться пустой при ненулевом курсоре
+		next, keys := tx.Scan(cmd.Cursor, cmd.Count)
+		return Array([]Result{
+			String(strconv.FormatUint(next, 10)),
+			Strings(matchKeys(keys, cmd.Pattern)),
+		}), false, nil
+	case parser.KEYS:
+		_, keys := tx.Scan(0, -1)
+		keys = matchKeys(keys, cmd.Pattern)
+		sort.Strings(keys)
+		return Strings(keys), false, nil
+	case parser.DBSIZE:
+		return Integer(int64(tx.Len())), false, nil
+	case parser.RANGE, parser.PREFIX:
+		start, end := cmd.Key, cmd.End
+		if cmd.Type == parser.PREFIX {
+			end = prefixEnd(cmd.Key)
+		}
+		keys, err := tx.Range(start, end, cmd.Count, cmd.Reverse)
+		if err != nil {
+			return Result{}, false, err
+		}
+		return Strings(keys), false, nil
+	case parser.GETVER:
+		version := tx.Version(cmd.Key)
+		if version == 0 {
+			return Nil(), false, nil
+		}
+		return Integer(int64(version)), false, nil
+	case parser.HSET:
+		// Число новых полей; перезапись существующих тоже изменение
+		added, err := tx.HSet(cmd.Key, cmd.Args)
+		if err != nil {
+			return Result{}, false, err
+		}
+		return Integer(int64(added)), true, nil
+	case parser.HGET:
+		val, ok, err := tx.HGet(cmd.Key, cmd.Field)
+		if err != nil {
+			return Result{}, false, err
+		}
+		if !ok {
+			return Nil(), false, nil
+		}
+		return String(val), false, nil
+	case parser.HDEL:
+		removed, err := tx.HDel(cmd.Key, cmd.Args)
+		if err != nil {
+			return Result{}, false, err
+		}
+		return Integer(int64(removed)), removed > 0, nil
+	case parser.HGETALL:
+		// Пары поле/значение подряд, как в Redis
+		pairs, err := tx.HGetAll(cmd.Key)
+		if err != nil {
+			return Result{}, false, err
+		}
+		return Strings(pairs), false, nil
+	case parser.HLEN:
+		n, err := tx.HLen(cmd.Key)
+		if err != nil {
+			return Result{}, false, err
+		}
+		return Integer(int64(n)), false, nil
+	case parser.LPUSH, parser.RPUSH:
+		// Длина списка после вставки
+		push := tx.LPush
+		if cmd.Type == parser.RPUSH {
+			push = tx.RPush
+		}
+		n, err := push(cmd.Key, cmd.Args)
+		if err != nil {
+			return Result{}, false, err
+		}
+		return Integer(int64(n)), true, nil
+	case parser.LPOP, parser.RPOP:
+		pop := tx.LPop
+		if cmd.Type == parser.RPOP {
+			pop = tx.RPop
+		}
+		val, ok, err := pop(cmd.Key)
+		if err != nil {
+			return Result{}, false, err
 		}
-		return "OK: DEL", nil
-	case parser.GET:
-		val, ok := c.store.Get(cmd.Key)
 		if !ok {
-			return "", fmt.Errorf("key not found")
+			return Nil(), false, nil
+		}
+		return String(val), true, nil
+	case parser.BLPOP:
+		// Здесь – без ожидания (так BLPOP работает и внутри MULTI): элемент из первого
+		// непустого списка в виде [ключ, значение] или nil. Ждёт данных blockingPop.
+		for _, key := range cmd.Keys {
+			val, ok, err := tx.LPop(key)
+			if err != nil {
+				return Result{}, false, err
+			}
+			if ok {
+				return Strings([]string{key, val}), true, nil
+			}
+		}
+		return Nil(), false, nil
+	case parser.LRANGE:
+		items, err := tx.LRange(cmd.Key, cmd.Start, cmd.Stop)
+		if err != nil {
+			return Result{}, false, err
+		}
+		return Strings(items), false, nil
+	case parser.LLEN:
+		n, err := tx.LLen(cmd.Key)
+		if err != nil {
+			return Result{}, false, err
+		}
+		return Integer(int64(n)), false, nil
+	case parser.ZADD:
+		// Число новых элементов; обновление счёта тоже изменение
+		added, err := tx.ZAdd(cmd.Key, cmd.Members)
+		if err != nil {
+			return Result{}, false, err
+		}
+		return Integer(int64(added)), true, nil
+	case parser.ZREM:
+		removed, err := tx.ZRem(cmd.Key, cmd.Args)
+		if err != nil {
+			return Result{}, false, err
+		}
+		return Integer(int64(removed)), removed > 0, nil
+	case parser.ZRANGE:
+		members, err := tx.ZRange(cmd.Key, cmd.Start, cmd.Stop)
+		if err != nil {
+			return Result{}, false, err
+		}
+		return scoredMembers(members, cmd.WithScores), false, nil
+	case parser.ZRANGEBYSCORE:
+		members, err := tx.ZRangeByScore(cmd.Key, cmd.Min, cmd.Max, cmd.Offset, cmd.Count)
+		if err != nil {
+			return Result{}, false, err
+		}
+		return scoredMembers(members, cmd.WithScores), false, nil
+	case parser.ZRANK:
+		rank, ok, err := tx.ZRank(cmd.Key, cmd.Field)
+		if err != nil {
+			return Result{}, false, err
+		}
+		if !ok {
+			return Nil(), false, nil
+		}
+		return Integer(int64(rank)), false, nil
+	case parser.SADD:
+		added, err := tx.SAdd(cmd.Key, cmd.Args)
+		if err != nil {
+			return Result{}, false, err
+		}
+		return Integer(int64(added)), added > 0, nil
+	case parser.SREM:
+		removed, err := tx.SRem(cmd.Key, cmd.Args)
+		if err != nil {
+			return Result{}, false, err
+		}
+		return Integer(int64(removed)), removed > 0, nil
+	case parser.SMEMBERS:
+		members, err := tx.SMembers(cmd.Key)
+		if err != nil {
+			return Result{}, false, err
+		}
+		return Strings(members), false, nil
+	case parser.SISMEMBER:
+		ok, err := tx.SIsMember(cmd.Key, cmd.Field)
+		if err != nil {
+			return Result{}, false, err
+		}
+		return Bool(ok), false, nil
+	case parser.SINTER, parser.SUNION, parser.SDIFF:
+		members, err := combineSets(tx, cmd.Type, cmd.Keys)
+		if err != nil {
+			return Result{}, false, err
+		}
+		return Strings(members), false, nil
+	case parser.SINTERSTORE, parser.SUNIONSTORE, parser.SDIFFSTORE:
+		// Результат заменяет значение приёмника любого типа (пустой – удаляет его);
+		// ответ – размер результата
+		members, err := combineSets(tx, cmd.Type, cmd.Keys)
+		if err != nil {
+			return Result{}, false, err
+		}
+		existed := tx.Del(cmd.Key)
+		if len(members) > 0 {
+			if _, err := tx.SAdd(cmd.Key, members); err != nil {
+				return Result{}, false, err
+			}
 		}
-		return val, nil
+		return Integer(int64(len(members))), existed || len(members) > 0, nil
 	default:
-		return "", fmt.Errorf("unknown command")
+		return Result{}, false, fmt.Errorf("unknown command")
 	}
 }
+
+// matchKeys оставляет ключи, подходящие под glob-шаблон (пустой шаблон – все)
+func matchKeys(keys []string, pattern string) []string {
+	if pattern == "" || pattern == "*" {
+		return keys
+	}
+	matched := keys[:0]
+	for _, key := range keys {
+		if storage.MatchPattern(pattern, key) {
+			matched = append(matched, key)
+		}
+	}
+	return matched
+}
+
+// prefixEnd – наименьшая строка больше всех строк с префиксом prefix
+// (пустая – таких нет: префикс пустой или из одних байт 0xff)
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
+
+// scoredMembers – элементы сортированного множества; withScores – после каждого элемента его счёт
+func scoredMembers(members []storage.ScoredMember, withScores bool) Result {
+	values := make([]string, 0, 2*len(members))
+	for _, m := range members {
+		values = append(values, m.Member)
+		if withScores {
+			values = append(values, formatFloat(m.Score))
+		}
+	}
+	return Strings(values)
+}
+
+// formatFloat – число в ответе (счёт, INCRBYFLOAT): целые без дробной части и экспоненты,
+// бесконечности – "inf"/"-inf"
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.Abs(f) < 1e17:
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
+
+// expireAt – абсолютное время истечения с той же точностью (мс), что и в WAL
+func expireAt(now time.Time, ttl time.Duration) time.Time {
+	return time.UnixMilli(now.Add(ttl).UnixMilli())
+}
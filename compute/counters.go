@@ -0,0 +1,69 @@
+package compute
+
+import (
+	"errors"
+	"math"
+	"strconv"
+
+	"imkvdb/storage"
+)
+
+var (
+	errNotInteger = errors.New("value is not an integer or out of range")
+	errNotFloat   = errors.New("value is not a valid float")
+	errOverflow   = errors.New("increment or decrement would overflow")
+	errNotFinite  = errors.New("increment would produce NaN or Infinity")
+)
+
+// incrBy прибавляет by к целому значению строки key (нет ключа – 0) и возвращает новое значение.
+// Модифицирующие команды выполняются под writeMu, поэтому чтение и запись не разрываются чужой записью.
+func incrBy(tx storage.Tx, key string, by int64) (int64, error) {
+	cur, ok, err := tx.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	if ok {
+		if n, err = strconv.ParseInt(cur, 10, 64); err != nil {
+			return 0, errNotInteger
+		}
+	}
+	if by > 0 && n > math.MaxInt64-by || by < 0 && n < math.MinInt64-by {
+		return 0, errOverflow
+	}
+	n += by
+	return n, setKeepTTL(tx, key, strconv.FormatInt(n, 10))
+}
+
+// incrByFloat – как incrBy, но для чисел с плавающей точкой
+func incrByFloat(tx storage.Tx, key string, by float64) (string, error) {
+	cur, ok, err := tx.Get(key)
+	if err != nil {
+		return "", err
+	}
+	var f float64
+	if ok {
+		f, err = strconv.ParseFloat(cur, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return "", errNotFloat
+		}
+	}
+	f += by
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", errNotFinite
+	}
+	val := formatFloat(f)
+	return val, setKeepTTL(tx, key, val)
+}
+
+// setKeepTTL записывает строку, сохраняя TTL ключа (Set его сбрасывает)
+func setKeepTTL(tx storage.Tx, key, val string) error {
+	at, _ := tx.ExpireTime(key)
+	if err := tx.Set(key, val); err != nil {
+		return err
+	}
+	if !at.IsZero() {
+		tx.Expire(key, at)
+	}
+	return nil
+}
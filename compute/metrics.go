@@ -0,0 +1,26 @@
+package compute
+
+import (
+	"time"
+
+	"imkvdb/compute/parser"
+	"imkvdb/metrics"
+)
+
+var (
+	commandsTotal = metrics.NewCounterVec("imkvdb_commands_total",
+		"Processed client commands by type", "command")
+	commandDuration = metrics.NewHistogramVec("imkvdb_command_duration_seconds",
+		"Command latency from parsing to a ready reply, including the WAL fsync wait", nil, "command")
+	parseErrors = metrics.NewCounter("imkvdb_command_parse_errors_total",
+		"Commands rejected by the parser")
+)
+
+// observed учитывает команду в метриках; её длительность запишет Pending.Wait,
+// когда ответ будет готов (после fsync)
+func observed(cmd parser.Command, start time.Time, p Pending) Pending {
+	name := cmd.Type.String()
+	commandsTotal.WithLabelValues(name).Inc()
+	p.latency, p.start = commandDuration.WithLabelValues(name), start
+	return p
+}
@@ -2,8 +2,13 @@ package parser
 
 import (
 	"errors"
+	"fmt"
+	"math"
+	"strconv"
 	"strings"
-	"unicode"
+	"time"
+
+	"imkvdb/storage"
 )
 
 // CommandType – перечисление возможных типов команд
@@ -13,18 +18,178 @@ const (
 	SET CommandType = iota
 	GET
 	DEL
+	EXPIRE
+	TTL
+	PERSIST
+	MULTI
+	EXEC
+	DISCARD
+	CAS
+	WATCH
+	UNWATCH
+	GETVER
+	HSET
+	HGET
+	HDEL
+	HGETALL
+	HLEN
+	LPUSH
+	RPUSH
+	LPOP
+	RPOP
+	LRANGE
+	LLEN
+	BLPOP
+	ZADD
+	ZRANGE
+	ZRANGEBYSCORE
+	ZRANK
+	ZREM
+	SADD
+	SREM
+	SMEMBERS
+	SISMEMBER
+	SINTER
+	SUNION
+	SDIFF
+	SINTERSTORE
+	SUNIONSTORE
+	SDIFFSTORE
+	INCR
+	DECR
+	INCRBY
+	INCRBYFLOAT
+	SCAN
+	KEYS
+	DBSIZE
+	RANGE
+	PREFIX
+	MSET
+	MGET
+	MDEL
+	AUTH
 )
 
+// commandNames – имена команд, как их пишет клиент
+var commandNames = [...]string{
+	SET:           "SET",
+	GET:           "GET",
+	DEL:           "DEL",
+	EXPIRE:        "EXPIRE",
+	TTL:           "TTL",
+	PERSIST:       "PERSIST",
+	MULTI:         "MULTI",
+	EXEC:          "EXEC",
+	DISCARD:       "DISCARD",
+	CAS:           "CAS",
+	WATCH:         "WATCH",
+	UNWATCH:       "UNWATCH",
+	GETVER:        "GETVER",
+	HSET:          "HSET",
+	HGET:          "HGET",
+	HDEL:          "HDEL",
+	HGETALL:       "HGETALL",
+	HLEN:          "HLEN",
+	LPUSH:         "LPUSH",
+	RPUSH:         "RPUSH",
+	LPOP:          "LPOP",
+	RPOP:          "RPOP",
+	LRANGE:        "LRANGE",
+	LLEN:          "LLEN",
+	BLPOP:         "BLPOP",
+	ZADD:          "ZADD",
+	ZRANGE:        "ZRANGE",
+	ZRANGEBYSCORE: "ZRANGEBYSCORE",
+	ZRANK:         "ZRANK",
+	ZREM:          "ZREM",
+	SADD:          "SADD",
+	SREM:          "SREM",
+	SMEMBERS:      "SMEMBERS",
+	SISMEMBER:     "SISMEMBER",
+	SINTER:        "SINTER",
+	SUNION:        "SUNION",
+	SDIFF:         "SDIFF",
+	SINTERSTORE:   "SINTERSTORE",
+	SUNIONSTORE:   "SUNIONSTORE",
+	SDIFFSTORE:    "SDIFFSTORE",
+	INCR:          "INCR",
+	DECR:          "DECR",
+	INCRBY:        "INCRBY",
+	INCRBYFLOAT:   "INCRBYFLOAT",
+	SCAN:          "SCAN",
+	KEYS:          "KEYS",
+	DBSIZE:        "DBSIZE",
+	RANGE:         "RANGE",
+	PREFIX:        "PREFIX",
+	MSET:          "MSET",
+	MGET:          "MGET",
+	MDEL:          "MDEL",
+	AUTH:          "AUTH",
+}
+
+// String – имя команды (SET, HGETALL, ...)
+func (t CommandType) String() string {
+	if t >= 0 && int(t) < len(commandNames) {
+		return commandNames[t]
+	}
+	return "UNKNOWN"
+}
+
+// DefaultUser – пользователь команды AUTH без имени
+const DefaultUser = "default"
+
 // Command – структура, описывающая распарсенную команду
 type Command struct {
 	Type  CommandType
 	Key   string
 	Value string // Значение нужно только для SET
+	// Expire – время жизни ключа (SET ... EX/PX, EXPIRE); 0 – без TTL
+	Expire time.Duration
+	// Expected – ожидаемое текущее значение для CAS
+	Expected string
+	// Keys – ключи команд с несколькими ключами (WATCH, BLPOP, SINTER/SUNION/SDIFF, MGET/MDEL);
+	// у *STORE-вариантов ключ-приёмник – Key
+	Keys []string
+	// Field – поле хеша (HGET) или элемент множества (ZRANK, SISMEMBER)
+	Field string
+	// Args – аргументы команд над коллекциями: пары поле/значение (HSET), поля (HDEL),
+	// значения (LPUSH/RPUSH), элементы (ZREM, SADD/SREM); пары ключ/значение MSET;
+	// имя пользователя и пароль AUTH
+	Args []string
+	// Start, Stop – диапазон индексов (LRANGE, ZRANGE), включительно; отрицательные – с конца
+	Start, Stop int
+	// Timeout – сколько ждать данных (BLPOP); 0 – без ограничения
+	Timeout time.Duration
+	// Members – элементы со счетами (ZADD)
+	Members []storage.ScoredMember
+	// Min, Max – диапазон счетов (ZRANGEBYSCORE)
+	Min, Max storage.ScoreBound
+	// Offset, Count – LIMIT offset count (ZRANGEBYSCORE), Count < 0 – без ограничения;
+	// Count – ещё и размер порции SCAN
+	Offset, Count int
+	// WithScores – вернуть элементы вместе со счетами (ZRANGE, ZRANGEBYSCORE)
+	WithScores bool
+	// Increment – на сколько изменить целое значение (INCR/DECR – ±1, INCRBY)
+	Increment int64
+	// FloatIncrement – на сколько изменить число с плавающей точкой (INCRBYFLOAT)
+	FloatIncrement float64
+	// Cursor – курсор SCAN (0 – начать обход)
+	Cursor uint64
+	// Pattern – glob-шаблон ключей (KEYS, SCAN ... MATCH); пустой – все ключи
+	Pattern string
+	// End – верхняя граница диапазона ключей RANGE (не включается; пустая – без границы),
+	// нижняя граница RANGE и префикс PREFIX – Key
+	End string
+	// Reverse – ключи по убыванию (RANGE, PREFIX)
+	Reverse bool
 }
 
 // Parser – интерфейс парсинга строки в Command
 type Parser interface {
 	Parse(input string) (Command, error)
+	// ParseArgs разбирает уже выделенные аргументы (например, из массива RESP),
+	// поэтому ключи и значения могут содержать пробелы и любые байты
+	ParseArgs(args []string) (Command, error)
 }
 
 // parser – конкретная реализация Parser
@@ -37,23 +202,45 @@ func NewParser() Parser {
 
 // Parse – парсит строку и возвращает структуру команды
 func (p *parser) Parse(input string) (Command, error) {
-	// Разбиваем входную строку по пробелам
-	tokens := splitByWhitespace(input)
+	tokens, err := Tokenize(input)
+	if err != nil {
+		return Command{}, err
+	}
+	return p.ParseArgs(tokens)
+}
 
+// ParseArgs – разбирает команду из готовых аргументов: tokens[0] – имя команды
+func (p *parser) ParseArgs(tokens []string) (Command, error) {
 	if len(tokens) == 0 {
 		return Command{}, errors.New("empty command")
 	}
 
-	switch strings.ToUpper(tokens[0]) {
+	name := strings.ToUpper(tokens[0])
+	switch name {
 	case "SET":
 		if len(tokens) < 3 {
 			return Command{}, errors.New("SET command requires 2 arguments: key and value")
 		}
-		return Command{
+		cmd := Command{
 			Type:  SET,
 			Key:   tokens[1],
 			Value: tokens[2],
-		}, nil
+		}
+		// Необязательные параметры: EX seconds | PX milliseconds
+		if len(tokens) > 3 {
+			if len(tokens) != 5 {
+				return Command{}, errors.New("SET options: EX seconds | PX milliseconds")
+			}
+			ttl, err := parseTTL(tokens[3], tokens[4])
+			if err != nil {
+				return Command{}, err
+			}
+			if ttl <= 0 {
+				return Command{}, errors.New("invalid expire time in SET")
+			}
+			cmd.Expire = ttl
+		}
+		return cmd, nil
 	case "GET":
 		if len(tokens) < 2 {
 			return Command{}, errors.New("GET command requires 1 argument: key")
@@ -70,28 +257,547 @@ func (p *parser) Parse(input string) (Command, error) {
 			Type: DEL,
 			Key:  tokens[1],
 		}, nil
+	case "EXPIRE":
+		if len(tokens) < 3 {
+			return Command{}, errors.New("EXPIRE command requires 2 arguments: key and seconds")
+		}
+		ttl, err := parseTTL("EX", tokens[2])
+		if err != nil {
+			return Command{}, err
+		}
+		return Command{
+			Type:   EXPIRE,
+			Key:    tokens[1],
+			Expire: ttl,
+		}, nil
+	case "TTL":
+		if len(tokens) < 2 {
+			return Command{}, errors.New("TTL command requires 1 argument: key")
+		}
+		return Command{
+			Type: TTL,
+			Key:  tokens[1],
+		}, nil
+	case "PERSIST":
+		if len(tokens) < 2 {
+			return Command{}, errors.New("PERSIST command requires 1 argument: key")
+		}
+		return Command{
+			Type: PERSIST,
+			Key:  tokens[1],
+		}, nil
+	case "CAS":
+		if len(tokens) < 4 {
+			return Command{}, errors.New("CAS command requires 3 arguments: key, expected and new value")
+		}
+		return Command{
+			Type:     CAS,
+			Key:      tokens[1],
+			Expected: tokens[2],
+			Value:    tokens[3],
+		}, nil
+	case "WATCH":
+		if len(tokens) < 2 {
+			return Command{}, errors.New("WATCH command requires at least 1 key")
+		}
+		return Command{
+			Type: WATCH,
+			Keys: tokens[1:],
+		}, nil
+	case "UNWATCH":
+		return Command{Type: UNWATCH}, nil
+	case "GETVER":
+		if len(tokens) < 2 {
+			return Command{}, errors.New("GETVER command requires 1 argument: key")
+		}
+		return Command{
+			Type: GETVER,
+			Key:  tokens[1],
+		}, nil
+	case "INCR", "DECR":
+		if len(tokens) != 2 {
+			return Command{}, fmt.Errorf("%s command requires 1 argument: key", name)
+		}
+		cmd := Command{Type: INCR, Key: tokens[1], Increment: 1}
+		if name == "DECR" {
+			cmd.Type, cmd.Increment = DECR, -1
+		}
+		return cmd, nil
+	case "INCRBY":
+		if len(tokens) != 3 {
+			return Command{}, errors.New("INCRBY command requires 2 arguments: key and increment")
+		}
+		increment, err := strconv.ParseInt(tokens[2], 10, 64)
+		if err != nil {
+			return Command{}, fmt.Errorf("invalid increment %q: not an integer or out of range", tokens[2])
+		}
+		return Command{
+			Type:      INCRBY,
+			Key:       tokens[1],
+			Increment: increment,
+		}, nil
+	case "INCRBYFLOAT":
+		if len(tokens) != 3 {
+			return Command{}, errors.New("INCRBYFLOAT command requires 2 arguments: key and increment")
+		}
+		increment, err := strconv.ParseFloat(tokens[2], 64)
+		if err != nil || math.IsNaN(increment) || math.IsInf(increment, 0) {
+			return Command{}, fmt.Errorf("invalid increment %q: not a valid float", tokens[2])
+		}
+		return Command{
+			Type:           INCRBYFLOAT,
+			Key:            tokens[1],
+			FloatIncrement: increment,
+		}, nil
+	case "HSET":
+		if len(tokens) < 4 || len(tokens)%2 != 0 {
+			return Command{}, errors.New("HSET command requires a key and field value pairs")
+		}
+		return Command{
+			Type: HSET,
+			Key:  tokens[1],
+			Args: tokens[2:],
+		}, nil
+	case "HGET":
+		if len(tokens) != 3 {
+			return Command{}, errors.New("HGET command requires 2 arguments: key and field")
+		}
+		return Command{
+			Type:  HGET,
+			Key:   tokens[1],
+			Field: tokens[2],
+		}, nil
+	case "HDEL":
+		if len(tokens) < 3 {
+			return Command{}, errors.New("HDEL command requires a key and at least 1 field")
+		}
+		return Command{
+			Type: HDEL,
+			Key:  tokens[1],
+			Args: tokens[2:],
+		}, nil
+	case "HGETALL":
+		if len(tokens) != 2 {
+			return Command{}, errors.New("HGETALL command requires 1 argument: key")
+		}
+		return Command{
+			Type: HGETALL,
+			Key:  tokens[1],
+		}, nil
+	case "HLEN":
+		if len(tokens) != 2 {
+			return Command{}, errors.New("HLEN command requires 1 argument: key")
+		}
+		return Command{
+			Type: HLEN,
+			Key:  tokens[1],
+		}, nil
+	case "LPUSH":
+		if len(tokens) < 3 {
+			return Command{}, errors.New("LPUSH command requires a key and at least 1 value")
+		}
+		return Command{
+			Type: LPUSH,
+			Key:  tokens[1],
+			Args: tokens[2:],
+		}, nil
+	case "RPUSH":
+		if len(tokens) < 3 {
+			return Command{}, errors.New("RPUSH command requires a key and at least 1 value")
+		}
+		return Command{
+			Type: RPUSH,
+			Key:  tokens[1],
+			Args: tokens[2:],
+		}, nil
+	case "LPOP":
+		if len(tokens) != 2 {
+			return Command{}, errors.New("LPOP command requires 1 argument: key")
+		}
+		return Command{
+			Type: LPOP,
+			Key:  tokens[1],
+		}, nil
+	case "RPOP":
+		if len(tokens) != 2 {
+			return Command{}, errors.New("RPOP command requires 1 argument: key")
+		}
+		return Command{
+			Type: RPOP,
+			Key:  tokens[1],
+		}, nil
+	case "LLEN":
+		if len(tokens) != 2 {
+			return Command{}, errors.New("LLEN command requires 1 argument: key")
+		}
+		return Command{
+			Type: LLEN,
+			Key:  tokens[1],
+		}, nil
+	case "LRANGE":
+		if len(tokens) != 4 {
+			return Command{}, errors.New("LRANGE command requires 3 arguments: key, start and stop")
+		}
+		start, err1 := strconv.Atoi(tokens[2])
+		stop, err2 := strconv.Atoi(tokens[3])
+		if err1 != nil || err2 != nil {
+			return Command{}, errors.New("LRANGE start and stop must be integers")
+		}
+		return Command{
+			Type:  LRANGE,
+			Key:   tokens[1],
+			Start: start,
+			Stop:  stop,
+		}, nil
+	case "BLPOP":
+		if len(tokens) < 3 {
+			return Command{}, errors.New("BLPOP command requires at least 1 key and a timeout")
+		}
+		timeout, err := parseTimeout(tokens[len(tokens)-1])
+		if err != nil {
+			return Command{}, err
+		}
+		return Command{
+			Type:    BLPOP,
+			Keys:    tokens[1 : len(tokens)-1],
+			Timeout: timeout,
+		}, nil
+	case "ZADD":
+		if len(tokens) < 4 || len(tokens)%2 != 0 {
+			return Command{}, errors.New("ZADD command requires a key and score member pairs")
+		}
+		members := make([]storage.ScoredMember, 0, (len(tokens)-2)/2)
+		for i := 2; i < len(tokens); i += 2 {
+			score, err := parseScore(tokens[i])
+			if err != nil {
+				return Command{}, err
+			}
+			members = append(members, storage.ScoredMember{Member: tokens[i+1], Score: score})
+		}
+		return Command{
+			Type:    ZADD,
+			Key:     tokens[1],
+			Members: members,
+		}, nil
+	case "ZRANGE":
+		if len(tokens) < 4 || len(tokens) > 5 {
+			return Command{}, errors.New("ZRANGE command requires 3 arguments: key, start and stop")
+		}
+		start, err1 := strconv.Atoi(tokens[2])
+		stop, err2 := strconv.Atoi(tokens[3])
+		if err1 != nil || err2 != nil {
+			return Command{}, errors.New("ZRANGE start and stop must be integers")
+		}
+		cmd := Command{
+			Type:  ZRANGE,
+			Key:   tokens[1],
+			Start: start,
+			Stop:  stop,
+		}
+		if len(tokens) == 5 {
+			if strings.ToUpper(tokens[4]) != "WITHSCORES" {
+				return Command{}, fmt.Errorf("unknown option %q, expected WITHSCORES", tokens[4])
+			}
+			cmd.WithScores = true
+		}
+		return cmd, nil
+	case "ZRANGEBYSCORE":
+		if len(tokens) < 4 {
+			return Command{}, errors.New("ZRANGEBYSCORE command requires 3 arguments: key, min and max")
+		}
+		min, err := parseScoreBound(tokens[2])
+		if err != nil {
+			return Command{}, err
+		}
+		max, err := parseScoreBound(tokens[3])
+		if err != nil {
+			return Command{}, err
+		}
+		cmd := Command{
+			Type:  ZRANGEBYSCORE,
+			Key:   tokens[1],
+			Min:   min,
+			Max:   max,
+			Count: -1,
+		}
+		// Необязательные параметры: WITHSCORES и LIMIT offset count в любом порядке
+		for i := 4; i < len(tokens); i++ {
+			switch strings.ToUpper(tokens[i]) {
+			case "WITHSCORES":
+				cmd.WithScores = true
+			case "LIMIT":
+				if i+2 >= len(tokens) {
+					return Command{}, errors.New("LIMIT requires 2 arguments: offset and count")
+				}
+				offset, err1 := strconv.Atoi(tokens[i+1])
+				count, err2 := strconv.Atoi(tokens[i+2])
+				if err1 != nil || err2 != nil {
+					return Command{}, errors.New("LIMIT offset and count must be integers")
+				}
+				if offset < 0 {
+					return Command{}, errors.New("LIMIT offset must not be negative")
+				}
+				cmd.Offset, cmd.Count = offset, count
+				i += 2
+			default:
+				return Command{}, fmt.Errorf("unknown option %q, expected WITHSCORES or LIMIT", tokens[i])
+			}
+		}
+		return cmd, nil
+	case "ZRANK":
+		if len(tokens) != 3 {
+			return Command{}, errors.New("ZRANK command requires 2 arguments: key and member")
+		}
+		return Command{
+			Type:  ZRANK,
+			Key:   tokens[1],
+			Field: tokens[2],
+		}, nil
+	case "ZREM":
+		if len(tokens) < 3 {
+			return Command{}, errors.New("ZREM command requires a key and at least 1 member")
+		}
+		return Command{
+			Type: ZREM,
+			Key:  tokens[1],
+			Args: tokens[2:],
+		}, nil
+	case "SADD", "SREM":
+		if len(tokens) < 3 {
+			return Command{}, fmt.Errorf("%s command requires a key and at least 1 member", name)
+		}
+		cmdType := SADD
+		if name == "SREM" {
+			cmdType = SREM
+		}
+		return Command{
+			Type: cmdType,
+			Key:  tokens[1],
+			Args: tokens[2:],
+		}, nil
+	case "SMEMBERS":
+		if len(tokens) != 2 {
+			return Command{}, errors.New("SMEMBERS command requires 1 argument: key")
+		}
+		return Command{
+			Type: SMEMBERS,
+			Key:  tokens[1],
+		}, nil
+	case "SISMEMBER":
+		if len(tokens) != 3 {
+			return Command{}, errors.New("SISMEMBER command requires 2 arguments: key and member")
+		}
+		return Command{
+			Type:  SISMEMBER,
+			Key:   tokens[1],
+			Field: tokens[2],
+		}, nil
+	case "SINTER", "SUNION", "SDIFF":
+		if len(tokens) < 2 {
+			return Command{}, fmt.Errorf("%s command requires at least 1 key", name)
+		}
+		return Command{
+			Type: setAlgebra[name],
+			Keys: tokens[1:],
+		}, nil
+	case "SINTERSTORE", "SUNIONSTORE", "SDIFFSTORE":
+		if len(tokens) < 3 {
+			return Command{}, fmt.Errorf("%s command requires a destination and at least 1 key", name)
+		}
+		return Command{
+			Type: setAlgebra[name],
+			Key:  tokens[1],
+			Keys: tokens[2:],
+		}, nil
+	case "SCAN":
+		if len(tokens) < 2 {
+			return Command{}, errors.New("SCAN command requires a cursor")
+		}
+		cursor, err := strconv.ParseUint(tokens[1], 10, 64)
+		if err != nil {
+			return Command{}, fmt.Errorf("invalid cursor %q", tokens[1])
+		}
+		cmd := Command{Type: SCAN, Cursor: cursor, Count: defaultScanCount}
+		// Необязательные параметры: MATCH pattern и COUNT n в любом порядке
+		for i := 2; i < len(tokens); i += 2 {
+			if i+1 >= len(tokens) {
+				return Command{}, fmt.Errorf("option %q requires a value", tokens[i])
+			}
+			switch strings.ToUpper(tokens[i]) {
+			case "MATCH":
+				cmd.Pattern = tokens[i+1]
+			case "COUNT":
+				count, err := strconv.Atoi(tokens[i+1])
+				if err != nil || count < 1 {
+					return Command{}, fmt.Errorf("invalid COUNT %q: must be a positive integer", tokens[i+1])
+				}
+				cmd.Count = count
+			default:
+				return Command{}, fmt.Errorf("unknown option %q, expected MATCH or COUNT", tokens[i])
+			}
+		}
+		return cmd, nil
+	case "KEYS":
+		if len(tokens) != 2 {
+			return Command{}, errors.New("KEYS command requires 1 argument: pattern")
+		}
+		return Command{
+			Type:    KEYS,
+			Pattern: tokens[1],
+		}, nil
+	case "DBSIZE":
+		if len(tokens) != 1 {
+			return Command{}, errors.New("DBSIZE command takes no arguments")
+		}
+		return Command{Type: DBSIZE}, nil
+	case "RANGE":
+		// RANGE start end [LIMIT count] [REV]
+		if len(tokens) < 3 {
+			return Command{}, errors.New("RANGE command requires 2 arguments: start and end")
+		}
+		return parseRangeOptions(Command{Type: RANGE, Key: tokens[1], End: tokens[2], Count: -1}, tokens[3:])
+	case "PREFIX":
+		// PREFIX prefix [LIMIT count] [REV]
+		if len(tokens) < 2 {
+			return Command{}, errors.New("PREFIX command requires 1 argument: prefix")
+		}
+		return parseRangeOptions(Command{Type: PREFIX, Key: tokens[1], Count: -1}, tokens[2:])
+	case "MSET":
+		if len(tokens) < 3 || len(tokens)%2 != 1 {
+			return Command{}, errors.New("MSET command requires key value pairs")
+		}
+		return Command{
+			Type: MSET,
+			Args: tokens[1:],
+		}, nil
+	case "MGET", "MDEL":
+		if len(tokens) < 2 {
+			return Command{}, fmt.Errorf("%s command requires at least 1 key", name)
+		}
+		cmdType := MGET
+		if name == "MDEL" {
+			cmdType = MDEL
+		}
+		return Command{
+			Type: cmdType,
+			Keys: tokens[1:],
+		}, nil
+	case "AUTH":
+		// AUTH password – пользователь "default", как в Redis; AUTH username password
+		switch len(tokens) {
+		case 2:
+			return Command{Type: AUTH, Args: []string{DefaultUser, tokens[1]}}, nil
+		case 3:
+			return Command{Type: AUTH, Args: tokens[1:]}, nil
+		default:
+			return Command{}, errors.New("AUTH command requires [username] password")
+		}
+	case "MULTI":
+		return Command{Type: MULTI}, nil
+	case "EXEC":
+		return Command{Type: EXEC}, nil
+	case "DISCARD":
+		return Command{Type: DISCARD}, nil
 	default:
 		return Command{}, errors.New("unknown command")
 	}
 }
 
-// splitByWhitespace – вспомогательная функция, которая разделяет строку по любым пробельным символам
-func splitByWhitespace(input string) []string {
-	fields := []string{}
-	current := strings.Builder{}
+// maxTTLSeconds – предел, при котором секунды ещё помещаются в time.Duration
+const maxTTLSeconds = int64(math.MaxInt64 / time.Second)
 
-	for _, r := range input {
-		if unicode.IsSpace(r) {
-			if current.Len() > 0 {
-				fields = append(fields, current.String())
-				current.Reset()
+// parseRangeOptions разбирает необязательные LIMIT count и REV команд RANGE и PREFIX
+func parseRangeOptions(cmd Command, options []string) (Command, error) {
+	for i := 0; i < len(options); i++ {
+		switch strings.ToUpper(options[i]) {
+		case "LIMIT":
+			if i+1 >= len(options) {
+				return Command{}, errors.New("LIMIT requires 1 argument: count")
 			}
-		} else {
-			current.WriteRune(r)
+			count, err := strconv.Atoi(options[i+1])
+			if err != nil || count < 1 {
+				return Command{}, fmt.Errorf("invalid LIMIT %q: must be a positive integer", options[i+1])
+			}
+			cmd.Count = count
+			i++
+		case "REV":
+			cmd.Reverse = true
+		default:
+			return Command{}, fmt.Errorf("unknown option %q, expected LIMIT or REV", options[i])
 		}
 	}
-	if current.Len() > 0 {
-		fields = append(fields, current.String())
+	return cmd, nil
+}
+
+// defaultScanCount – размер порции SCAN без COUNT (как в Redis)
+const defaultScanCount = 10
+
+// setAlgebra – команды над несколькими множествами по имени
+var setAlgebra = map[string]CommandType{
+	"SINTER":      SINTER,
+	"SUNION":      SUNION,
+	"SDIFF":       SDIFF,
+	"SINTERSTORE": SINTERSTORE,
+	"SUNIONSTORE": SUNIONSTORE,
+	"SDIFFSTORE":  SDIFFSTORE,
+}
+
+// parseScore – счёт элемента сортированного множества: число с плавающей точкой,
+// допускаются "inf", "+inf" и "-inf"; NaN не допускается
+func parseScore(s string) (float64, error) {
+	score, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, fmt.Errorf("invalid score %q: not a valid float", s)
+	}
+	return score, nil
+}
+
+// parseScoreBound – граница диапазона счетов: число или "(число" для исключённой границы
+func parseScoreBound(s string) (storage.ScoreBound, error) {
+	var bound storage.ScoreBound
+	if strings.HasPrefix(s, "(") {
+		bound.Exclusive = true
+		s = s[1:]
+	}
+	score, err := parseScore(s)
+	if err != nil {
+		return storage.ScoreBound{}, fmt.Errorf("min or max is not a float: %w", err)
+	}
+	bound.Value = score
+	return bound, nil
+}
+
+// parseTimeout – таймаут блокирующей команды в секундах (допускаются дробные, как в Redis)
+func parseTimeout(s string) (time.Duration, error) {
+	sec, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(sec) || math.IsInf(sec, 0) {
+		return 0, fmt.Errorf("invalid timeout %q: not a number", s)
+	}
+	if sec < 0 {
+		return 0, fmt.Errorf("invalid timeout %q: negative", s)
+	}
+	if sec > float64(maxTTLSeconds) {
+		return 0, fmt.Errorf("invalid timeout %q: out of range", s)
+	}
+	return time.Duration(sec * float64(time.Second)), nil
+}
+
+// parseTTL – разбирает пару "EX seconds" / "PX milliseconds" в time.Duration.
+// Отрицательные значения допускаются (EXPIRE с ними удаляет ключ), проверку делает вызывающий.
+func parseTTL(unit, amount string) (time.Duration, error) {
+	n, err := strconv.ParseInt(amount, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid expire time %q: not an integer", amount)
+	}
+	switch strings.ToUpper(unit) {
+	case "EX":
+		if n > maxTTLSeconds || n < -maxTTLSeconds {
+			return 0, fmt.Errorf("invalid expire time %q: out of range", amount)
+		}
+		return time.Duration(n) * time.Second, nil
+	case "PX":
+		return time.Duration(n) * time.Millisecond, nil
+	default:
+		return 0, fmt.Errorf("unknown option %q, expected EX or PX", unit)
 	}
-	return fields
 }
@@ -1,8 +1,14 @@
 package parser
 
 import (
+	"errors"
+	"math"
 	"reflect"
+	"strconv"
 	"testing"
+	"time"
+
+	"imkvdb/storage"
 )
 
 func TestParser(t *testing.T) {
@@ -28,6 +34,407 @@ func TestParser(t *testing.T) {
 				Key:  "key",
 			},
 		},
+		{
+			input: "SET key value EX 30",
+			expected: Command{
+				Type:   SET,
+				Key:    "key",
+				Value:  "value",
+				Expire: 30 * time.Second,
+			},
+		},
+		{
+			input: "set key value px 1500",
+			expected: Command{
+				Type:   SET,
+				Key:    "key",
+				Value:  "value",
+				Expire: 1500 * time.Millisecond,
+			},
+		},
+		{
+			input: `SET greeting "hello world" EX 30`,
+			expected: Command{
+				Type:   SET,
+				Key:    "greeting",
+				Value:  "hello world",
+				Expire: 30 * time.Second,
+			},
+		},
+		{
+			// Без кавычек лишние слова не отбрасываются молча
+			input:   "SET greeting hello world",
+			wantErr: true,
+		},
+		{
+			input:   `SET greeting "hello world`,
+			wantErr: true,
+		},
+		{
+			input:   "SET key value EX 0",
+			wantErr: true,
+		},
+		{
+			input:   "SET key value EX abc",
+			wantErr: true,
+		},
+		{
+			input:   "SET key value KEEP 10",
+			wantErr: true,
+		},
+		{
+			input: "EXPIRE key 30",
+			expected: Command{
+				Type:   EXPIRE,
+				Key:    "key",
+				Expire: 30 * time.Second,
+			},
+		},
+		{
+			input:   "EXPIRE key",
+			wantErr: true,
+		},
+		{
+			input: "TTL key",
+			expected: Command{
+				Type: TTL,
+				Key:  "key",
+			},
+		},
+		{
+			input: "PERSIST key",
+			expected: Command{
+				Type: PERSIST,
+				Key:  "key",
+			},
+		},
+		{
+			input:    "multi",
+			expected: Command{Type: MULTI},
+		},
+		{
+			input:    "EXEC",
+			expected: Command{Type: EXEC},
+		},
+		{
+			input:    "DISCARD",
+			expected: Command{Type: DISCARD},
+		},
+		{
+			input: "CAS key old new",
+			expected: Command{
+				Type:     CAS,
+				Key:      "key",
+				Expected: "old",
+				Value:    "new",
+			},
+		},
+		{
+			input:   "CAS key old",
+			wantErr: true,
+		},
+		{
+			input: "WATCH k1 k2",
+			expected: Command{
+				Type: WATCH,
+				Keys: []string{"k1", "k2"},
+			},
+		},
+		{
+			input:   "WATCH",
+			wantErr: true,
+		},
+		{
+			input: "GETVER key",
+			expected: Command{
+				Type: GETVER,
+				Key:  "key",
+			},
+		},
+		{
+			input: "RPUSH q a b",
+			expected: Command{
+				Type: RPUSH,
+				Key:  "q",
+				Args: []string{"a", "b"},
+			},
+		},
+		{
+			input:   "LPUSH q",
+			wantErr: true,
+		},
+		{
+			input: "LRANGE q 0 -1",
+			expected: Command{
+				Type:  LRANGE,
+				Key:   "q",
+				Start: 0,
+				Stop:  -1,
+			},
+		},
+		{
+			input:   "LRANGE q 0 x",
+			wantErr: true,
+		},
+		{
+			input: "BLPOP q1 q2 1.5",
+			expected: Command{
+				Type:    BLPOP,
+				Keys:    []string{"q1", "q2"},
+				Timeout: 1500 * time.Millisecond,
+			},
+		},
+		{
+			input:   "BLPOP q -1",
+			wantErr: true,
+		},
+		{
+			input:   "BLPOP 5",
+			wantErr: true,
+		},
+		{
+			input: "ZADD board 1.5 alice -inf bob +inf carol",
+			expected: Command{
+				Type: ZADD,
+				Key:  "board",
+				Members: []storage.ScoredMember{
+					{Member: "alice", Score: 1.5},
+					{Member: "bob", Score: math.Inf(-1)},
+					{Member: "carol", Score: math.Inf(1)},
+				},
+			},
+		},
+		{
+			input:   "ZADD board nan alice",
+			wantErr: true,
+		},
+		{
+			input:   "ZADD board 1",
+			wantErr: true,
+		},
+		{
+			input: "ZRANGE board 0 -1 withscores",
+			expected: Command{
+				Type:       ZRANGE,
+				Key:        "board",
+				Start:      0,
+				Stop:       -1,
+				WithScores: true,
+			},
+		},
+		{
+			input: "ZRANGEBYSCORE board (1 +inf",
+			expected: Command{
+				Type:  ZRANGEBYSCORE,
+				Key:   "board",
+				Min:   storage.ScoreBound{Value: 1, Exclusive: true},
+				Max:   storage.ScoreBound{Value: math.Inf(1)},
+				Count: -1,
+			},
+		},
+		{
+			input: "ZRANGEBYSCORE board -inf 10 LIMIT 2 5 WITHSCORES",
+			expected: Command{
+				Type:       ZRANGEBYSCORE,
+				Key:        "board",
+				Min:        storage.ScoreBound{Value: math.Inf(-1)},
+				Max:        storage.ScoreBound{Value: 10},
+				Offset:     2,
+				Count:      5,
+				WithScores: true,
+			},
+		},
+		{
+			input:   "ZRANGEBYSCORE board 0 10 LIMIT -1 5",
+			wantErr: true,
+		},
+		{
+			input:   "ZRANGEBYSCORE board (x 10",
+			wantErr: true,
+		},
+		{
+			input: "SADD flags u1 u2",
+			expected: Command{
+				Type: SADD,
+				Key:  "flags",
+				Args: []string{"u1", "u2"},
+			},
+		},
+		{
+			input:   "SREM flags",
+			wantErr: true,
+		},
+		{
+			input: "SISMEMBER flags u1",
+			expected: Command{
+				Type:  SISMEMBER,
+				Key:   "flags",
+				Field: "u1",
+			},
+		},
+		{
+			input: "sunion a b c",
+			expected: Command{
+				Type: SUNION,
+				Keys: []string{"a", "b", "c"},
+			},
+		},
+		{
+			input: "SDIFFSTORE dst a b",
+			expected: Command{
+				Type: SDIFFSTORE,
+				Key:  "dst",
+				Keys: []string{"a", "b"},
+			},
+		},
+		{
+			input:   "SINTERSTORE dst",
+			wantErr: true,
+		},
+		{
+			input: "DECR hits",
+			expected: Command{
+				Type:      DECR,
+				Key:       "hits",
+				Increment: -1,
+			},
+		},
+		{
+			input: "INCRBY hits -9223372036854775808",
+			expected: Command{
+				Type:      INCRBY,
+				Key:       "hits",
+				Increment: math.MinInt64,
+			},
+		},
+		{
+			input:   "INCRBY hits 9223372036854775808",
+			wantErr: true,
+		},
+		{
+			input: "INCRBYFLOAT price 1.5e2",
+			expected: Command{
+				Type:           INCRBYFLOAT,
+				Key:            "price",
+				FloatIncrement: 150,
+			},
+		},
+		{
+			input:   "INCRBYFLOAT price inf",
+			wantErr: true,
+		},
+		{
+			input: "SCAN 0",
+			expected: Command{
+				Type:  SCAN,
+				Count: 10,
+			},
+		},
+		{
+			input: "SCAN 17 count 100 MATCH user:*",
+			expected: Command{
+				Type:    SCAN,
+				Cursor:  17,
+				Count:   100,
+				Pattern: "user:*",
+			},
+		},
+		{
+			input:   "SCAN 0 COUNT 0",
+			wantErr: true,
+		},
+		{
+			input:   "SCAN 0 MATCH",
+			wantErr: true,
+		},
+		{
+			input:   "SCAN -1",
+			wantErr: true,
+		},
+		{
+			input: "KEYS *",
+			expected: Command{
+				Type:    KEYS,
+				Pattern: "*",
+			},
+		},
+		{
+			input:    "DBSIZE",
+			expected: Command{Type: DBSIZE},
+		},
+		{
+			input: "RANGE metrics:2026-10-16 metrics:2026-10-17 LIMIT 100 rev",
+			expected: Command{
+				Type:    RANGE,
+				Key:     "metrics:2026-10-16",
+				End:     "metrics:2026-10-17",
+				Count:   100,
+				Reverse: true,
+			},
+		},
+		{
+			input: `RANGE "" ""`,
+			expected: Command{
+				Type:  RANGE,
+				Count: -1,
+			},
+		},
+		{
+			input: "PREFIX users:",
+			expected: Command{
+				Type:  PREFIX,
+				Key:   "users:",
+				Count: -1,
+			},
+		},
+		{
+			input:   "PREFIX users: LIMIT 0",
+			wantErr: true,
+		},
+		{
+			input:   "RANGE a",
+			wantErr: true,
+		},
+		{
+			input: "MSET a 1 b 2",
+			expected: Command{
+				Type: MSET,
+				Args: []string{"a", "1", "b", "2"},
+			},
+		},
+		{
+			input:   "MSET a 1 b",
+			wantErr: true,
+		},
+		{
+			input: "MDEL a b",
+			expected: Command{
+				Type: MDEL,
+				Keys: []string{"a", "b"},
+			},
+		},
+		{
+			input:   "MGET",
+			wantErr: true,
+		},
+		{
+			input: "AUTH secret",
+			expected: Command{
+				Type: AUTH,
+				Args: []string{"default", "secret"},
+			},
+		},
+		{
+			input: "auth alice secret",
+			expected: Command{
+				Type: AUTH,
+				Args: []string{"alice", "secret"},
+			},
+		},
+		{
+			input:   "AUTH alice secret extra",
+			wantErr: true,
+		},
 		{
 			input:   "DEL",
 			wantErr: true,
@@ -48,3 +455,59 @@ func TestParser(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    []string
+		wantErr error
+	}{
+		{input: "  SET  key\tvalue \n", want: []string{"SET", "key", "value"}},
+		{input: `SET greeting "hello world"`, want: []string{"SET", "greeting", "hello world"}},
+		{input: `SET json '{"a": [1, 2]}'`, want: []string{"SET", "json", `{"a": [1, 2]}`}},
+		{input: `SET k "line1\nline2\ttab \"q\" \\"`, want: []string{"SET", "k", "line1\nline2\ttab \"q\" \\"}},
+		{input: `SET k "\x00\xffé"`, want: []string{"SET", "k", "\x00\xffé"}},
+		{input: `SET k 'it\'s \n raw'`, want: []string{"SET", "k", `it's \n raw`}},
+		{input: `SET "" ''`, want: []string{"SET", "", ""}},
+		{input: `SET k a\nb`, want: []string{"SET", "k", `a\nb`}},
+		{input: `SET k "unterminated`, wantErr: ErrUnterminatedQuote},
+		{input: `SET k 'unterminated`, wantErr: ErrUnterminatedQuote},
+		{input: `SET k "trailing\`, wantErr: ErrUnterminatedQuote},
+		{input: `SET k "a"b`, wantErr: ErrQuoteNotClosed},
+		{input: `SET k "\xZZ"`, wantErr: ErrInvalidEscape},
+		{input: `SET k "\q"`, wantErr: ErrInvalidEscape},
+	}
+
+	for _, tt := range tests {
+		got, err := Tokenize(tt.input)
+		if !errors.Is(err, tt.wantErr) {
+			t.Fatalf("input=%q: got error %v, want %v", tt.input, err, tt.wantErr)
+		}
+		if err == nil && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("input=%q: got %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+// Значение, выведенное через strconv.Quote (так его показывают сервер и клиент),
+// разбирается обратно в тот же набор байт
+func TestTokenize_QuoteRoundTrip(t *testing.T) {
+	values := []string{
+		"hello world",
+		`{"name": "x", "tags": ["a b"]}`,
+		"line1\r\nline2\ttab\a\b\f\v",
+		"\x00\x01\xfe\xff",
+		"юникод и эмодзи 🙂",
+		" \U0010ffff",
+		"",
+	}
+	for _, v := range values {
+		got, err := Tokenize("SET k " + strconv.Quote(v))
+		if err != nil {
+			t.Fatalf("value %q: %v", v, err)
+		}
+		if len(got) != 3 || got[2] != v {
+			t.Errorf("value %q: got %q", v, got)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package parser
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Ошибки токенизатора
+var (
+	ErrUnterminatedQuote = errors.New("unterminated quote in command")
+	ErrQuoteNotClosed    = errors.New("closing quote must be followed by a space")
+	ErrInvalidEscape     = errors.New("invalid escape sequence")
+)
+
+// Tokenize – разбивает строку команды на аргументы по пробельным символам.
+//
+// Аргумент в двойных кавычках может содержать пробелы и escape-последовательности:
+// \n \r \t \a \b \f \v \\ \" \xNN (любой байт), \uNNNN и \UNNNNNNNN – то же, что выдаёт
+// strconv.Quote, поэтому ответ сервера можно скопировать обратно в команду без потерь.
+// В одинарных кавычках текст берётся как есть, экранируется только \'.
+// Пустые кавычки – пустой аргумент. Вне кавычек обратный слеш – обычный символ.
+func Tokenize(input string) ([]string, error) {
+	var tokens []string
+	i := 0
+	for {
+		for i < len(input) && isSpace(input[i]) {
+			i++
+		}
+		if i == len(input) {
+			return tokens, nil
+		}
+
+		var (
+			token string
+			err   error
+		)
+		switch input[i] {
+		case '"':
+			token, i, err = readDoubleQuoted(input, i+1)
+		case '\'':
+			token, i, err = readSingleQuoted(input, i+1)
+		default:
+			start := i
+			for i < len(input) && !isSpace(input[i]) {
+				i++
+			}
+			token = input[start:i]
+		}
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+}
+
+// readDoubleQuoted – содержимое двойных кавычек начиная с позиции i (после открывающей)
+func readDoubleQuoted(input string, i int) (string, int, error) {
+	var sb strings.Builder
+	for i < len(input) {
+		c := input[i]
+		switch c {
+		case '"':
+			return closeQuote(sb.String(), input, i+1)
+		case '\\':
+			if i+1 == len(input) {
+				return "", 0, ErrUnterminatedQuote
+			}
+			n, err := unescape(&sb, input[i+1:])
+			if err != nil {
+				return "", 0, err
+			}
+			i += 1 + n
+		default:
+			sb.WriteByte(c)
+			i++
+		}
+	}
+	return "", 0, ErrUnterminatedQuote
+}
+
+// readSingleQuoted – содержимое одинарных кавычек начиная с позиции i (после открывающей)
+func readSingleQuoted(input string, i int) (string, int, error) {
+	var sb strings.Builder
+	for i < len(input) {
+		c := input[i]
+		switch {
+		case c == '\'':
+			return closeQuote(sb.String(), input, i+1)
+		case c == '\\' && i+1 < len(input) && input[i+1] == '\'':
+			sb.WriteByte('\'')
+			i += 2
+		default:
+			sb.WriteByte(c)
+			i++
+		}
+	}
+	return "", 0, ErrUnterminatedQuote
+}
+
+// closeQuote – после закрывающей кавычки должен быть пробел или конец строки,
+// иначе `"a"b` молча склеился бы в один аргумент
+func closeQuote(token, input string, i int) (string, int, error) {
+	if i < len(input) && !isSpace(input[i]) {
+		return "", 0, ErrQuoteNotClosed
+	}
+	return token, i, nil
+}
+
+// unescape – пишет в sb символ escape-последовательности, начинающейся в s
+// (s – текст сразу после обратного слеша), и возвращает её длину
+func unescape(sb *strings.Builder, s string) (int, error) {
+	switch s[0] {
+	case 'n':
+		sb.WriteByte('\n')
+	case 'r':
+		sb.WriteByte('\r')
+	case 't':
+		sb.WriteByte('\t')
+	case 'a':
+		sb.WriteByte('\a')
+	case 'b':
+		sb.WriteByte('\b')
+	case 'f':
+		sb.WriteByte('\f')
+	case 'v':
+		sb.WriteByte('\v')
+	case '\\', '"', '\'':
+		sb.WriteByte(s[0])
+	case 'x':
+		if len(s) < 3 {
+			return 0, ErrInvalidEscape
+		}
+		b, err := strconv.ParseUint(s[1:3], 16, 8)
+		if err != nil {
+			return 0, ErrInvalidEscape
+		}
+		sb.WriteByte(byte(b))
+		return 3, nil
+	case 'u', 'U':
+		size := 5
+		if s[0] == 'U' {
+			size = 9
+		}
+		if len(s) < size {
+			return 0, ErrInvalidEscape
+		}
+		r, err := strconv.ParseUint(s[1:size], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(r)) {
+			return 0, ErrInvalidEscape
+		}
+		sb.WriteRune(rune(r))
+		return size, nil
+	default:
+		return 0, ErrInvalidEscape
+	}
+	return 1, nil
+}
+
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\r', '\v', '\f':
+		return true
+	}
+	return false
+}
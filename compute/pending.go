@@ -0,0 +1,39 @@
+package compute
+
+import (
+	"fmt"
+	"time"
+
+	"imkvdb/metrics"
+)
+
+// Pending – ответ на команду, запись которой, возможно, ещё ждёт fsync в WAL.
+// Команда уже применена: следующие команды соединения видят её результат, а ответ
+// клиенту отдаётся только после Wait. Так соединение может прислать несколько команд
+// подряд (pipelining), и их записи попадут в один батч WAL.
+type Pending struct {
+	result Result
+	done   <-chan error // nil – ждать нечего
+
+	// latency – куда записать длительность команды от start до готового ответа (см. observed)
+	latency *metrics.Histogram
+	start   time.Time
+}
+
+// Done – готовый ответ, которому не нужна запись в WAL
+func Done(res Result) Pending {
+	return Pending{result: res}
+}
+
+// Wait дожидается записи в WAL и возвращает ответ. Вызывается один раз.
+func (p Pending) Wait() Result {
+	if p.latency != nil {
+		defer func() { p.latency.ObserveDuration(time.Since(p.start)) }()
+	}
+	if p.done != nil {
+		if err := <-p.done; err != nil {
+			return ErrorResult(fmt.Errorf("failed to write WAL: %w", err))
+		}
+	}
+	return p.result
+}
@@ -0,0 +1,149 @@
+package compute
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"imkvdb/storage"
+)
+
+// Kind – вид ответа команды
+type Kind int
+
+const (
+	KindOK      Kind = iota // подтверждение без данных (OK, QUEUED) – текст в Str
+	KindNil                 // значения нет (GET отсутствующего ключа, EXEC отменён WATCH)
+	KindString              // строковое значение
+	KindInteger             // число (DEL, TTL, GETVER, ...)
+	KindArray               // массив ответов (EXEC)
+	KindError               // ошибка: код в Code, сообщение в Str
+)
+
+// ErrorCode – машиночитаемый код ошибки; клиенты сравнивают его, а не текст
+type ErrorCode string
+
+const (
+	CodeErr       ErrorCode = "ERR"       // прочие ошибки (в т.ч. запись в WAL)
+	CodeSyntax    ErrorCode = "SYNTAX"    // команда не разобрана
+	CodeNotFound  ErrorCode = "NOT_FOUND" // команде нужен существующий ключ
+	CodeWrongType ErrorCode = "WRONGTYPE" // операция над значением другого типа
+	CodeReadOnly  ErrorCode = "READONLY"  // запись на реплику
+	CodeExecAbort ErrorCode = "EXECABORT" // транзакция отклонена из-за ошибок в MULTI
+	CodeNoAuth    ErrorCode = "NOAUTH"    // соединение не прошло AUTH
+	CodeWrongPass ErrorCode = "WRONGPASS" // неверный пользователь или пароль
+	CodeNoPerm    ErrorCode = "NOPERM"    // команда или ключ запрещены пользователю
+	CodeOOM       ErrorCode = "OOM"       // достигнут memory.max_memory, вытеснять нечего
+	CodeMisconf   ErrorCode = "MISCONF"   // ошибка WAL: запись отключена до перезапуска
+)
+
+// Result – типизированный ответ команды. Протоколы (текстовый, RESP) кодируют его сами,
+// не разбирая текст.
+type Result struct {
+	Kind  Kind
+	Str   string // KindOK, KindString, KindError
+	Int   int64  // KindInteger
+	Items []Result
+	Code  ErrorCode // KindError
+}
+
+// Конструкторы ответов
+func OK() Result                  { return Result{Kind: KindOK, Str: "OK"} }
+func Status(s string) Result      { return Result{Kind: KindOK, Str: s} }
+func Nil() Result                 { return Result{Kind: KindNil} }
+func String(s string) Result      { return Result{Kind: KindString, Str: s} }
+func Integer(n int64) Result      { return Result{Kind: KindInteger, Int: n} }
+func Array(items []Result) Result { return Result{Kind: KindArray, Items: items} }
+
+// Strings – массив строковых ответов
+func Strings(values []string) Result {
+	items := make([]Result, len(values))
+	for i, v := range values {
+		items[i] = String(v)
+	}
+	return Array(items)
+}
+
+// Bool – 1/0, как в Redis для команд "сработало / нет"
+func Bool(ok bool) Result {
+	if ok {
+		return Integer(1)
+	}
+	return Integer(0)
+}
+
+// ErrorResult – ответ-ошибка. Код берётся из *Error, остальные ошибки получают CodeErr.
+func ErrorResult(err error) Result {
+	var e *Error
+	if errors.As(err, &e) {
+		return Result{Kind: KindError, Code: e.Code, Str: e.Msg}
+	}
+	if errors.Is(err, storage.ErrWrongType) {
+		return Result{Kind: KindError, Code: CodeWrongType, Str: err.Error()}
+	}
+	if errors.Is(err, storage.ErrOOM) {
+		return Result{Kind: KindError, Code: CodeOOM, Str: err.Error()}
+	}
+	return Result{Kind: KindError, Code: CodeErr, Str: err.Error()}
+}
+
+// Err – ошибка ответа (nil, если ответ не ошибка)
+func (r Result) Err() error {
+	if r.Kind != KindError {
+		return nil
+	}
+	return &Error{Code: r.Code, Msg: r.Str}
+}
+
+// String – текстовое представление ответа в одну строку. Его используют текстовый
+// протокол tcpserver, cmd/cli и cmd/client:
+//
+//	OK | QUEUED               – подтверждение
+//	(nil)                     – значения нет
+//	"value"                   – строка в кавычках Go (переводы строк и байты экранированы)
+//	(integer) 5               – число
+//	1) OK; 2) "v"             – массив, (empty array) – пустой;
+//	                            вложенный массив – в квадратных скобках: 1) "0"; 2) [1) "k"]
+//	ERROR: NOT_FOUND message  – ошибка с кодом
+func (r Result) String() string {
+	switch r.Kind {
+	case KindOK:
+		return r.Str
+	case KindNil:
+		return "(nil)"
+	case KindString:
+		return strconv.Quote(r.Str)
+	case KindInteger:
+		return "(integer) " + strconv.FormatInt(r.Int, 10)
+	case KindArray:
+		if len(r.Items) == 0 {
+			return "(empty array)"
+		}
+		parts := make([]string, len(r.Items))
+		for i, item := range r.Items {
+			s := item.String()
+			if item.Kind == KindArray {
+				s = "[" + s + "]"
+			}
+			parts[i] = strconv.Itoa(i+1) + ") " + s
+		}
+		return strings.Join(parts, "; ")
+	case KindError:
+		return "ERROR: " + string(r.Code) + " " + r.Str
+	default:
+		return "(unknown)"
+	}
+}
+
+// Error – ошибка команды с кодом
+type Error struct {
+	Code ErrorCode
+	Msg  string
+}
+
+func (e *Error) Error() string { return string(e.Code) + " " + e.Msg }
+
+// newError – ошибка с кодом
+func newError(code ErrorCode, msg string) *Error {
+	return &Error{Code: code, Msg: msg}
+}
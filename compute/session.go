@@ -0,0 +1,185 @@
+package compute
+
+import (
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+	"imkvdb/compute/parser"
+)
+
+// Session – состояние одного клиентского соединения: открытая транзакция MULTI/EXEC
+// и ключи под WATCH.
+// Не потокобезопасна: ей пользуется только горутина своего соединения.
+type Session struct {
+	c *compute
+
+	inMulti bool
+	queue   []parser.Command
+	// aborted – внутри MULTI была ошибка разбора, EXEC отклонит всю транзакцию
+	aborted bool
+	// watched – версии ключей на момент WATCH
+	watched map[string]uint64
+	// readOnly – соединение с репликой: модифицирующие команды отклоняются
+	readOnly bool
+	// watcher – наблюдение за соединением на время BLPOP
+	watcher BlockWatcher
+	// access – права пользователя соединения; nil – аутентификация выключена
+	access Access
+}
+
+// Access – аутентификация и права одного соединения (см. acl.Conn)
+type Access interface {
+	// Auth – вход пользователем name (команда AUTH); ошибка – вход не выполнен
+	Auth(name, password string) error
+	// Check – разрешена ли команда текущему пользователю соединения; ошибка – отказ
+	Check(cmd parser.Command) error
+}
+
+// errReadOnly – запись на реплику (данные на ней меняет только репликация)
+var errReadOnly = newError(CodeReadOnly, "You can't write against a read only replica")
+
+// SetReadOnly запрещает модифицирующие команды в этой сессии
+func (s *Session) SetReadOnly(readOnly bool) {
+	s.readOnly = readOnly
+}
+
+// SetAccess включает проверку прав: до AUTH команды отклоняются с NOAUTH,
+// после – по правам вошедшего пользователя
+func (s *Session) SetAccess(access Access) {
+	s.access = access
+}
+
+// Process – как Compute.Process, но с учётом состояния соединения
+func (s *Session) Process(input string) Result {
+	return s.Start(input).Wait()
+}
+
+// Start выполняет команду, но не ждёт fsync её записи в WAL: следующую команду
+// соединения можно начинать сразу, а ответы отдавать по порядку через Pending.Wait
+func (s *Session) Start(input string) Pending {
+	start := time.Now()
+	cmd, err := s.c.parser.Parse(input)
+	if err != nil {
+		return Done(s.parseFailed(err))
+	}
+	return observed(cmd, start, s.handle(cmd))
+}
+
+// ProcessArgs – как Process, но аргументы команды уже выделены (например, из массива RESP),
+// поэтому значения передаются как есть
+func (s *Session) ProcessArgs(args []string) Result {
+	return s.StartArgs(args).Wait()
+}
+
+// StartArgs – как Start, но для уже выделенных аргументов (см. ProcessArgs)
+func (s *Session) StartArgs(args []string) Pending {
+	start := time.Now()
+	cmd, err := s.c.parser.ParseArgs(args)
+	if err != nil {
+		return Done(s.parseFailed(err))
+	}
+	return observed(cmd, start, s.handle(cmd))
+}
+
+// parseFailed – ошибка разбора; внутри MULTI она отменяет всю транзакцию
+func (s *Session) parseFailed(err error) Result {
+	parseErrors.Inc()
+	s.c.logger.Error("failed to parse command", zap.Error(err))
+	if s.inMulti {
+		s.aborted = true
+	}
+	return ErrorResult(newError(CodeSyntax, err.Error()))
+}
+
+// handle выполняет разобранную команду с учётом состояния соединения
+func (s *Session) handle(cmd parser.Command) Pending {
+	if cmd.Type == parser.AUTH {
+		return Done(s.auth(cmd))
+	}
+	if s.access != nil {
+		if err := s.access.Check(cmd); err != nil {
+			if s.inMulti {
+				s.aborted = true
+			}
+			return Done(ErrorResult(err))
+		}
+	}
+
+	switch cmd.Type {
+	case parser.MULTI:
+		if s.inMulti {
+			return Done(ErrorResult(errors.New("MULTI calls can not be nested")))
+		}
+		s.inMulti = true
+		return Done(OK())
+	case parser.EXEC:
+		if !s.inMulti {
+			return Done(ErrorResult(errors.New("EXEC without MULTI")))
+		}
+		queue, aborted, watched := s.queue, s.aborted, s.watched
+		s.reset()
+		if aborted {
+			return Done(ErrorResult(newError(CodeExecAbort, "Transaction discarded because of previous errors")))
+		}
+		return s.c.exec(queue, watched)
+	case parser.DISCARD:
+		if !s.inMulti {
+			return Done(ErrorResult(errors.New("DISCARD without MULTI")))
+		}
+		s.reset()
+		return Done(OK())
+	case parser.WATCH:
+		if s.inMulti {
+			return Done(ErrorResult(errors.New("WATCH inside MULTI is not allowed")))
+		}
+		if s.watched == nil {
+			s.watched = make(map[string]uint64, len(cmd.Keys))
+		}
+		for key, version := range s.c.versions(cmd.Keys) {
+			// Повторный WATCH ключа не сдвигает уже запомненную версию
+			if _, ok := s.watched[key]; !ok {
+				s.watched[key] = version
+			}
+		}
+		return Done(OK())
+	case parser.UNWATCH:
+		s.watched = nil
+		return Done(OK())
+	}
+
+	if s.readOnly && IsWrite(cmd) {
+		if s.inMulti {
+			s.aborted = true
+		}
+		return Done(ErrorResult(errReadOnly))
+	}
+
+	if s.inMulti {
+		s.queue = append(s.queue, cmd)
+		return Done(Status("QUEUED"))
+	}
+	if cmd.Type == parser.BLPOP {
+		return Done(s.c.blockingPop(cmd, s.watcher))
+	}
+	return s.c.startCommand(cmd)
+}
+
+// auth – AUTH [username] password; выполняется сразу, даже внутри MULTI
+func (s *Session) auth(cmd parser.Command) Result {
+	if s.access == nil {
+		return ErrorResult(errors.New("AUTH called without any users configured"))
+	}
+	if err := s.access.Auth(cmd.Args[0], cmd.Args[1]); err != nil {
+		return ErrorResult(err)
+	}
+	return OK()
+}
+
+// reset закрывает транзакцию; WATCH действует только до ближайшего EXEC/DISCARD
+func (s *Session) reset() {
+	s.inMulti = false
+	s.queue = nil
+	s.aborted = false
+	s.watched = nil
+}
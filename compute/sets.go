@@ -0,0 +1,60 @@
+package compute
+
+import (
+	"sort"
+
+	"imkvdb/compute/parser"
+	"imkvdb/storage"
+)
+
+// combineSets – пересечение, объединение или разность (SINTER/SUNION/SDIFF и их *STORE-варианты)
+// множеств keys, по возрастанию. Отсутствующий ключ – пустое множество; ключ другого типа – ErrWrongType.
+func combineSets(tx storage.Tx, op parser.CommandType, keys []string) ([]string, error) {
+	sets := make([][]string, len(keys))
+	for i, key := range keys {
+		members, err := tx.SMembers(key)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = members
+	}
+
+	switch op {
+	case parser.SUNION, parser.SUNIONSTORE:
+		seen := make(map[string]struct{})
+		var union []string
+		for _, members := range sets {
+			for _, m := range members {
+				if _, ok := seen[m]; !ok {
+					seen[m] = struct{}{}
+					union = append(union, m)
+				}
+			}
+		}
+		sort.Strings(union)
+		return union, nil
+	case parser.SINTER, parser.SINTERSTORE:
+		// Элементы первого множества уже отсортированы, фильтр порядок не меняет
+		return filterMembers(sets[0], sets[1:], true), nil
+	default: // SDIFF, SDIFFSTORE
+		return filterMembers(sets[0], sets[1:], false), nil
+	}
+}
+
+// filterMembers оставляет элементы members, которые есть во всех others (in=true)
+// или ни в одном из них (in=false)
+func filterMembers(members []string, others [][]string, in bool) []string {
+	counts := make(map[string]int)
+	for _, other := range others {
+		for _, m := range other {
+			counts[m]++
+		}
+	}
+	var res []string
+	for _, m := range members {
+		if in && counts[m] == len(others) || !in && counts[m] == 0 {
+			res = append(res, m)
+		}
+	}
+	return res
+}
@@ -16,19 +16,88 @@ type WALConfig struct {
 	FlushingBatchTimeout time.Duration `yaml:"flushing_batch_timeout"` // по умолчанию 10ms
 	MaxSegmentSize       string        `yaml:"max_segment_size"`       // например "10MB"
 	DataDirectory        string        `yaml:"data_directory"`
+	ArchiveDirectory     string        `yaml:"archive_directory"` // куда переносить сегменты, покрытые снимком; пусто – удалять
+}
+
+// SnapshotConfig — конфигурация снимков (checkpoint) данных
+type SnapshotConfig struct {
+	Enabled       bool          `yaml:"enabled"`        // по умолчанию false
+	Interval      time.Duration `yaml:"interval"`       // по умолчанию 5m
+	DataDirectory string        `yaml:"data_directory"` // по умолчанию /tmp/snapshots
+}
+
+// Роли узла при репликации
+const (
+	RoleMaster  = "master"
+	RoleReplica = "replica"
+)
+
+// ReplicationConfig — конфигурация репликации leader–follower
+type ReplicationConfig struct {
+	Role          string        `yaml:"role"`           // "master" (по умолчанию) или "replica"
+	ListenAddress string        `yaml:"listen_address"` // master: адрес для подключения реплик; пусто – реплики не принимаются
+	LeaderAddress string        `yaml:"leader_address"` // replica: адрес лидера (его listen_address)
+	SyncInterval  time.Duration `yaml:"sync_interval"`  // replica: как часто запрашивать новые записи, по умолчанию 1s
+	// replica: пользователь лидера с категорией admin; нужен, если на лидере включён auth
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
 }
 
 // Config — основная структура конфигурации
 type Config struct {
-	Engine  EngineConfig  `yaml:"engine"`
-	Network NetworkConfig `yaml:"network"`
-	Logging LoggingConfig `yaml:"logging"`
-	WAL     WALConfig     `yaml:"wal"`
+	Engine      EngineConfig      `yaml:"engine"`
+	Network     NetworkConfig     `yaml:"network"`
+	Logging     LoggingConfig     `yaml:"logging"`
+	WAL         WALConfig         `yaml:"wal"`
+	Snapshot    SnapshotConfig    `yaml:"snapshot"`
+	Replication ReplicationConfig `yaml:"replication"`
+	Metrics     MetricsConfig     `yaml:"metrics"`
+	Auth        AuthConfig        `yaml:"auth"`
+	Memory      MemoryConfig      `yaml:"memory"`
+}
+
+// Политики вытеснения ключей при достижении memory.max_memory
+const (
+	EvictNoEviction  = "noeviction"   // не вытеснять: записи отклоняются с ошибкой OOM
+	EvictAllKeysLRU  = "allkeys-lru"  // ключи, к которым дольше всего не обращались
+	EvictAllKeysLFU  = "allkeys-lfu"  // ключи, к которым обращаются реже всего
+	EvictRandom      = "random"       // случайные ключи
+	EvictVolatileTTL = "volatile-ttl" // ключи с TTL, ближайшие к истечению
+)
+
+// MemoryConfig — лимит памяти данных и вытеснение ключей при его достижении
+type MemoryConfig struct {
+	MaxMemory      string `yaml:"max_memory"`      // напр. "512MB"; пусто или 0 – без лимита
+	EvictionPolicy string `yaml:"eviction_policy"` // см. Evict*; по умолчанию noeviction
+}
+
+// AuthConfig — пользователи и их права (команда AUTH); без пользователей аутентификация выключена
+type AuthConfig struct {
+	Users    []UserConfig `yaml:"users"`
+	AuditLog string       `yaml:"audit_log"` // файл журнала отказов и входов; пусто – общий лог
+	// MinPasswordIterations – наименьшее число итераций PBKDF2 в password_hash; 0 – 100000.
+	// Меньшие значения ослабляют хеши и нужны только в тестах.
+	MinPasswordIterations int `yaml:"min_password_iterations"`
+}
+
+// UserConfig — пользователь сервера
+type UserConfig struct {
+	Name         string   `yaml:"name"`
+	PasswordHash string   `yaml:"password_hash"` // "pbkdf2-sha256:<итерации>:<соль hex>:<хеш hex>", см. imkvdb-server -hash-password
+	Categories   []string `yaml:"categories"`    // разрешённые категории команд: read, write, admin
+	Keys         []string `yaml:"keys"`          // glob-шаблоны доступных ключей ("*" – все); пусто – ни одного
+}
+
+// MetricsConfig — экспорт метрик в формате Prometheus
+type MetricsConfig struct {
+	Address string `yaml:"address"` // адрес HTTP-эндпоинта /metrics, напр. "127.0.0.1:9323"; пусто – выключено
 }
 
 // EngineConfig — конфигурация движка
 type EngineConfig struct {
-	Type string `yaml:"type"` // Например, "in_memory" (наше единственное значение пока)
+	Type string `yaml:"type"` // имя зарегистрированного движка: "in_memory", "sharded", "ordered"
+	// Options – собственные опции выбранного движка; их разбирает сам движок (см. engine.Register)
+	Options yaml.Node `yaml:"options"`
 }
 
 // NetworkConfig — конфигурация TCP-сервера
@@ -39,8 +108,31 @@ type NetworkConfig struct {
 	// Можно хранить сырые строки и потом при запуске парсить (KB, MB и т.п.)
 	MaxMessageSize string        `yaml:"max_message_size"` // напр., "4KB"
 	IdleTimeout    time.Duration `yaml:"idle_timeout"`     // Можно распарсить напрямую time.ParseDuration
+
+	// RESPAddress – адрес для клиентов Redis (протокол RESP), напр. "127.0.0.1:6379"; пусто – выключено
+	RESPAddress string `yaml:"resp_address"`
+
+	// ShutdownTimeout – сколько при остановке ждать завершения начатых команд, по умолчанию 10s
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	// TLS – шифрование клиентских соединений (address и resp_address) и репликации
+	TLS TLSConfig `yaml:"tls"`
+}
+
+// TLSConfig — настройки TLS; без cert_file соединения открытые. Файлы перечитываются
+// при изменении, без перезапуска.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"` // сертификат сервера (PEM), на реплике – ещё и клиентский
+	KeyFile  string `yaml:"key_file"`  // его закрытый ключ (PEM)
+	// CAFile – CA (PEM) для проверки сертификатов клиентов, а на реплике – сертификата лидера
+	CAFile string `yaml:"ca_file"`
+	// RequireClientCert – mutual TLS: клиент без сертификата, подписанного ca_file, не подключится
+	RequireClientCert bool `yaml:"require_client_cert"`
 }
 
+// Enabled – включён ли TLS
+func (t TLSConfig) Enabled() bool { return t.CertFile != "" }
+
 // LoggingConfig — конфигурация логирования
 type LoggingConfig struct {
 	Level  string `yaml:"level"`  // "debug" / "info" / "error"
@@ -57,6 +149,7 @@ func LoadConfig(path string) (Config, error) {
 	cfg.Network.MaxConnections = 10
 	cfg.Network.MaxMessageSize = "4KB"
 	cfg.Network.IdleTimeout = 5 * time.Minute
+	cfg.Network.ShutdownTimeout = 10 * time.Second
 	cfg.Logging.Level = "info"
 	cfg.Logging.Output = "stdout"
 	cfg.WAL.Enabled = false
@@ -64,6 +157,12 @@ func LoadConfig(path string) (Config, error) {
 	cfg.WAL.FlushingBatchTimeout = 10 * time.Millisecond
 	cfg.WAL.MaxSegmentSize = "10MB"
 	cfg.WAL.DataDirectory = "/tmp/wal"
+	cfg.Snapshot.Enabled = false
+	cfg.Snapshot.Interval = 5 * time.Minute
+	cfg.Snapshot.DataDirectory = "/tmp/snapshots"
+	cfg.Replication.Role = RoleMaster
+	cfg.Replication.SyncInterval = time.Second
+	cfg.Memory.EvictionPolicy = EvictNoEviction
 
 	// Пытаемся прочитать файл (если не нашли, не падаем, а оставляем дефолты)
 	data, err := ioutil.ReadFile(path)
@@ -95,20 +194,72 @@ func LoadConfig(path string) (Config, error) {
 	if cfg.Network.IdleTimeout == 0 {
 		cfg.Network.IdleTimeout = 5 * time.Minute
 	}
+	if cfg.Network.ShutdownTimeout <= 0 {
+		cfg.Network.ShutdownTimeout = 10 * time.Second
+	}
 	if cfg.Logging.Level == "" {
 		cfg.Logging.Level = "info"
 	}
 	if cfg.Logging.Output == "" {
 		cfg.Logging.Output = "stdout"
 	}
+	if cfg.Snapshot.Interval <= 0 {
+		cfg.Snapshot.Interval = 5 * time.Minute
+	}
+	if cfg.Snapshot.DataDirectory == "" {
+		cfg.Snapshot.DataDirectory = "/tmp/snapshots"
+	}
+	if cfg.Replication.Role == "" {
+		cfg.Replication.Role = RoleMaster
+	}
+	if cfg.Replication.SyncInterval <= 0 {
+		cfg.Replication.SyncInterval = time.Second
+	}
+	if tls := cfg.Network.TLS; tls.Enabled() || tls.RequireClientCert {
+		if tls.CertFile == "" || tls.KeyFile == "" {
+			return cfg, errors.New("network.tls requires both cert_file and key_file")
+		}
+		if tls.RequireClientCert && tls.CAFile == "" {
+			return cfg, errors.New("network.tls.require_client_cert requires ca_file")
+		}
+	}
+	if cfg.Memory.EvictionPolicy == "" {
+		cfg.Memory.EvictionPolicy = EvictNoEviction
+	}
+	switch cfg.Memory.EvictionPolicy {
+	case EvictNoEviction, EvictAllKeysLRU, EvictAllKeysLFU, EvictRandom, EvictVolatileTTL:
+	default:
+		return cfg, errors.New("unknown memory.eviction_policy: " + cfg.Memory.EvictionPolicy)
+	}
+	if cfg.Memory.MaxMemory != "" {
+		if _, err := ParseSize(cfg.Memory.MaxMemory); err != nil {
+			return cfg, errors.New("invalid memory.max_memory: " + err.Error())
+		}
+	}
+	switch cfg.Replication.Role {
+	case RoleMaster:
+	case RoleReplica:
+		if cfg.Replication.LeaderAddress == "" {
+			return cfg, errors.New("replication.leader_address is required for role replica")
+		}
+	default:
+		return cfg, errors.New("unknown replication.role: " + cfg.Replication.Role)
+	}
 
 	return cfg, nil
 }
 
-// ParseSize — вспомогательная функция для парсинга "4KB" -> 4096, "1MB" -> 1048576
+// ParseSize — вспомогательная функция для парсинга "4KB" -> 4096, "1MB" -> 1048576, "2GB"
 func ParseSize(input string) (int, error) {
-	// Допустим, поддерживаем только KB, MB
+	// Допустим, поддерживаем только KB, MB, GB
 	input = strings.ToUpper(strings.TrimSpace(input))
+	if strings.HasSuffix(input, "GB") {
+		num, err := strconv.Atoi(strings.TrimSuffix(input, "GB"))
+		if err != nil {
+			return 0, err
+		}
+		return num * 1024 * 1024 * 1024, nil
+	}
 	if strings.HasSuffix(input, "KB") {
 		valStr := strings.TrimSuffix(input, "KB")
 		num, err := strconv.Atoi(valStr)
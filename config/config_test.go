@@ -33,6 +33,9 @@ func TestLoadConfig_DefaultsIfFileNotFound(t *testing.T) {
 	if cfg.Network.IdleTimeout != 5*time.Minute {
 		t.Errorf("expected default idle_timeout=5m, got %v", cfg.Network.IdleTimeout)
 	}
+	if cfg.Network.ShutdownTimeout != 10*time.Second {
+		t.Errorf("expected default shutdown_timeout=10s, got %v", cfg.Network.ShutdownTimeout)
+	}
 	if cfg.Logging.Level != "info" {
 		t.Errorf("expected default logging.level=info, got %s", cfg.Logging.Level)
 	}
@@ -104,6 +107,7 @@ func TestParseSize(t *testing.T) {
 	}{
 		{"4KB", 4 * 1024, false},
 		{"1MB", 1 * 1024 * 1024, false},
+		{"2GB", 2 * 1024 * 1024 * 1024, false},
 		{"512", 512, false},
 		{"abc", 0, true},
 		{"4MBx", 0, true},
@@ -156,6 +160,7 @@ logging:
 	defaults.Network.MaxConnections = 10
 	defaults.Network.MaxMessageSize = "4KB"
 	defaults.Network.IdleTimeout = 5 * time.Minute
+	defaults.Network.ShutdownTimeout = 10 * time.Second
 	defaults.Logging.Level = "info"
 	defaults.Logging.Output = "stdout"
 	defaults.WAL.Enabled = false
@@ -163,8 +168,91 @@ logging:
 	defaults.WAL.FlushingBatchSize = 100
 	defaults.WAL.FlushingBatchTimeout = 10 * time.Millisecond
 	defaults.WAL.MaxSegmentSize = "10MB"
+	defaults.Snapshot.Interval = 5 * time.Minute
+	defaults.Snapshot.DataDirectory = "/tmp/snapshots"
+	defaults.Replication.Role = config.RoleMaster
+	defaults.Replication.SyncInterval = time.Second
+	defaults.Memory.EvictionPolicy = config.EvictNoEviction
 
 	if !reflect.DeepEqual(cfg, defaults) {
 		t.Errorf("config not matching defaults after empty fields.\nGot: %#v\nWant: %#v", cfg, defaults)
 	}
 }
+
+func TestLoadConfig_ReplicationValidation(t *testing.T) {
+	for name, content := range map[string]string{
+		"unknown role":           "replication:\n  role: \"slave\"\n",
+		"replica without leader": "replication:\n  role: \"replica\"\n",
+	} {
+		tmpFile, err := ioutil.TempFile("", "config_test_*.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(tmpFile.Name())
+		if _, err := tmpFile.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+		tmpFile.Close()
+
+		if _, err := config.LoadConfig(tmpFile.Name()); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
+
+func TestLoadConfig_TLSValidation(t *testing.T) {
+	for content, wantErr := range map[string]bool{
+		"network:\n  tls:\n    cert_file: s.crt\n":                                                                          true,
+		"network:\n  tls:\n    require_client_cert: true\n":                                                                 true,
+		"network:\n  tls:\n    cert_file: s.crt\n    key_file: s.key\n":                                                     false,
+		"network:\n  tls:\n    cert_file: s.crt\n    key_file: s.key\n    require_client_cert: true\n":                      true,
+		"network:\n  tls:\n    cert_file: s.crt\n    key_file: s.key\n    ca_file: ca.crt\n    require_client_cert: true\n": false,
+	} {
+		tmpFile, err := ioutil.TempFile("", "config_test_*.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(tmpFile.Name())
+		if _, err := tmpFile.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+		tmpFile.Close()
+
+		cfg, err := config.LoadConfig(tmpFile.Name())
+		if (err != nil) != wantErr {
+			t.Errorf("%q: got error %v, wantErr=%v", content, err, wantErr)
+		}
+		if err == nil && !cfg.Network.TLS.Enabled() {
+			t.Errorf("%q: TLS is not enabled", content)
+		}
+	}
+}
+
+func TestLoadConfig_MemoryValidation(t *testing.T) {
+	for content, wantErr := range map[string]bool{
+		"memory:\n  max_memory: 512MB\n":                                  false,
+		"memory:\n  max_memory: 1GB\n  eviction_policy: allkeys-lru\n":    false,
+		"memory:\n  eviction_policy: volatile-ttl\n":                      false,
+		"memory:\n  eviction_policy: allkeys-lfu\n":                       false,
+		"memory:\n  max_memory: lots\n":                                   true,
+		"memory:\n  max_memory: 1MB\n  eviction_policy: allkeys-oldest\n": true,
+	} {
+		tmpFile, err := ioutil.TempFile("", "config_test_*.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(tmpFile.Name())
+		if _, err := tmpFile.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+		tmpFile.Close()
+
+		cfg, err := config.LoadConfig(tmpFile.Name())
+		if (err != nil) != wantErr {
+			t.Errorf("%q: got error %v, wantErr=%v", content, err, wantErr)
+		}
+		if err == nil && cfg.Memory.EvictionPolicy == "" {
+			t.Errorf("%q: eviction_policy has no default", content)
+		}
+	}
+}
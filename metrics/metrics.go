@@ -0,0 +1,384 @@
+// Package metrics – счётчики, gauge и гистограммы в текстовом формате Prometheus
+// (https://prometheus.io/docs/instrumenting/exposition_formats/).
+//
+// Пакеты объявляют свои метрики переменными уровня пакета через New* – они регистрируются
+// в Default, который отдаёт Server. Метрики потокобезопасны и дёшевы на горячем пути:
+// обновление – одна атомарная операция (у Vec – ещё поиск по меткам под RLock).
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefBuckets – границы гистограмм по умолчанию (секунды): от 50µs до 2.5s
+var DefBuckets = []float64{.00005, .0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+// collector – метрика или семейство метрик с метками
+type collector interface {
+	// write выводит значения; labels – уже отформатированные метки без скобок (может быть пусто)
+	write(w *bufio.Writer, name, labels string)
+}
+
+type family struct {
+	name, help, typ string
+	c               collector
+}
+
+// Registry – набор метрик одной страницы /metrics
+type Registry struct {
+	mu       sync.RWMutex
+	families map[string]family
+}
+
+// Default – реестр, в котором регистрируют метрики New* и который отдаёт Server
+var Default = NewRegistry()
+
+// NewRegistry – пустой реестр
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]family)}
+}
+
+// register добавляет метрику; повторное имя – паника, как и у Prometheus-клиента
+func (r *Registry) register(name, help, typ string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, dup := r.families[name]; dup {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.families[name] = family{name: name, help: help, typ: typ, c: c}
+}
+
+// WriteText выводит все метрики в текстовом формате Prometheus, по алфавиту имён
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.RLock()
+	families := make([]family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.RUnlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.name, f.typ)
+		f.c.write(bw, f.name, "")
+	}
+	return bw.Flush()
+}
+
+// Counter – монотонно растущее значение
+type Counter struct {
+	bits atomic.Uint64 // float64
+}
+
+// NewCounter регистрирует счётчик в Default
+func NewCounter(name, help string) *Counter { return Default.NewCounter(name, help) }
+
+// NewCounter регистрирует счётчик
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{}
+	r.register(name, help, "counter", c)
+	return c
+}
+
+// Inc увеличивает счётчик на 1
+func (c *Counter) Inc() { c.Add(1) }
+
+// Add увеличивает счётчик на v (v >= 0)
+func (c *Counter) Add(v float64) { addFloat(&c.bits, v) }
+
+// Value – текущее значение
+func (c *Counter) Value() float64 { return math.Float64frombits(c.bits.Load()) }
+
+func (c *Counter) write(w *bufio.Writer, name, labels string) {
+	writeSample(w, name, labels, c.Value())
+}
+
+// Gauge – значение, которое может и расти, и уменьшаться
+type Gauge struct {
+	bits atomic.Uint64 // float64
+}
+
+// NewGauge регистрирует gauge в Default
+func NewGauge(name, help string) *Gauge { return Default.NewGauge(name, help) }
+
+// NewGauge регистрирует gauge
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{}
+	r.register(name, help, "gauge", g)
+	return g
+}
+
+// Set выставляет значение
+func (g *Gauge) Set(v float64) { g.bits.Store(math.Float64bits(v)) }
+
+// Add прибавляет v (может быть отрицательным)
+func (g *Gauge) Add(v float64) { addFloat(&g.bits, v) }
+
+// Inc, Dec – изменение на единицу
+func (g *Gauge) Inc() { g.Add(1) }
+func (g *Gauge) Dec() { g.Add(-1) }
+
+// Value – текущее значение
+func (g *Gauge) Value() float64 { return math.Float64frombits(g.bits.Load()) }
+
+func (g *Gauge) write(w *bufio.Writer, name, labels string) {
+	writeSample(w, name, labels, g.Value())
+}
+
+// GaugeFunc – gauge, значение которого вычисляется при каждом сборе метрик
+type GaugeFunc struct {
+	mu sync.RWMutex
+	fn func() float64
+}
+
+// NewGaugeFunc регистрирует вычисляемый gauge в Default; fn можно задать позже через Set
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	return Default.NewGaugeFunc(name, help, fn)
+}
+
+// NewGaugeFunc регистрирует вычисляемый gauge
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{fn: fn}
+	r.register(name, help, "gauge", g)
+	return g
+}
+
+// Set заменяет функцию; nil – метрика не выводится
+func (g *GaugeFunc) Set(fn func() float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.fn = fn
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer, name, labels string) {
+	g.mu.RLock()
+	fn := g.fn
+	g.mu.RUnlock()
+	if fn != nil {
+		writeSample(w, name, labels, fn())
+	}
+}
+
+// Histogram – распределение значений по корзинам (накопительно, как в Prometheus)
+type Histogram struct {
+	upper  []float64       // верхние границы корзин по возрастанию, без +Inf
+	counts []atomic.Uint64 // не накопительно; последняя – +Inf
+	sum    atomic.Uint64   // float64
+	count  atomic.Uint64
+}
+
+// NewHistogram регистрирует гистограмму в Default; buckets nil – DefBuckets
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	return Default.NewHistogram(name, help, buckets)
+}
+
+// NewHistogram регистрирует гистограмму
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := newHistogram(buckets)
+	r.register(name, help, "histogram", h)
+	return h
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	upper := append([]float64(nil), buckets...)
+	sort.Float64s(upper)
+	return &Histogram{upper: upper, counts: make([]atomic.Uint64, len(upper)+1)}
+}
+
+// Observe добавляет значение
+func (h *Histogram) Observe(v float64) {
+	h.counts[sort.SearchFloat64s(h.upper, v)].Add(1)
+	addFloat(&h.sum, v)
+	h.count.Add(1)
+}
+
+// ObserveDuration добавляет длительность в секундах
+func (h *Histogram) ObserveDuration(d time.Duration) { h.Observe(d.Seconds()) }
+
+// Count – число наблюдений
+func (h *Histogram) Count() uint64 { return h.count.Load() }
+
+// Sum – сумма наблюдений
+func (h *Histogram) Sum() float64 { return math.Float64frombits(h.sum.Load()) }
+
+func (h *Histogram) write(w *bufio.Writer, name, labels string) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	var cumulative uint64
+	for i, upper := range h.upper {
+		cumulative += h.counts[i].Load()
+		writeSample(w, name+"_bucket", labels+sep+`le="`+formatFloat(upper)+`"`, float64(cumulative))
+	}
+	cumulative += h.counts[len(h.upper)].Load()
+	writeSample(w, name+"_bucket", labels+sep+`le="+Inf"`, float64(cumulative))
+	writeSample(w, name+"_sum", labels, h.Sum())
+	writeSample(w, name+"_count", labels, float64(cumulative))
+}
+
+// vec – семейство метрик одного типа, различающихся значениями меток
+type vec[M collector] struct {
+	labels   []string
+	newChild func() M
+
+	mu       sync.RWMutex
+	children map[string]labeled[M]
+}
+
+type labeled[M collector] struct {
+	values []string
+	m      M
+}
+
+func newVec[M collector](labels []string, newChild func() M) *vec[M] {
+	return &vec[M]{labels: labels, newChild: newChild, children: make(map[string]labeled[M])}
+}
+
+// with возвращает (создавая при первом обращении) метрику с данными значениями меток
+func (v *vec[M]) with(values []string) M {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: got %d label values, want %d", len(values), len(v.labels)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.RLock()
+	child, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return child.m
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if child, ok := v.children[key]; ok {
+		return child.m
+	}
+	child = labeled[M]{values: append([]string(nil), values...), m: v.newChild()}
+	v.children[key] = child
+	return child.m
+}
+
+func (v *vec[M]) write(w *bufio.Writer, name, _ string) {
+	v.mu.RLock()
+	children := make([]labeled[M], 0, len(v.children))
+	for _, child := range v.children {
+		children = append(children, child)
+	}
+	v.mu.RUnlock()
+	sort.Slice(children, func(i, j int) bool {
+		return strings.Join(children[i].values, "\xff") < strings.Join(children[j].values, "\xff")
+	})
+
+	for _, child := range children {
+		pairs := make([]string, len(v.labels))
+		for i, label := range v.labels {
+			pairs[i] = label + `="` + escapeLabel(child.values[i]) + `"`
+		}
+		child.m.write(w, name, strings.Join(pairs, ","))
+	}
+}
+
+// CounterVec – счётчики с метками
+type CounterVec struct{ *vec[*Counter] }
+
+// NewCounterVec регистрирует счётчики с метками labels в Default
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+// NewCounterVec регистрирует счётчики с метками labels
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{newVec(labels, func() *Counter { return &Counter{} })}
+	r.register(name, help, "counter", v)
+	return v
+}
+
+// WithLabelValues – счётчик с данными значениями меток (в порядке labels)
+func (v *CounterVec) WithLabelValues(values ...string) *Counter { return v.with(values) }
+
+// GaugeVec – gauge с метками
+type GaugeVec struct{ *vec[*Gauge] }
+
+// NewGaugeVec регистрирует gauge с метками labels в Default
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labels...)
+}
+
+// NewGaugeVec регистрирует gauge с метками labels
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{newVec(labels, func() *Gauge { return &Gauge{} })}
+	r.register(name, help, "gauge", v)
+	return v
+}
+
+// WithLabelValues – gauge с данными значениями меток (в порядке labels)
+func (v *GaugeVec) WithLabelValues(values ...string) *Gauge { return v.with(values) }
+
+// HistogramVec – гистограммы с метками
+type HistogramVec struct{ *vec[*Histogram] }
+
+// NewHistogramVec регистрирует гистограммы с метками labels в Default; buckets nil – DefBuckets
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labels...)
+}
+
+// NewHistogramVec регистрирует гистограммы с метками labels
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	v := &HistogramVec{newVec(labels, func() *Histogram { return newHistogram(buckets) })}
+	r.register(name, help, "histogram", v)
+	return v
+}
+
+// WithLabelValues – гистограмма с данными значениями меток (в порядке labels)
+func (v *HistogramVec) WithLabelValues(values ...string) *Histogram { return v.with(values) }
+
+// addFloat атомарно прибавляет v к float64, хранящемуся в bits
+func addFloat(bits *atomic.Uint64, v float64) {
+	for {
+		old := bits.Load()
+		if bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func writeSample(w *bufio.Writer, name, labels string, v float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteString("{" + labels + "}")
+	}
+	w.WriteString(" " + formatFloat(v) + "\n")
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
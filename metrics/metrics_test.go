@@ -0,0 +1,122 @@
+package metrics_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"imkvdb/metrics"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	r := metrics.NewRegistry()
+	requests := r.NewCounterVec("test_requests_total", "Requests by type", "type")
+	inflight := r.NewGauge("test_inflight", "In-flight requests")
+	r.NewGaugeFunc("test_keys", "Keys\nin store", func() float64 { return 42 })
+	latency := r.NewHistogram("test_latency_seconds", "Latency", []float64{0.1, 0.5})
+
+	requests.WithLabelValues("GET").Inc()
+	requests.WithLabelValues("GET").Inc()
+	requests.WithLabelValues(`SE"T`).Add(3)
+	inflight.Inc()
+	inflight.Inc()
+	inflight.Dec()
+	latency.Observe(0.05)
+	latency.Observe(0.1)
+	latency.Observe(0.3)
+	latency.ObserveDuration(time.Second)
+
+	var sb strings.Builder
+	if err := r.WriteText(&sb); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	want := `# HELP test_inflight In-flight requests
+# TYPE test_inflight gauge
+test_inflight 1
+# HELP test_keys Keys\nin store
+# TYPE test_keys gauge
+test_keys 42
+# HELP test_latency_seconds Latency
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{le="0.1"} 2
+test_latency_seconds_bucket{le="0.5"} 3
+test_latency_seconds_bucket{le="+Inf"} 4
+test_latency_seconds_sum 1.45
+test_latency_seconds_count 4
+# HELP test_requests_total Requests by type
+# TYPE test_requests_total counter
+test_requests_total{type="GET"} 2
+test_requests_total{type="SE\"T"} 3
+`
+	if got := sb.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegistry_LabeledHistogram(t *testing.T) {
+	r := metrics.NewRegistry()
+	h := r.NewHistogramVec("test_op_seconds", "Op latency", []float64{1}, "op", "result")
+	h.WithLabelValues("read", "ok").Observe(0.5)
+	h.WithLabelValues("read", "ok").Observe(2)
+
+	var sb strings.Builder
+	if err := r.WriteText(&sb); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	for _, line := range []string{
+		`test_op_seconds_bucket{op="read",result="ok",le="1"} 1`,
+		`test_op_seconds_bucket{op="read",result="ok",le="+Inf"} 2`,
+		`test_op_seconds_sum{op="read",result="ok"} 2.5`,
+		`test_op_seconds_count{op="read",result="ok"} 2`,
+	} {
+		if !strings.Contains(sb.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, sb.String())
+		}
+	}
+}
+
+func TestRegistry_DuplicatePanics(t *testing.T) {
+	r := metrics.NewRegistry()
+	r.NewCounter("test_total", "first")
+	defer func() {
+		if recover() == nil {
+			t.Error("duplicate registration did not panic")
+		}
+	}()
+	r.NewGauge("test_total", "second")
+}
+
+func TestServer(t *testing.T) {
+	r := metrics.NewRegistry()
+	r.NewCounter("test_total", "Test counter").Add(7)
+
+	srv := metrics.NewServer("127.0.0.1:0", r, zap.NewNop())
+	if err := srv.Start(); err != nil {
+		t.Fatalf("failed to start metrics server: %v", err)
+	}
+	defer srv.Shutdown(context.Background())
+	addr, err := srv.Addr()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if !strings.Contains(string(body), "test_total 7\n") {
+		t.Errorf("unexpected body:\n%s", body)
+	}
+}
@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// Server – HTTP-эндпоинт /metrics для Prometheus (адрес – metrics.address)
+type Server struct {
+	addr     string
+	registry *Registry
+	logger   *zap.Logger
+	listener net.Listener
+	srv      *http.Server
+}
+
+// NewServer – конструктор; registry nil – Default
+func NewServer(addr string, registry *Registry, logger *zap.Logger) *Server {
+	if registry == nil {
+		registry = Default
+	}
+	return &Server{addr: addr, registry: registry, logger: logger}
+}
+
+// Start – начинает слушать адрес и отдавать метрики
+func (s *Server) Start() error {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		s.logger.Error("failed to listen metrics", zap.Error(err))
+		return err
+	}
+	s.listener = ln
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.registry)
+	s.srv = &http.Server{Handler: mux}
+	s.logger.Info("metrics server started", zap.String("address", ln.Addr().String()))
+
+	go func() {
+		if err := s.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("metrics server failed", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+// Addr – фактический адрес (нужен, если слушаем порт :0)
+func (s *Server) Addr() (string, error) {
+	if s.listener == nil {
+		return "", errors.New("server is not listening")
+	}
+	return s.listener.Addr().String(), nil
+}
+
+// Shutdown – останавливает сервер, дожидаясь текущих запросов до отмены ctx
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.srv.Shutdown(ctx)
+	s.logger.Info("metrics server stopped")
+	return err
+}
+
+// ServeHTTP отдаёт метрики реестра в текстовом формате Prometheus
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = r.WriteText(w)
+}
@@ -0,0 +1,260 @@
+package replication
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"imkvdb/config"
+	"imkvdb/storage"
+	"imkvdb/tlsutil"
+	"imkvdb/wal"
+)
+
+// Target – хранилище реплики. Записи применяются через wal.Replayer,
+// Dump нужен при полной синхронизации, чтобы удалить ключи, которых нет в снимке лидера.
+type Target interface {
+	wal.Replayer
+	Dump() []storage.Entry
+}
+
+// Follower – сторона реплики: раз в sync_interval запрашивает у лидера записи
+// после своего последнего LSN и применяет их. При обрыве связи переподключается.
+//
+// Реплика хранит данные только в памяти: после перезапуска она догоняет лидера заново.
+type Follower struct {
+	cfg    config.ReplicationConfig
+	target Target
+	logger *zap.Logger
+	// tls – сертификаты для подключения к лидеру по TLS; nil – открытое соединение
+	tls *tlsutil.Reloader
+
+	// lastLSN – LSN последней применённой записи лидера
+	lastLSN atomic.Uint64
+
+	quitCh chan struct{}
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewFollower – конструктор
+func NewFollower(cfg config.ReplicationConfig, target Target, logger *zap.Logger) *Follower {
+	return &Follower{
+		cfg:    cfg,
+		target: target,
+		logger: logger,
+		quitCh: make(chan struct{}),
+	}
+}
+
+// SetTLS включает TLS: реплика проверяет сертификат лидера по cfg.CAFile
+// и предъявляет свой (cfg.CertFile) для mutual TLS. Вызывается до Start.
+func (f *Follower) SetTLS(cfg config.TLSConfig) error {
+	r, err := tlsutil.NewReloader(cfg, f.logger)
+	if err != nil {
+		return err
+	}
+	f.tls = r
+	return nil
+}
+
+// LastLSN – LSN последней применённой записи лидера
+func (f *Follower) LastLSN() uint64 {
+	return f.lastLSN.Load()
+}
+
+// Start запускает фоновую синхронизацию с лидером
+func (f *Follower) Start() {
+	f.wg.Add(1)
+	go f.run()
+}
+
+// Stop останавливает синхронизацию и закрывает соединение с лидером
+func (f *Follower) Stop() {
+	close(f.quitCh)
+	f.mu.Lock()
+	if f.conn != nil {
+		_ = f.conn.Close()
+	}
+	f.mu.Unlock()
+	f.wg.Wait()
+}
+
+func (f *Follower) run() {
+	defer f.wg.Done()
+	for {
+		if err := f.session(); err != nil {
+			select {
+			case <-f.quitCh:
+				return
+			default:
+			}
+			f.logger.Warn("replication session failed, reconnecting",
+				zap.String("leader", f.cfg.LeaderAddress),
+				zap.Error(err),
+			)
+		}
+		if !f.wait(f.cfg.SyncInterval) {
+			return
+		}
+	}
+}
+
+// wait ждёт d; false – пора завершаться
+func (f *Follower) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-f.quitCh:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// session – одно подключение к лидеру: синхронизация в цикле до ошибки или остановки
+func (f *Follower) session() error {
+	conn, err := f.dial()
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	select {
+	case <-f.quitCh:
+		f.mu.Unlock()
+		_ = conn.Close()
+		return nil
+	default:
+	}
+	f.conn = conn
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.conn = nil
+		f.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	f.logger.Info("Connected to replication leader",
+		zap.String("leader", f.cfg.LeaderAddress),
+		zap.Uint64("last_lsn", f.LastLSN()),
+	)
+	reader := bufio.NewReader(conn)
+	if f.cfg.User != "" {
+		_ = conn.SetDeadline(time.Now().Add(ioTimeout))
+		if err := f.auth(conn, reader); err != nil {
+			return err
+		}
+	}
+	for {
+		_ = conn.SetDeadline(time.Now().Add(ioTimeout))
+		n, err := f.syncOnce(conn, reader)
+		if err != nil {
+			return err
+		}
+		// Полная порция – у лидера, скорее всего, есть ещё: запрашиваем сразу
+		if n < batchLimit && !f.wait(f.cfg.SyncInterval) {
+			return nil
+		}
+	}
+}
+
+// dial подключается к лидеру, по TLS – если он включён
+func (f *Follower) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: ioTimeout}
+	if f.tls == nil {
+		return dialer.Dial("tcp", f.cfg.LeaderAddress)
+	}
+	return tls.DialWithDialer(dialer, "tcp", f.cfg.LeaderAddress, f.tls.ClientConfig())
+}
+
+// auth входит на лидера пользователем replication.user
+func (f *Follower) auth(conn net.Conn, reader *bufio.Reader) error {
+	if _, err := fmt.Fprintf(conn, "%s %s %s\n", cmdAuth, f.cfg.User, f.cfg.Password); err != nil {
+		return err
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	kind, rest, _ := strings.Cut(strings.TrimSuffix(line, "\n"), " ")
+	switch kind {
+	case msgOK:
+		return nil
+	case msgError:
+		return fmt.Errorf("leader auth error: %s", rest)
+	}
+	return fmt.Errorf("unexpected message %q", line)
+}
+
+// syncOnce запрашивает записи после LastLSN и применяет их. Возвращает число записей WAL в ответе.
+func (f *Follower) syncOnce(conn net.Conn, reader *bufio.Reader) (int, error) {
+	if _, err := fmt.Fprintf(conn, "%s %d\n", cmdSync, f.LastLSN()); err != nil {
+		return 0, err
+	}
+
+	kind, lsn, count, err := readHeader(reader)
+	if err != nil {
+		return 0, err
+	}
+	if kind == msgFullSync {
+		if err := f.applyFullSync(reader, lsn, count); err != nil {
+			return 0, err
+		}
+		if kind, _, count, err = readHeader(reader); err != nil {
+			return 0, err
+		}
+		if kind != msgRecords {
+			return 0, fmt.Errorf("unexpected %s after %s", kind, msgFullSync)
+		}
+	}
+
+	for i := 0; i < count; i++ {
+		rec, err := wal.ReadRecord(reader)
+		if err != nil {
+			return 0, fmt.Errorf("read record: %w", err)
+		}
+		if rec.LSN != f.LastLSN()+1 {
+			return 0, fmt.Errorf("unexpected LSN %d after %d", rec.LSN, f.LastLSN())
+		}
+		if err := wal.ApplyRecord(rec, f.target); err != nil {
+			return 0, fmt.Errorf("apply record LSN=%d: %w", rec.LSN, err)
+		}
+		f.lastLSN.Store(rec.LSN)
+	}
+	return count, nil
+}
+
+// applyFullSync заменяет данные реплики снимком лидера одной атомарной группой:
+// удаляет все текущие ключи и записывает ключи снимка
+func (f *Follower) applyFullSync(reader *bufio.Reader, lsn uint64, count int) error {
+	existing := f.target.Dump()
+	batch := make([]wal.Record, 0, len(existing)+count)
+	for _, e := range existing {
+		batch = append(batch, wal.Record{Op: wal.OpDel, Key: e.Key})
+	}
+	for i := 0; i < count; i++ {
+		rec, err := wal.ReadRecord(reader)
+		if err != nil {
+			return fmt.Errorf("read snapshot entry: %w", err)
+		}
+		batch = append(batch, rec)
+	}
+	if err := wal.ApplyRecord(wal.Record{Op: wal.OpBatch, Batch: batch}, f.target); err != nil {
+		return fmt.Errorf("apply snapshot: %w", err)
+	}
+	f.lastLSN.Store(lsn)
+	f.logger.Info("Replica resynced from leader snapshot",
+		zap.Uint64("lsn", lsn),
+		zap.Int("entries", count),
+	)
+	return nil
+}
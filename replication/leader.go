@@ -0,0 +1,256 @@
+package replication
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"imkvdb/acl"
+	"imkvdb/config"
+	"imkvdb/storage"
+	"imkvdb/tlsutil"
+	"imkvdb/wal"
+)
+
+// Log – WAL лидера: сколько записей поставлено в очередь и сколько уже на диске
+type Log interface {
+	LastLSN() uint64
+	SyncedLSN() uint64
+}
+
+// Source – откуда лидер берёт снимок для полной синхронизации (обычно compute.Compute)
+type Source interface {
+	Snapshot() (uint64, []storage.Entry, error)
+}
+
+// Leader – сторона мастера: принимает реплики и отдаёт им записи из сегментов WAL
+type Leader struct {
+	cfg    config.ReplicationConfig
+	walDir string
+	log    Log
+	src    Source
+	logger *zap.Logger
+	// tls – шифрование соединений с репликами (network.tls); без cert_file – открытые
+	tls config.TLSConfig
+	// acl – пользователи (auth.users); nil – реплики подключаются без AUTH
+	acl *acl.ACL
+
+	listener net.Listener
+	quitCh   chan struct{}
+	wg       sync.WaitGroup
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// NewLeader – конструктор. walDir – каталог сегментов WAL, из которого читаются записи.
+func NewLeader(cfg config.ReplicationConfig, walDir string, log Log, src Source, logger *zap.Logger) *Leader {
+	return &Leader{
+		cfg:    cfg,
+		walDir: walDir,
+		log:    log,
+		src:    src,
+		logger: logger,
+		quitCh: make(chan struct{}),
+		conns:  make(map[net.Conn]struct{}),
+	}
+}
+
+// SetTLS включает TLS для подключений реплик. Вызывается до Start.
+func (l *Leader) SetTLS(cfg config.TLSConfig) {
+	l.tls = cfg
+}
+
+// SetACL требует от реплик AUTH пользователем с категорией admin. Вызывается до Start.
+func (l *Leader) SetACL(a *acl.ACL) {
+	l.acl = a
+}
+
+// Start начинает принимать подключения реплик на cfg.ListenAddress
+func (l *Leader) Start() error {
+	ln, err := tlsutil.Listen(l.cfg.ListenAddress, l.tls, l.logger)
+	if err != nil {
+		return fmt.Errorf("replication listen error: %w", err)
+	}
+	l.listener = ln
+	l.logger.Info("Replication leader started", zap.String("address", ln.Addr().String()))
+
+	l.wg.Add(1)
+	go l.acceptLoop()
+	return nil
+}
+
+// Addr – фактический адрес (нужен, если слушаем порт :0)
+func (l *Leader) Addr() (string, error) {
+	if l.listener == nil {
+		return "", errors.New("replication leader is not listening")
+	}
+	return l.listener.Addr().String(), nil
+}
+
+// Stop закрывает listener и все соединения с репликами
+func (l *Leader) Stop() {
+	close(l.quitCh)
+	if l.listener != nil {
+		_ = l.listener.Close()
+	}
+	l.mu.Lock()
+	for conn := range l.conns {
+		_ = conn.Close()
+	}
+	l.mu.Unlock()
+
+	l.wg.Wait()
+	l.logger.Info("Replication leader stopped")
+}
+
+func (l *Leader) acceptLoop() {
+	defer l.wg.Done()
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			select {
+			case <-l.quitCh:
+				return
+			default:
+				l.logger.Error("failed to accept replica", zap.Error(err))
+				continue
+			}
+		}
+		l.mu.Lock()
+		l.conns[conn] = struct{}{}
+		l.mu.Unlock()
+
+		l.wg.Add(1)
+		go l.serveReplica(conn)
+	}
+}
+
+// serveReplica отвечает на запросы AUTH и SYNC одной реплики, пока она не отключится.
+// Ошибка запроса или входа закрывает соединение.
+func (l *Leader) serveReplica(conn net.Conn) {
+	defer l.wg.Done()
+	defer func() {
+		l.mu.Lock()
+		delete(l.conns, conn)
+		l.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	remote := conn.RemoteAddr().String()
+	l.logger.Info("Replica connected", zap.String("remote", remote))
+
+	var access *acl.Conn
+	if l.acl != nil {
+		access = l.acl.NewConn(remote)
+	}
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	fail := func(err error) {
+		fmt.Fprintf(writer, "%s %v\n", msgError, err)
+		_ = writer.Flush()
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			l.logger.Info("Replica disconnected", zap.String("remote", remote), zap.Error(err))
+			return
+		}
+		cmd, arg, _ := strings.Cut(strings.TrimSuffix(line, "\n"), " ")
+		if cmd == cmdAuth {
+			// Без auth.users AUTH принимается: реплике можно задать пользователя заранее
+			name, password, _ := strings.Cut(arg, " ")
+			if access != nil {
+				if err := access.Auth(name, password); err != nil {
+					fail(err)
+					return
+				}
+			}
+			fmt.Fprintf(writer, "%s\n", msgOK)
+			if err := writer.Flush(); err != nil {
+				return
+			}
+			continue
+		}
+		lsn, err := strconv.ParseUint(arg, 10, 64)
+		if cmd != cmdSync || err != nil {
+			fail(fmt.Errorf("invalid request %q", strings.TrimSpace(line)))
+			return
+		}
+		if access != nil {
+			if err := access.CheckCategory(acl.Admin, cmdSync); err != nil {
+				fail(err)
+				return
+			}
+		}
+
+		_ = conn.SetWriteDeadline(time.Now().Add(ioTimeout))
+		if err := l.sync(writer, lsn); err != nil {
+			l.logger.Error("replication sync failed", zap.String("remote", remote), zap.Error(err))
+			fmt.Fprintf(writer, "%s %v\n", msgError, err)
+		}
+		if err := writer.Flush(); err != nil {
+			l.logger.Info("Replica disconnected", zap.String("remote", remote), zap.Error(err))
+			return
+		}
+	}
+}
+
+// sync отправляет реплике всё, что записано после её lsn:
+// при необходимости сначала снимок (FULLSYNC), затем записи WAL (RECORDS)
+func (l *Leader) sync(w *bufio.Writer, lsn uint64) error {
+	synced := l.log.SyncedLSN()
+
+	recs, err := l.readRecords(lsn, synced)
+	if errors.Is(err, wal.ErrLSNUnavailable) || lsn > l.log.LastLSN() {
+		// Записей после lsn в WAL нет (или реплика "впереди" лидера – у неё чужая история):
+		// отдаём снимок целиком, дальше реплика продолжит с его LSN
+		snapLSN, entries, snapErr := l.src.Snapshot()
+		if snapErr != nil {
+			return snapErr
+		}
+		l.logger.Info("Full resync of replica",
+			zap.Uint64("replica_lsn", lsn),
+			zap.Uint64("snapshot_lsn", snapLSN),
+			zap.Int("entries", len(entries)),
+		)
+		fmt.Fprintf(w, "%s %d %d\n", msgFullSync, snapLSN, len(entries))
+		var buf []byte
+		for _, e := range entries {
+			buf = wal.AppendRecord(buf[:0], entryRecord(e))
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+		}
+		recs, err = l.readRecords(snapLSN, synced)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "%s %d\n", msgRecords, len(recs))
+	var buf []byte
+	for _, rec := range recs {
+		buf = wal.AppendRecord(buf[:0], rec)
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRecords – не больше batchLimit записей WAL с after < LSN <= upto
+func (l *Leader) readRecords(after, upto uint64) ([]wal.Record, error) {
+	var recs []wal.Record
+	err := wal.ReadRecords(l.walDir, after, upto, batchLimit, func(rec wal.Record) error {
+		recs = append(recs, rec)
+		return nil
+	})
+	return recs, err
+}
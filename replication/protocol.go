@@ -0,0 +1,91 @@
+package replication
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"imkvdb/storage"
+	"imkvdb/wal"
+)
+
+// Протокол репликации (поверх TCP, одно соединение на реплику):
+//
+//	реплика -> лидер: "AUTH <user> <password>\n" – вход, если на лидере включён auth
+//	лидер -> реплика: "OK\n" или "ERROR <текст>\n" (соединение закрывается)
+//	реплика -> лидер: "SYNC <lsn>\n" – прислать записи после lsn
+//	лидер -> реплика: ["FULLSYNC <lsn> <n>\n" + n записей снимка]
+//	                  "RECORDS <n>\n" + n записей WAL
+//	                  или "ERROR <текст>\n"
+//
+// Записи передаются в бинарном формате WAL (wal.AppendRecord), поэтому значения
+// с пробелами и двоичными данными доходят без искажений, а повреждение ловится по CRC.
+// С auth.users лидер отвечает на SYNC только пользователю с категорией admin:
+// снимок и WAL содержат все ключи, поэтому шаблоны ключей тут не помогут.
+// FULLSYNC отправляется, если нужных записей в WAL лидера уже нет (сегменты убраны
+// после снимка): реплика заменяет свои данные снимком и продолжает с его LSN.
+const (
+	cmdAuth     = "AUTH"
+	cmdSync     = "SYNC"
+	msgOK       = "OK"
+	msgFullSync = "FULLSYNC"
+	msgRecords  = "RECORDS"
+	msgError    = "ERROR"
+
+	// batchLimit – максимум записей WAL в одном ответе; получив столько,
+	// реплика сразу запрашивает следующую порцию, не дожидаясь sync_interval
+	batchLimit = 1000
+
+	// ioTimeout – таймаут одного обмена запрос/ответ
+	ioTimeout = 30 * time.Second
+)
+
+// entryRecord – запись снимка в виде операции, создающей значение целиком
+// (OpSet для строки, OpHSet со всеми полями для хеша, OpRPush, OpZAdd и OpSAdd со всеми элементами
+// для списка, сортированного множества и множества), со временем истечения и версией ключа
+func entryRecord(e storage.Entry) wal.Record {
+	rec := wal.Record{Op: wal.OpSet, Key: e.Key, Value: e.Value, Version: e.Version}
+	switch e.Type {
+	case storage.TypeHash:
+		rec = wal.Record{Op: wal.OpHSet, Key: e.Key, Args: e.Items, Version: e.Version}
+	case storage.TypeList:
+		rec = wal.Record{Op: wal.OpRPush, Key: e.Key, Args: e.Items, Version: e.Version}
+	case storage.TypeZSet:
+		rec = wal.Record{Op: wal.OpZAdd, Key: e.Key, Args: e.Items, Version: e.Version}
+	case storage.TypeSet:
+		rec = wal.Record{Op: wal.OpSAdd, Key: e.Key, Args: e.Items, Version: e.Version}
+	}
+	if !e.ExpireAt.IsZero() {
+		rec.ExpireAt = e.ExpireAt.UnixMilli()
+	}
+	return rec
+}
+
+// readHeader читает строку-заголовок ответа: тип, LSN (только для FULLSYNC) и число записей
+func readHeader(r *bufio.Reader) (kind string, lsn uint64, count int, err error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", 0, 0, err
+	}
+	line = strings.TrimSuffix(line, "\n")
+	kind, rest, _ := strings.Cut(line, " ")
+	switch kind {
+	case msgError:
+		return "", 0, 0, fmt.Errorf("leader error: %s", rest)
+	case msgFullSync:
+		lsnStr, countStr, _ := strings.Cut(rest, " ")
+		if lsn, err = strconv.ParseUint(lsnStr, 10, 64); err != nil {
+			return "", 0, 0, fmt.Errorf("invalid FULLSYNC LSN %q: %w", lsnStr, err)
+		}
+		rest = countStr
+	case msgRecords:
+	default:
+		return "", 0, 0, fmt.Errorf("unexpected message %q", line)
+	}
+	if count, err = strconv.Atoi(rest); err != nil || count < 0 {
+		return "", 0, 0, fmt.Errorf("invalid record count in %q", line)
+	}
+	return kind, lsn, count, nil
+}
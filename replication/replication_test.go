@@ -0,0 +1,386 @@
+package replication_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"imkvdb/acl"
+	"imkvdb/compute"
+	"imkvdb/compute/parser"
+	"imkvdb/config"
+	"imkvdb/replication"
+	"imkvdb/storage/engine"
+	"imkvdb/tcpserver"
+	"imkvdb/wal"
+)
+
+// leaderNode – мастер: FileWAL, compute, TCP-сервер и сервер репликации
+type leaderNode struct {
+	dir    string // каталог WAL
+	wal    *wal.FileWAL
+	srv    *tcpserver.TCPServer
+	leader *replication.Leader
+}
+
+// startLeader; memory – лимит памяти мастера (пустой – без лимита),
+// access – пользователи для входа реплик (nil – без AUTH)
+func startLeader(t *testing.T, maxSegmentSize string, memory config.MemoryConfig, access *acl.ACL) *leaderNode {
+	t.Helper()
+	logger := zap.NewNop()
+	dir := t.TempDir()
+
+	w, err := wal.NewFileWAL(config.WALConfig{
+		Enabled:              true,
+		FlushingBatchSize:    10,
+		FlushingBatchTimeout: 5 * time.Millisecond,
+		MaxSegmentSize:       maxSegmentSize,
+		DataDirectory:        dir,
+	}, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eng := engine.NewInMemoryEngine(logger)
+	cmp := compute.NewCompute(parser.NewParser(), eng, w, logger)
+	evictor, err := engine.NewEvictor(eng, memory, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evictor != nil {
+		cmp.SetEvictor(evictor)
+	}
+
+	cfg := serverConfig()
+	cfg.Replication.Role = config.RoleMaster
+	cfg.Replication.ListenAddress = "127.0.0.1:0"
+
+	node := &leaderNode{dir: dir, wal: w, srv: tcpserver.NewTCPServer(cfg, cmp, logger)}
+	if err := node.srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	node.leader = replication.NewLeader(cfg.Replication, dir, w, cmp, logger)
+	node.leader.SetACL(access)
+	if err := node.leader.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		node.leader.Stop()
+		node.srv.Stop()
+		_ = w.Close()
+	})
+	return node
+}
+
+// startReplica – реплика с собственным TCP-сервером (только чтение)
+func startReplica(t *testing.T, leaderAddr string) (*tcpserver.TCPServer, *replication.Follower) {
+	t.Helper()
+	return startReplicaAs(t, leaderAddr, "", "")
+}
+
+// startReplicaAs – реплика, входящая на лидера пользователем user
+func startReplicaAs(t *testing.T, leaderAddr, user, password string) (*tcpserver.TCPServer, *replication.Follower) {
+	t.Helper()
+	logger := zap.NewNop()
+
+	eng := engine.NewInMemoryEngine(logger)
+	cmp := compute.NewCompute(parser.NewParser(), eng, &wal.NoOpWAL{}, logger)
+
+	cfg := serverConfig()
+	cfg.Replication.Role = config.RoleReplica
+	cfg.Replication.LeaderAddress = leaderAddr
+	cfg.Replication.SyncInterval = 10 * time.Millisecond
+	cfg.Replication.User = user
+	cfg.Replication.Password = password
+
+	srv := tcpserver.NewTCPServer(cfg, cmp, logger)
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	follower := replication.NewFollower(cfg.Replication, eng, logger)
+	follower.Start()
+	t.Cleanup(func() {
+		follower.Stop()
+		srv.Stop()
+	})
+	return srv, follower
+}
+
+func serverConfig() config.Config {
+	cfg := config.Config{}
+	cfg.Network.Address = "127.0.0.1:0"
+	cfg.Network.MaxConnections = 5
+	cfg.Network.MaxMessageSize = "4KB"
+	cfg.Network.IdleTimeout = 2 * time.Second
+	return cfg
+}
+
+// client – соединение с TCP-сервером; закрывается раньше остановки сервера
+func client(t *testing.T, srv *tcpserver.TCPServer) func(cmd string) string {
+	t.Helper()
+	addr, _ := srv.Addr()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial %s: %v", addr, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	reader := bufio.NewReader(conn)
+	return func(cmd string) string {
+		t.Helper()
+		if _, err := fmt.Fprintf(conn, "%s\n", cmd); err != nil {
+			t.Fatalf("failed to send %q: %v", cmd, err)
+		}
+		resp, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read response to %q: %v", cmd, err)
+		}
+		return strings.TrimSpace(resp)
+	}
+}
+
+// waitLSN ждёт, пока реплика применит записи лидера до lsn включительно
+func waitLSN(t *testing.T, follower *replication.Follower, lsn uint64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for follower.LastLSN() < lsn {
+		if time.Now().After(deadline) {
+			t.Fatalf("replica did not catch up: LSN %d, want %d", follower.LastLSN(), lsn)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReplication_StreamsWAL(t *testing.T) {
+	leader := startLeader(t, "10MB", config.MemoryConfig{}, nil)
+	leaderAddr, _ := leader.leader.Addr()
+	replicaSrv, follower := startReplica(t, leaderAddr)
+
+	master := client(t, leader.srv)
+	replica := client(t, replicaSrv)
+
+	for _, cmd := range []string{
+		"SET a 1",
+		"SET b 2",
+		"SET tmp x EX 100",
+		"DEL b",
+		"MULTI",
+		"SET c 3",
+		"SET a 10",
+		"HSET h f1 v1",
+		"EXEC",
+		"HSET h f2 v2 f3 v3",
+		"HDEL h f3",
+		"INCRBY hits 5",
+		"EXPIRE hits 100",
+		"INCRBYFLOAT hits 0.5",
+	} {
+		master(cmd)
+	}
+	waitLSN(t, follower, leader.wal.LastLSN())
+
+	for cmd, want := range map[string]string{
+		"GET a":      `"10"`,
+		"GET b":      "(nil)",
+		"GET c":      `"3"`,
+		"TTL tmp":    "(integer) 100",
+		"GETVER a":   master("GETVER a"),
+		"GETVER tmp": master("GETVER tmp"),
+		"HGETALL h":  `1) "f1"; 2) "v1"; 3) "f2"; 4) "v2"`,
+		"GETVER h":   master("GETVER h"),
+		"GET hits":   `"5.5"`,
+		"TTL hits":   "(integer) 100",
+	} {
+		if got := replica(cmd); got != want {
+			t.Errorf("replica %s: got %q, want %q", cmd, got, want)
+		}
+	}
+
+	// Реплика только для чтения
+	if got := replica("SET a 2"); !strings.HasPrefix(got, "ERROR: READONLY ") {
+		t.Errorf("replica SET: got %q, want READONLY error", got)
+	}
+	if got := replica("GET a"); got != `"10"` {
+		t.Errorf("replica GET after rejected SET: got %q, want 10", got)
+	}
+
+	// Новые записи доезжают и после первой синхронизации
+	master("SET d 4")
+	waitLSN(t, follower, leader.wal.LastLSN())
+	if got := replica("GET d"); got != `"4"` {
+		t.Errorf("replica GET d: got %q, want 4", got)
+	}
+
+	// MSET и MDEL – одна запись WAL на всю группу ключей
+	lsn := leader.wal.LastLSN()
+	master("MSET m1 a m2 b m3 c")
+	master("MDEL m1 m3 missing")
+	if got := leader.wal.LastLSN(); got != lsn+2 {
+		t.Errorf("MSET+MDEL took %d WAL records, want 2", got-lsn)
+	}
+	waitLSN(t, follower, leader.wal.LastLSN())
+	if got := replica("MGET m1 m2 m3"); got != `1) (nil); 2) "b"; 3) (nil)` {
+		t.Errorf("replica MGET: got %q", got)
+	}
+}
+
+func TestReplication_FullResyncAfterTruncation(t *testing.T) {
+	// Маленькие сегменты, чтобы записи разошлись по нескольким файлам
+	leader := startLeader(t, "256", config.MemoryConfig{}, nil)
+	master := client(t, leader.srv)
+	for i := 0; i < 50; i++ {
+		master(fmt.Sprintf("SET key%d value%d", i, i))
+	}
+	master("DEL key0")
+	master("HSET hash f1 v1 f2 v2")
+	master("EXPIRE hash 100")
+	master("RPUSH list a b c")
+	master("LPOP list")
+	master("ZADD board 1.5 ann -inf zed 3 bob")
+	master("SADD a x y z")
+	master("SADD b y")
+	master("SDIFFSTORE diff a b")
+
+	// Как после снимка: ранние сегменты убраны, с LSN 0 догнать по WAL нельзя
+	removed, err := leader.wal.RemoveSegmentsUpTo(leader.wal.LastLSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed == 0 {
+		t.Fatal("expected some WAL segments to be removed")
+	}
+
+	leaderAddr, _ := leader.leader.Addr()
+	replicaSrv, follower := startReplica(t, leaderAddr)
+	waitLSN(t, follower, leader.wal.LastLSN())
+
+	master("SET after resync")
+	waitLSN(t, follower, leader.wal.LastLSN())
+
+	replica := client(t, replicaSrv)
+	for cmd, want := range map[string]string{
+		"GET key0":                     "(nil)",
+		"GET key1":                     `"value1"`,
+		"GET key49":                    `"value49"`,
+		"GET after":                    `"resync"`,
+		"HGET hash f2":                 `"v2"`,
+		"TTL hash":                     "(integer) 100",
+		"LRANGE list 0 -1":             `1) "b"; 2) "c"`,
+		"ZRANGE board 0 -1 WITHSCORES": `1) "zed"; 2) "-inf"; 3) "ann"; 4) "1.5"; 5) "bob"; 6) "3"`,
+		"SMEMBERS diff":                `1) "x"; 2) "z"`,
+	} {
+		if got := replica(cmd); got != want {
+			t.Errorf("replica %s: got %q, want %q", cmd, got, want)
+		}
+	}
+}
+
+// TestReplication_Eviction – ключи, вытесненные мастером по max_memory, пишутся в WAL
+// как удаления: на реплике и после реплея WAL остаются те же ключи, что и на мастере
+func TestReplication_Eviction(t *testing.T) {
+	leader := startLeader(t, "10MB", config.MemoryConfig{
+		MaxMemory: "4KB", EvictionPolicy: config.EvictAllKeysLRU,
+	}, nil)
+	leaderAddr, _ := leader.leader.Addr()
+	replicaSrv, follower := startReplica(t, leaderAddr)
+
+	master := client(t, leader.srv)
+	replica := client(t, replicaSrv)
+
+	value := strings.Repeat("v", 100)
+	for i := 0; i < 100; i++ {
+		if got := master(fmt.Sprintf("SET key%d %s", i, value)); got != "OK" {
+			t.Fatalf("SET key%d: %s", i, got)
+		}
+	}
+	// Последний записанный ключ только что использован и не вытесняется
+	if got := master("GET key99"); got != `"`+value+`"` {
+		t.Errorf("GET key99: got %q", got)
+	}
+	keys := master("KEYS *")
+	if got := master("DBSIZE"); got == "(integer) 100" {
+		t.Fatalf("no keys were evicted: %s", got)
+	}
+
+	waitLSN(t, follower, leader.wal.LastLSN())
+	if got := replica("KEYS *"); got != keys {
+		t.Errorf("replica keys differ from master:\nreplica: %s\nmaster:  %s", got, keys)
+	}
+
+	replayed := engine.NewInMemoryEngine(zap.NewNop())
+	if _, err := wal.ReplayWAL(leader.dir, 0, replayed, zap.NewNop()); err != nil {
+		t.Fatalf("ReplayWAL: %v", err)
+	}
+	_, replayedKeys := replayed.Scan(0, -1)
+	sort.Strings(replayedKeys)
+	if got := compute.Strings(replayedKeys).String(); got != keys {
+		t.Errorf("replayed WAL keys differ from master:\nreplayed: %s\nmaster:   %s", got, keys)
+	}
+}
+
+// testHashIterations – дешёвый PBKDF2 для тестов: с 600000 итераций вход под -race
+// занимает секунды и не укладывается в таймауты соединений
+const testHashIterations = 1000
+
+func TestReplication_Auth(t *testing.T) {
+	replicatorHash, _ := acl.HashPasswordIterations("repl pass", testHashIterations)
+	readerHash, _ := acl.HashPasswordIterations("r", testHashIterations)
+	access, err := acl.New(config.AuthConfig{MinPasswordIterations: testHashIterations, Users: []config.UserConfig{
+		{Name: "replicator", PasswordHash: replicatorHash, Categories: []string{"admin"}},
+		{Name: "reader", PasswordHash: readerHash, Categories: []string{"read"}, Keys: []string{"*"}},
+	}}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("acl.New: %v", err)
+	}
+	leader := startLeader(t, "10MB", config.MemoryConfig{}, access)
+	leaderAddr, _ := leader.leader.Addr()
+	master := client(t, leader.srv)
+	if got := master("SET a 1"); got != "OK" {
+		t.Fatalf("SET a: %s", got)
+	}
+
+	// Без AUTH, с неверным паролем и без категории admin записи не отдаются
+	for _, tc := range []struct {
+		name    string
+		request string
+		want    string
+	}{
+		{"no auth", "SYNC 0", "ERROR NOAUTH"},
+		{"wrong password", "AUTH replicator wrong", "ERROR WRONGPASS"},
+		{"no admin", "AUTH reader r\nSYNC 0", "ERROR NOPERM"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", leaderAddr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			fmt.Fprintf(conn, "%s\n", tc.request)
+			reader := bufio.NewReader(conn)
+			var last string
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					break
+				}
+				last = strings.TrimSpace(line)
+			}
+			if !strings.HasPrefix(last, tc.want) {
+				t.Errorf("got %q, want prefix %q", last, tc.want)
+			}
+		})
+	}
+
+	_, denied := startReplicaAs(t, leaderAddr, "reader", "r")
+	replicaSrv, follower := startReplicaAs(t, leaderAddr, "replicator", "repl pass")
+	waitLSN(t, follower, leader.wal.LastLSN())
+	if got := client(t, replicaSrv)("GET a"); got != `"1"` {
+		t.Errorf("GET a on replica: %s", got)
+	}
+	if got := denied.LastLSN(); got != 0 {
+		t.Errorf("replica without admin category synced up to LSN %d", got)
+	}
+}
@@ -0,0 +1,200 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"imkvdb/compute"
+	"imkvdb/compute/parser"
+)
+
+// Протокол Redis (RESP2/RESP3).
+//
+// Запрос – массив bulk-строк ("*2\r\n$3\r\nGET\r\n$1\r\nk\r\n") или inline-строка ("GET k\r\n"),
+// которую отправляют redis-cli и telnet (кавычки и escape – как в текстовом протоколе, parser.Tokenize).
+// Аргументы массива передаются как есть, поэтому ключи и значения могут содержать пробелы,
+// переводы строк и любые байты.
+// Ответы кодируются в RESP2 или, после HELLO 3, в RESP3 (отличаются null и map).
+
+// maxArgs – защита от мусорной длины массива
+const maxArgs = 1024 * 1024
+
+// errProtocol – клиент прислал не RESP; соединение после ответа закрывается
+var errProtocol = errors.New("Protocol error")
+
+// readCommand читает одну команду. Пустая inline-строка – nil без ошибки.
+// maxSize ограничивает суммарный размер команды (как max_message_size текстового протокола).
+func readCommand(r *bufio.Reader, maxSize int) ([]string, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] != '*' {
+		line, err := readLine(r, maxSize)
+		if err != nil {
+			return nil, err
+		}
+		args, err := parser.Tokenize(line)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errProtocol, err)
+		}
+		return args, nil
+	}
+
+	header, err := readLine(r, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(header[1:])
+	if err != nil || n > maxArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errProtocol)
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+
+	// n пришло от клиента: память под аргументы растёт по мере чтения, а не выделяется
+	// заранее по заголовку (иначе короткий "*1048576" стоит 16MB)
+	args := make([]string, 0, min(n, 1024))
+	size := len(header)
+	for i := 0; i < n; i++ {
+		line, err := readLine(r, maxSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$', got %q", errProtocol, line)
+		}
+		l, err := strconv.Atoi(line[1:])
+		if err != nil || l < 0 {
+			return nil, fmt.Errorf("%w: invalid bulk length", errProtocol)
+		}
+		size += len(line) + l
+		if size > maxSize {
+			return nil, fmt.Errorf("%w: message too large", errProtocol)
+		}
+		buf := make([]byte, l+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if buf[l] != '\r' || buf[l+1] != '\n' {
+			return nil, fmt.Errorf("%w: bulk string is not terminated by CRLF", errProtocol)
+		}
+		args = append(args, string(buf[:l]))
+	}
+	return args, nil
+}
+
+// readLine читает строку до "\n" и отрезает "\r\n"
+func readLine(r *bufio.Reader, maxSize int) (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		line = append(line, chunk...)
+		if len(line) > maxSize {
+			return "", fmt.Errorf("%w: message too large", errProtocol)
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+// writer кодирует ответы в RESP2 или RESP3. Им пользуется только горутина,
+// отправляющая ответы соединения (Server.writeReplies).
+type writer struct {
+	w     *bufio.Writer
+	proto int // 2 или 3
+}
+
+func (w *writer) simple(s string) {
+	w.w.WriteByte('+')
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+// error – строка ошибки; первое слово – код (ERR, WRONGTYPE, READONLY, ...)
+func (w *writer) error(msg string) {
+	// Переводы строк внутри ошибки сломали бы протокол
+	msg = strings.NewReplacer("\r", " ", "\n", " ").Replace(msg)
+	w.w.WriteByte('-')
+	w.w.WriteString(msg)
+	w.w.WriteString("\r\n")
+}
+
+func (w *writer) integer(n int64) {
+	w.w.WriteByte(':')
+	w.w.WriteString(strconv.FormatInt(n, 10))
+	w.w.WriteString("\r\n")
+}
+
+func (w *writer) bulk(s string) {
+	w.w.WriteByte('$')
+	w.w.WriteString(strconv.Itoa(len(s)))
+	w.w.WriteString("\r\n")
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+// null – отсутствующее значение (nil в клиентах)
+func (w *writer) null() {
+	if w.proto == 3 {
+		w.w.WriteString("_\r\n")
+		return
+	}
+	w.w.WriteString("$-1\r\n")
+}
+
+// nullArray – отсутствующий массив (EXEC, отменённый из-за WATCH)
+func (w *writer) nullArray() {
+	if w.proto == 3 {
+		w.w.WriteString("_\r\n")
+		return
+	}
+	w.w.WriteString("*-1\r\n")
+}
+
+func (w *writer) array(n int) {
+	w.w.WriteByte('*')
+	w.w.WriteString(strconv.Itoa(n))
+	w.w.WriteString("\r\n")
+}
+
+// mapHeader – заголовок map из n пар; в RESP2 это плоский массив ключей и значений
+func (w *writer) mapHeader(n int) {
+	if w.proto == 3 {
+		w.w.WriteByte('%')
+		w.w.WriteString(strconv.Itoa(n))
+		w.w.WriteString("\r\n")
+		return
+	}
+	w.array(2 * n)
+}
+
+// result кодирует ответ compute в соответствующий тип RESP
+func (w *writer) result(res compute.Result) {
+	switch res.Kind {
+	case compute.KindOK:
+		w.simple(res.Str)
+	case compute.KindNil:
+		w.null()
+	case compute.KindString:
+		w.bulk(res.Str)
+	case compute.KindInteger:
+		w.integer(res.Int)
+	case compute.KindArray:
+		w.array(len(res.Items))
+		for _, item := range res.Items {
+			w.result(item)
+		}
+	case compute.KindError:
+		w.error(errorCode(res.Code) + " " + res.Str)
+	}
+}
@@ -0,0 +1,348 @@
+package resp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"imkvdb/acl"
+	"imkvdb/compute"
+	"imkvdb/config"
+	"imkvdb/tcpserver"
+	"imkvdb/tlsutil"
+)
+
+// Server – фронтенд протокола Redis поверх compute.Compute (адрес – network.resp_address).
+// Лимиты соединений, idle timeout и max_message_size те же, что у текстового tcpserver.
+type Server struct {
+	cfg       config.Config
+	cmp       compute.Compute
+	logger    *zap.Logger
+	listener  net.Listener
+	quitCh    chan struct{}
+	wg        sync.WaitGroup
+	conns     tcpserver.ConnSet
+	connLimit chan struct{}
+	// acl – пользователи и права; nil – аутентификация выключена
+	acl *acl.ACL
+}
+
+// NewServer – конструктор
+func NewServer(cfg config.Config, cmp compute.Compute, logger *zap.Logger) *Server {
+	return &Server{
+		cfg:       cfg,
+		cmp:       cmp,
+		logger:    logger,
+		quitCh:    make(chan struct{}),
+		conns:     tcpserver.ConnSet{Protocol: "resp"},
+		connLimit: make(chan struct{}, cfg.Network.MaxConnections),
+	}
+}
+
+// SetACL включает аутентификацию, как tcpserver.TCPServer.SetACL. Вызывается до Start.
+func (s *Server) SetACL(a *acl.ACL) {
+	s.acl = a
+}
+
+// Start – начинает слушать network.resp_address
+func (s *Server) Start() error {
+	ln, err := tlsutil.Listen(s.cfg.Network.RESPAddress, s.cfg.Network.TLS, s.logger)
+	if err != nil {
+		s.logger.Error("failed to listen RESP", zap.Error(err))
+		return err
+	}
+	s.listener = ln
+	s.logger.Info("RESP server started",
+		zap.String("address", ln.Addr().String()),
+		zap.Bool("tls", s.cfg.Network.TLS.Enabled()),
+	)
+
+	go s.acceptLoop()
+	return nil
+}
+
+// Addr – фактический адрес (нужен, если слушаем порт :0)
+func (s *Server) Addr() (string, error) {
+	if s.listener == nil {
+		return "", errors.New("server is not listening")
+	}
+	return s.listener.Addr().String(), nil
+}
+
+// Stop – останавливает приём соединений и ждёт завершения обработчиков
+func (s *Server) Stop() {
+	_ = s.Shutdown(context.Background())
+}
+
+// Shutdown – плавная остановка, как у tcpserver.TCPServer.Shutdown: уже полученные
+// команды выполняются и получают ответы; по отмене ctx соединения закрываются
+func (s *Server) Shutdown(ctx context.Context) error {
+	close(s.quitCh)
+	s.listener.Close()
+	err := tcpserver.Drain(ctx, &s.wg, &s.conns)
+	if err != nil {
+		s.logger.Warn("RESP shutdown timed out, connections closed", zap.Error(err))
+	}
+	s.logger.Info("RESP server stopped")
+	return err
+}
+
+// stopping – вызван ли Shutdown
+func (s *Server) stopping() bool {
+	select {
+	case <-s.quitCh:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Server) acceptLoop() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.quitCh:
+				return
+			default:
+				s.logger.Error("failed to accept", zap.Error(err))
+				continue
+			}
+		}
+
+		select {
+		case s.connLimit <- struct{}{}:
+			s.wg.Add(1)
+			s.conns.Add(conn)
+			go s.handleConnection(conn)
+		default:
+			s.logger.Warn("too many connections, rejecting RESP client")
+			s.conns.Reject()
+			_, _ = io.WriteString(conn, "-ERR max number of clients reached\r\n")
+			_ = conn.Close()
+		}
+	}
+}
+
+// handleConnection читает команды подряд и выполняет их, не дожидаясь fsync WAL:
+// ответы ждёт и кодирует отдельная горутина (writeReplies) в порядке команд, как в
+// tcpserver. Поэтому записи конвейера (pipelining) попадают в один батч WAL,
+// а ответы уходят одной записью, когда готовых больше нет.
+func (s *Server) handleConnection(netConn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		<-s.connLimit
+		s.conns.Remove(netConn)
+		if err := netConn.Close(); err != nil {
+			s.logger.Error("failed to close connection", zap.Error(err))
+		}
+	}()
+
+	maxSizeBytes, _ := config.ParseSize(s.cfg.Network.MaxMessageSize)
+	reader := bufio.NewReader(netConn)
+	replies := make(chan reply, tcpserver.MaxPipelined)
+	c := &conn{
+		session: s.cmp.NewSession(),
+		replies: replies,
+		proto:   2,
+		replica: s.cfg.Replication.Role == config.RoleReplica,
+	}
+	c.session.SetReadOnly(c.replica)
+	if s.acl != nil {
+		c.session.SetAccess(s.acl.NewConn(netConn.RemoteAddr().String()))
+	}
+	c.session.SetBlockWatcher(tcpserver.ConnWatcher(netConn, reader, s.quitCh, s.cfg.Network.IdleTimeout))
+
+	writerDone := make(chan struct{})
+	go s.writeReplies(netConn, replies, writerDone)
+	// Отправляем ответы на всё, что уже прочитано, и только потом закрываем соединение
+	defer func() {
+		close(replies)
+		<-writerDone
+	}()
+
+	for {
+		if s.cfg.Network.IdleTimeout > 0 {
+			_ = netConn.SetReadDeadline(time.Now().Add(s.cfg.Network.IdleTimeout))
+		}
+		// При остановке выполняем только уже полученные команды
+		if reader.Buffered() == 0 && s.stopping() {
+			return
+		}
+		args, err := readCommand(reader, maxSizeBytes)
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				c.send(errorResult(compute.CodeErr, err.Error()))
+			}
+			s.logger.Info("RESP client disconnected", zap.Error(err))
+			return
+		}
+		if len(args) > 0 && !c.handle(args) {
+			return
+		}
+	}
+}
+
+// reply – ответ на одну команду. encode вызывает writeReplies, когда готов результат
+// pending, поэтому ответы (и переключение протокола в HELLO) идут в порядке команд.
+type reply struct {
+	pending compute.Pending
+	encode  func(w *writer, res compute.Result)
+}
+
+// writeReplies отправляет ответы в порядке команд: дожидается каждого (fsync WAL)
+// и сбрасывает буфер, когда готовых ответов больше нет. После ошибки записи ответы
+// больше не отправляются, а чтение команд прерывается.
+func (s *Server) writeReplies(netConn net.Conn, replies <-chan reply, done chan<- struct{}) {
+	defer close(done)
+
+	out := &writer{w: bufio.NewWriter(netConn), proto: 2}
+	var writeErr error
+	for r := range replies {
+		res := r.pending.Wait()
+		if writeErr != nil {
+			continue // дочитываем очередь, чтобы не блокировать читателя
+		}
+		if s.cfg.Network.IdleTimeout > 0 {
+			_ = netConn.SetWriteDeadline(time.Now().Add(s.cfg.Network.IdleTimeout))
+		}
+		r.encode(out, res)
+		if len(replies) == 0 {
+			writeErr = out.w.Flush()
+		}
+		if writeErr != nil {
+			s.logger.Info("RESP client disconnected", zap.Error(writeErr))
+			// Дедлайн в прошлом будит читателя, и соединение закрывается
+			_ = netConn.SetReadDeadline(time.Now())
+		}
+	}
+}
+
+// conn – состояние одного RESP-соединения на стороне чтения команд
+type conn struct {
+	session *compute.Session
+	replies chan<- reply
+	// proto – версия протокола после последнего принятого HELLO
+	proto   int
+	replica bool
+}
+
+// send ставит в очередь готовый ответ
+func (c *conn) send(res compute.Result) {
+	c.replies <- reply{pending: compute.Done(res), encode: (*writer).result}
+}
+
+// errorResult – ошибка с кодом RESP, которого нет среди кодов compute (NOPROTO) или
+// с текстом, как у Redis
+func errorResult(code compute.ErrorCode, msg string) compute.Result {
+	return compute.Result{Kind: compute.KindError, Code: code, Str: msg}
+}
+
+// handle выполняет одну команду; false – клиент попросил закрыть соединение (QUIT)
+func (c *conn) handle(args []string) bool {
+	name := strings.ToUpper(args[0])
+	switch name {
+	case "PING":
+		if len(args) > 1 {
+			c.send(compute.String(args[1]))
+		} else {
+			c.send(compute.Status("PONG"))
+		}
+		return true
+	case "ECHO":
+		if len(args) != 2 {
+			c.send(errorResult(compute.CodeErr, "wrong number of arguments for 'echo' command"))
+		} else {
+			c.send(compute.String(args[1]))
+		}
+		return true
+	case "HELLO":
+		c.hello(args[1:])
+		return true
+	case "QUIT":
+		c.send(compute.OK())
+		return false
+	case "COMMAND":
+		// redis-cli запрашивает описание команд при старте; нам отдавать нечего
+		c.send(compute.Array(nil))
+		return true
+	case "CLIENT":
+		// CLIENT SETNAME / SETINFO, которые шлют клиентские библиотеки, принимаем молча
+		c.send(compute.OK())
+		return true
+	}
+
+	// Ответы на предыдущие команды writeReplies отправит, пока BLPOP ждёт данных
+	encode := (*writer).result
+	if name == "EXEC" || name == "BLPOP" {
+		encode = nilAsNullArray
+	}
+	c.replies <- reply{pending: c.session.StartArgs(args), encode: encode}
+	return true
+}
+
+// nilAsNullArray – транзакцию отменил WATCH или BLPOP не дождался данных: в RESP2 это nil-массив
+func nilAsNullArray(w *writer, res compute.Result) {
+	if res.Kind == compute.KindNil {
+		w.nullArray()
+		return
+	}
+	w.result(res)
+}
+
+// hello – HELLO [protover [AUTH username password]]: переключение RESP2/RESP3,
+// вход пользователем и сведения о сервере
+func (c *conn) hello(args []string) {
+	proto := c.proto
+	if len(args) > 0 {
+		var err error
+		proto, err = strconv.Atoi(args[0])
+		if err != nil || (proto != 2 && proto != 3) {
+			c.send(errorResult("NOPROTO", "unsupported protocol version"))
+			return
+		}
+	}
+	if len(args) > 1 {
+		if len(args) != 4 || !strings.EqualFold(args[1], "AUTH") {
+			c.send(errorResult(compute.CodeErr, "syntax error in HELLO option"))
+			return
+		}
+		if res := c.session.ProcessArgs([]string{"AUTH", args[2], args[3]}); res.Kind == compute.KindError {
+			c.send(res)
+			return
+		}
+	}
+	c.proto = proto
+	role := config.RoleMaster
+	if c.replica {
+		role = config.RoleReplica
+	}
+	c.replies <- reply{encode: func(w *writer, _ compute.Result) {
+		w.proto = proto
+		w.mapHeader(4)
+		w.bulk("server")
+		w.bulk("imkvdb")
+		w.bulk("proto")
+		w.integer(int64(proto))
+		w.bulk("mode")
+		w.bulk("standalone")
+		w.bulk("role")
+		w.bulk(role)
+	}}
+}
+
+// errorCode – код ошибки RESP. Ошибки разбора в Redis имеют общий код ERR,
+// остальные коды compute (NOT_FOUND, READONLY, EXECABORT, ...) передаются как есть
+func errorCode(code compute.ErrorCode) string {
+	if code == compute.CodeSyntax {
+		return string(compute.CodeErr)
+	}
+	return string(code)
+}
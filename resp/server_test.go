@@ -0,0 +1,383 @@
+package resp_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"imkvdb/acl"
+	"imkvdb/compute"
+	"imkvdb/compute/parser"
+	"imkvdb/config"
+	"imkvdb/resp"
+	"imkvdb/storage/engine"
+	"imkvdb/wal"
+)
+
+func startServer(t *testing.T) string {
+	t.Helper()
+	return startServerWith(t, engine.NewInMemoryEngine(zap.NewNop()))
+}
+
+// startServerWith – сервер поверх заданного движка
+func startServerWith(t *testing.T, eng engine.Engine) string {
+	t.Helper()
+	logger := zap.NewNop()
+
+	cfg := config.Config{}
+	cfg.Network.RESPAddress = "127.0.0.1:0"
+	cfg.Network.MaxConnections = 5
+	cfg.Network.MaxMessageSize = "4KB"
+	cfg.Network.IdleTimeout = 2 * time.Second
+
+	cmp := compute.NewCompute(parser.NewParser(), eng, &wal.NoOpWAL{}, logger)
+	srv := resp.NewServer(cfg, cmp, logger)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("failed to start RESP server: %v", err)
+	}
+	t.Cleanup(srv.Stop)
+	addr, _ := srv.Addr()
+	return addr
+}
+
+// dial – соединение с функцией "отправить запрос и проверить ответ байт в байт"
+func dial(t *testing.T, addr string) func(request, want string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	reader := bufio.NewReader(conn)
+	return func(request, want string) {
+		t.Helper()
+		if _, err := io.WriteString(conn, request); err != nil {
+			t.Fatalf("failed to send %q: %v", request, err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		got := make([]byte, len(want))
+		if _, err := io.ReadFull(reader, got); err != nil {
+			t.Fatalf("request %q: got %q, read error: %v", request, got, err)
+		}
+		if string(got) != want {
+			t.Errorf("request %q: got %q, want %q", request, got, want)
+		}
+	}
+}
+
+// command кодирует аргументы как массив bulk-строк
+func command(args ...string) string {
+	var sb strings.Builder
+	sb.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		sb.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	return sb.String()
+}
+
+func TestRESP_Commands(t *testing.T) {
+	send := dial(t, startServer(t))
+
+	value := "hello world\r\nwith \x00 binary"
+	send(command("SET", "key with space", value), "+OK\r\n")
+	send(command("GET", "key with space"), "$"+strconv.Itoa(len(value))+"\r\n"+value+"\r\n")
+	send(command("GET", "missing"), "$-1\r\n")
+	send(command("TTL", "missing"), ":-2\r\n")
+	send(command("SET", "tmp", "v", "EX", "100"), "+OK\r\n")
+	send(command("TTL", "tmp"), ":100\r\n")
+	send(command("DEL", "tmp"), ":1\r\n")
+	send(command("DEL", "tmp"), ":0\r\n")
+	send(command("CAS", "key with space", "nope", "x"), ":0\r\n")
+	send(command("NOSUCH"), "-ERR unknown command\r\n")
+	send(command("SET", "k"), "-ERR SET command requires 2 arguments: key and value\r\n")
+
+	// inline-команды (redis-cli, telnet)
+	send("PING\r\n", "+PONG\r\n")
+	send("SET inline 42\r\n", "+OK\r\n")
+	send("GET inline\r\n", "$2\r\n42\r\n")
+}
+
+func TestRESP_Pipeline(t *testing.T) {
+	send := dial(t, startServer(t))
+
+	// Несколько команд одной записью – ответы в том же порядке
+	send(
+		command("SET", "a", "1")+command("SET", "b", "2")+command("GET", "a")+"GET b\r\n"+command("GET", "c"),
+		"+OK\r\n+OK\r\n$1\r\n1\r\n$1\r\n2\r\n$-1\r\n",
+	)
+}
+
+// Записи конвейера уходят в WAL общим батчем: следующая команда выполняется, не дожидаясь
+// fsync предыдущей, а ответы (и переключение протокола в HELLO) идут в порядке команд
+func TestRESP_PipelineBatchesWAL(t *testing.T) {
+	logger := zap.NewNop()
+
+	cfg := config.Config{}
+	cfg.Network.RESPAddress = "127.0.0.1:0"
+	cfg.Network.MaxConnections = 5
+	cfg.Network.MaxMessageSize = "4KB"
+	cfg.Network.IdleTimeout = 5 * time.Second
+
+	// Батч сбрасывается по таймеру: без конвейера каждая запись ждала бы его целиком
+	wl, err := wal.NewFileWAL(config.WALConfig{
+		FlushingBatchSize:    1000,
+		FlushingBatchTimeout: 50 * time.Millisecond,
+		MaxSegmentSize:       "1MB",
+		DataDirectory:        t.TempDir(),
+	}, 0, logger)
+	if err != nil {
+		t.Fatalf("failed to create WAL: %v", err)
+	}
+	defer wl.Close()
+
+	cmp := compute.NewCompute(parser.NewParser(), engine.NewInMemoryEngine(logger), wl, logger)
+	srv := resp.NewServer(cfg, cmp, logger)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("failed to start RESP server: %v", err)
+	}
+	defer srv.Stop()
+	addr, _ := srv.Addr()
+	send := dial(t, addr)
+
+	const n = 100
+	var request, want strings.Builder
+	for i := 1; i <= n; i++ {
+		request.WriteString(command("INCR", "counter"))
+		want.WriteString(":" + strconv.Itoa(i) + "\r\n")
+	}
+	request.WriteString(command("GET", "missing") + command("HELLO", "3") + command("GET", "missing"))
+	want.WriteString("$-1\r\n%4\r\n$6\r\nserver\r\n$6\r\nimkvdb\r\n$5\r\nproto\r\n:3\r\n" +
+		"$4\r\nmode\r\n$10\r\nstandalone\r\n$4\r\nrole\r\n$6\r\nmaster\r\n_\r\n")
+
+	start := time.Now()
+	send(request.String(), want.String())
+	if elapsed := time.Since(start); elapsed > n*50*time.Millisecond/4 {
+		t.Errorf("pipeline took %v, writes were not batched", elapsed)
+	}
+	if got := wl.SyncedLSN(); got != n {
+		t.Errorf("synced LSN = %d, want %d", got, n)
+	}
+}
+
+func TestRESP_Transactions(t *testing.T) {
+	send := dial(t, startServer(t))
+
+	send(command("MULTI"), "+OK\r\n")
+	send(command("SET", "a", "1"), "+QUEUED\r\n")
+	send(command("GET", "a"), "+QUEUED\r\n")
+	send(command("GET", "missing"), "+QUEUED\r\n")
+	send(command("DEL", "a"), "+QUEUED\r\n")
+	send(command("EXEC"), "*4\r\n+OK\r\n$1\r\n1\r\n$-1\r\n:1\r\n")
+	send(command("EXEC"), "-ERR EXEC without MULTI\r\n")
+
+	send(command("MULTI"), "+OK\r\n")
+	send(command("BOGUS"), "-ERR unknown command\r\n")
+	send(command("EXEC"), "-EXECABORT Transaction discarded because of previous errors\r\n")
+
+	// Ключ под WATCH изменился до EXEC -> nil-массив
+	send(command("SET", "w", "1"), "+OK\r\n")
+	send(command("WATCH", "w"), "+OK\r\n")
+	send(command("SET", "w", "2"), "+OK\r\n")
+	send(command("MULTI"), "+OK\r\n")
+	send(command("SET", "w", "3"), "+QUEUED\r\n")
+	send(command("EXEC"), "*-1\r\n")
+}
+
+func TestRESP_Hello3(t *testing.T) {
+	send := dial(t, startServer(t))
+
+	send(command("HELLO", "3"),
+		"%4\r\n$6\r\nserver\r\n$6\r\nimkvdb\r\n$5\r\nproto\r\n:3\r\n"+
+			"$4\r\nmode\r\n$10\r\nstandalone\r\n$4\r\nrole\r\n$6\r\nmaster\r\n")
+	send(command("GET", "missing"), "_\r\n")
+	send(command("HELLO", "4"), "-NOPROTO unsupported protocol version\r\n")
+}
+
+func TestRESP_ProtocolError(t *testing.T) {
+	send := dial(t, startServer(t))
+	send("*1\r\n+GET\r\n", "-ERR Protocol error: expected '$', got \"+GET\"\r\n")
+}
+
+func TestRESP_InlineQuotes(t *testing.T) {
+	send := dial(t, startServer(t))
+
+	send("SET k \"hello world\\n\"\r\n", "+OK\r\n")
+	send(command("GET", "k"), "$12\r\nhello world\n\r\n")
+	send("SET k \"open\r\n", "-ERR Protocol error: unterminated quote in command\r\n")
+}
+
+func TestRESP_BlockingPop(t *testing.T) {
+	addr := startServer(t)
+	consumer, producer := dial(t, addr), dial(t, addr)
+
+	consumer(command("BLPOP", "q", "0.05"), "*-1\r\n")
+	producer(command("RPUSH", "q", "job"), ":1\r\n")
+	consumer(command("BLPOP", "q", "1"), "*2\r\n$1\r\nq\r\n$3\r\njob\r\n")
+	consumer(command("LPOP", "q"), "$-1\r\n")
+}
+
+func TestRESP_SortedSet(t *testing.T) {
+	send := dial(t, startServer(t))
+
+	send(command("ZADD", "board", "10", "bob", "2.5", "ann", "10", "al"), ":3\r\n")
+	send(command("ZADD", "board", "-inf", "zed", "20", "bob"), ":1\r\n")
+	send(command("ZRANGE", "board", "0", "-1", "WITHSCORES"),
+		"*8\r\n$3\r\nzed\r\n$4\r\n-inf\r\n$3\r\nann\r\n$3\r\n2.5\r\n$2\r\nal\r\n$2\r\n10\r\n$3\r\nbob\r\n$2\r\n20\r\n")
+	send(command("ZRANGEBYSCORE", "board", "(2.5", "+inf", "LIMIT", "1", "1"), "*1\r\n$3\r\nbob\r\n")
+	send(command("ZRANK", "board", "al"), ":2\r\n")
+	send(command("ZRANK", "board", "nobody"), "$-1\r\n")
+	send(command("ZREM", "board", "zed", "nobody"), ":1\r\n")
+	send(command("ZRANK", "board", "al"), ":1\r\n")
+	send(command("ZADD", "board", "nan", "x"), "-ERR invalid score \"nan\": not a valid float\r\n")
+	send(command("GET", "board"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+}
+
+func TestRESP_Set(t *testing.T) {
+	send := dial(t, startServer(t))
+
+	send(command("SADD", "beta", "u1", "u2", "u3", "u2"), ":3\r\n")
+	send(command("SADD", "staff", "u2", "u9"), ":2\r\n")
+	send(command("SREM", "staff", "u9", "nobody"), ":1\r\n")
+	send(command("SISMEMBER", "beta", "u3"), ":1\r\n")
+	send(command("SMEMBERS", "beta"), "*3\r\n$2\r\nu1\r\n$2\r\nu2\r\n$2\r\nu3\r\n")
+	send(command("SINTER", "beta", "staff"), "*1\r\n$2\r\nu2\r\n")
+	send(command("SUNION", "staff", "beta", "missing"), "*3\r\n$2\r\nu1\r\n$2\r\nu2\r\n$2\r\nu3\r\n")
+	send(command("SDIFF", "beta", "staff"), "*2\r\n$2\r\nu1\r\n$2\r\nu3\r\n")
+
+	// *STORE заменяет значение приёмника любого типа; пустой результат удаляет его
+	send(command("SET", "dst", "string"), "+OK\r\n")
+	send(command("SDIFFSTORE", "dst", "beta", "staff"), ":2\r\n")
+	send(command("SMEMBERS", "dst"), "*2\r\n$2\r\nu1\r\n$2\r\nu3\r\n")
+	send(command("SINTERSTORE", "dst", "dst", "missing"), ":0\r\n")
+	send(command("SMEMBERS", "dst"), "*0\r\n")
+	send(command("MULTI"), "+OK\r\n")
+	send(command("SUNIONSTORE", "all", "beta", "staff"), "+QUEUED\r\n")
+	send(command("SISMEMBER", "all", "u2"), "+QUEUED\r\n")
+	send(command("EXEC"), "*2\r\n:3\r\n:1\r\n")
+
+	send(command("SET", "str", "v"), "+OK\r\n")
+	send(command("SINTER", "beta", "str"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+}
+
+func TestRESP_Counters(t *testing.T) {
+	send := dial(t, startServer(t))
+
+	send(command("INCR", "hits"), ":1\r\n")
+	send(command("INCRBY", "hits", "41"), ":42\r\n")
+	send(command("DECR", "hits"), ":41\r\n")
+	send(command("SET", "max", "9223372036854775807"), "+OK\r\n")
+	send(command("INCR", "max"), "-ERR increment or decrement would overflow\r\n")
+	send(command("SET", "name", "ann"), "+OK\r\n")
+	send(command("INCR", "name"), "-ERR value is not an integer or out of range\r\n")
+	send(command("INCRBYFLOAT", "name", "1"), "-ERR value is not a valid float\r\n")
+	send(command("INCRBYFLOAT", "price", "10.5"), "$4\r\n10.5\r\n")
+	send(command("INCRBYFLOAT", "price", "0.1"), "$4\r\n10.6\r\n")
+	send(command("INCRBYFLOAT", "price", "-10.6"), "$1\r\n0\r\n")
+	send(command("INCR", "price"), ":1\r\n")
+	send(command("SET", "big", "1e308"), "+OK\r\n")
+	send(command("INCRBYFLOAT", "big", "1e308"), "-ERR increment would produce NaN or Infinity\r\n")
+	send(command("HSET", "h", "f", "v"), ":1\r\n")
+	send(command("INCR", "h"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+
+	// TTL ключа переживает приращение
+	send(command("SET", "tmp", "1", "EX", "100"), "+OK\r\n")
+	send(command("INCR", "tmp"), ":2\r\n")
+	send(command("TTL", "tmp"), ":100\r\n")
+}
+
+func TestRESP_Keyspace(t *testing.T) {
+	send := dial(t, startServer(t))
+
+	send(command("DBSIZE"), ":0\r\n")
+	send(command("SCAN", "0"), "*2\r\n$1\r\n0\r\n*0\r\n")
+	for _, key := range []string{"user:1", "user:2", "order:1"} {
+		send(command("SET", key, "v"), "+OK\r\n")
+	}
+	send(command("DBSIZE"), ":3\r\n")
+	send(command("KEYS", "user:*"), "*2\r\n$6\r\nuser:1\r\n$6\r\nuser:2\r\n")
+	send(command("KEYS", "*:[^2]"), "*2\r\n$7\r\norder:1\r\n$6\r\nuser:1\r\n")
+	send(command("SCAN", "0", "MATCH", "order:*", "COUNT", "100"), "*2\r\n$1\r\n0\r\n*1\r\n$7\r\norder:1\r\n")
+	send(command("SCAN", "0", "COUNT", "0"), "-ERR invalid COUNT \"0\": must be a positive integer\r\n")
+}
+
+func TestRESP_Range(t *testing.T) {
+	send := dial(t, startServerWith(t, engine.NewOrderedEngine(zap.NewNop())))
+
+	for _, key := range []string{"metrics:2026-10-16:b", "metrics:2026-10-16:a", "metrics:2026-10-17:a", "users:1"} {
+		send(command("SET", key, "v"), "+OK\r\n")
+	}
+	send(command("RANGE", "metrics:2026-10-16", "metrics:2026-10-17"),
+		"*2\r\n$20\r\nmetrics:2026-10-16:a\r\n$20\r\nmetrics:2026-10-16:b\r\n")
+	send(command("RANGE", "", "users:", "LIMIT", "1", "REV"), "*1\r\n$20\r\nmetrics:2026-10-17:a\r\n")
+	send(command("PREFIX", "users:"), "*1\r\n$7\r\nusers:1\r\n")
+	send(command("PREFIX", "nope"), "*0\r\n")
+
+	// Движок без упорядоченного индекса – понятная ошибка
+	other := dial(t, startServer(t))
+	other(command("PREFIX", "users:"), "-ERR range queries require an ordered engine (engine.type: ordered)\r\n")
+}
+
+func TestRESP_MultiKey(t *testing.T) {
+	send := dial(t, startServer(t))
+
+	send(command("MSET", "a", "1", "b", "two words", "a", "3"), "+OK\r\n")
+	send(command("HSET", "h", "f", "v"), ":1\r\n")
+	send(command("MGET", "a", "b", "missing", "h"), "*4\r\n$1\r\n3\r\n$9\r\ntwo words\r\n$-1\r\n$-1\r\n")
+	send(command("MDEL", "a", "h", "missing"), ":2\r\n")
+	send(command("MGET", "a", "b"), "*2\r\n$-1\r\n$9\r\ntwo words\r\n")
+	send(command("MSET", "a"), "-ERR MSET command requires key value pairs\r\n")
+}
+
+// testHashIterations – дешёвый PBKDF2 для тестов: с 600000 итераций вход под -race
+// занимает секунды и не укладывается в таймауты соединений
+const testHashIterations = 1000
+
+func TestRESP_Auth(t *testing.T) {
+	logger := zap.NewNop()
+	cfg := config.Config{}
+	cfg.Network.RESPAddress = "127.0.0.1:0"
+	cfg.Network.MaxConnections = 5
+	cfg.Network.MaxMessageSize = "4KB"
+	cfg.Network.IdleTimeout = 2 * time.Second
+
+	defaultHash, _ := acl.HashPasswordIterations("secret", testHashIterations)
+	aliceHash, _ := acl.HashPasswordIterations("pw", testHashIterations)
+	access, err := acl.New(config.AuthConfig{MinPasswordIterations: testHashIterations, Users: []config.UserConfig{
+		{Name: "default", PasswordHash: defaultHash, Categories: []string{"read", "write"}, Keys: []string{"*"}},
+		{Name: "alice", PasswordHash: aliceHash, Categories: []string{"read"}, Keys: []string{"*"}},
+	}}, logger)
+	if err != nil {
+		t.Fatalf("acl.New: %v", err)
+	}
+	cmp := compute.NewCompute(parser.NewParser(), engine.NewInMemoryEngine(logger), &wal.NoOpWAL{}, logger)
+	srv := resp.NewServer(cfg, cmp, logger)
+	srv.SetACL(access)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("failed to start RESP server: %v", err)
+	}
+	t.Cleanup(srv.Stop)
+	addr, _ := srv.Addr()
+
+	// redis-cli -a: AUTH password для пользователя default
+	send := dial(t, addr)
+	send(command("PING"), "+PONG\r\n")
+	send(command("SET", "k", "v"), "-NOAUTH Authentication required.\r\n")
+	send(command("AUTH", "wrong"), "-WRONGPASS invalid username-password pair\r\n")
+	send(command("AUTH", "secret"), "+OK\r\n")
+	send(command("SET", "k", "v"), "+OK\r\n")
+
+	// Клиенты RESP3 входят через HELLO 3 AUTH
+	send = dial(t, addr)
+	send(command("HELLO", "3", "AUTH", "alice", "bad"), "-WRONGPASS invalid username-password pair\r\n")
+	send(command("GET", "k"), "-NOAUTH Authentication required.\r\n")
+	send(command("HELLO", "3", "AUTH", "alice", "pw"),
+		"%4\r\n$6\r\nserver\r\n$6\r\nimkvdb\r\n$5\r\nproto\r\n:3\r\n"+
+			"$4\r\nmode\r\n$10\r\nstandalone\r\n$4\r\nrole\r\n$6\r\nmaster\r\n")
+	send(command("GET", "k"), "$1\r\nv\r\n")
+	send(command("DEL", "k"), "-NOPERM User alice has no permissions to run the 'DEL' command\r\n")
+}
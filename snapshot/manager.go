@@ -0,0 +1,123 @@
+package snapshot
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"imkvdb/config"
+	"imkvdb/storage"
+)
+
+// Source – источник согласованного снимка: данные и LSN последней учтённой в них записи WAL
+type Source interface {
+	Snapshot() (uint64, []storage.Entry, error)
+}
+
+// Truncater – WAL, из которого можно убрать сегменты, покрытые снимком
+type Truncater interface {
+	RemoveSegmentsUpTo(lsn uint64) (int, error)
+}
+
+// Manager периодически снимает контрольные точки и подрезает WAL
+type Manager struct {
+	cfg    config.SnapshotConfig
+	src    Source
+	wal    Truncater // nil, если WAL выключен
+	logger *zap.Logger
+
+	mu      sync.Mutex // одна контрольная точка за раз
+	lastLSN uint64
+
+	quitCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewManager – конструктор Manager. wal может быть nil.
+func NewManager(cfg config.SnapshotConfig, src Source, wal Truncater, logger *zap.Logger) *Manager {
+	return &Manager{
+		cfg:    cfg,
+		src:    src,
+		wal:    wal,
+		logger: logger,
+		quitCh: make(chan struct{}),
+	}
+}
+
+// Start запускает периодическое снятие снимков раз в cfg.Interval
+func (m *Manager) Start() {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		ticker := time.NewTicker(m.cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-m.quitCh:
+				return
+			case <-ticker.C:
+				if err := m.Checkpoint(); err != nil {
+					m.logger.Error("checkpoint failed", zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// Stop останавливает периодическое снятие снимков
+func (m *Manager) Stop() {
+	close(m.quitCh)
+	m.wg.Wait()
+}
+
+// Checkpoint снимает снимок, записывает его на диск и убирает покрытые им сегменты WAL
+func (m *Manager) Checkpoint() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Данные копируются под короткой блокировкой записи, дальше работаем с копией
+	lsn, entries, err := m.src.Snapshot()
+	if err != nil {
+		return fmt.Errorf("take snapshot: %w", err)
+	}
+	if lsn != 0 && lsn == m.lastLSN {
+		return nil // с прошлого снимка ничего не изменилось
+	}
+
+	start := time.Now()
+	path, err := Write(m.cfg.DataDirectory, lsn, entries)
+	if err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	m.lastLSN = lsn
+	m.logger.Info("Snapshot written",
+		zap.String("file", path),
+		zap.Uint64("lsn", lsn),
+		zap.Int("entries", len(entries)),
+		zap.Duration("took", time.Since(start)),
+	)
+
+	if err := prune(m.cfg.DataDirectory); err != nil {
+		m.logger.Warn("failed to prune old snapshots", zap.Error(err))
+	}
+
+	if m.wal == nil {
+		return nil
+	}
+	// Подрезаем WAL только до самого старого из хранимых снимков: если последний снимок
+	// окажется повреждённым, Load откатится на предыдущий и нужные сегменты будут на месте
+	oldest, err := oldestLSN(m.cfg.DataDirectory)
+	if err != nil {
+		return fmt.Errorf("list snapshots: %w", err)
+	}
+	if oldest == 0 {
+		return nil
+	}
+	if _, err := m.wal.RemoveSegmentsUpTo(oldest); err != nil {
+		return fmt.Errorf("truncate WAL: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+package snapshot
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+	"imkvdb/storage"
+)
+
+const (
+	// Формат файла: magic, версия, LSN, число записей, записи, CRC32C всего предыдущего
+	fileMagic = "IMKVSNAP"
+	// Версия 2 добавила версию ключа в каждую запись, версия 3 – тип значения и элементы коллекции.
+	// Старые версии читаются: с нулевыми версиями и строковыми значениями.
+	fileVersion = uint32(3)
+
+	filePattern = "snapshot_*.snap"
+	// retainSnapshots – сколько последних снимков хранить (предыдущий – на случай порчи нового)
+	retainSnapshots = 2
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// fileName – имя файла снимка; LSN дополнен нулями, чтобы сортировка по имени совпадала с сортировкой по LSN
+func fileName(lsn uint64) string {
+	return fmt.Sprintf("snapshot_%020d.snap", lsn)
+}
+
+// Write атомарно записывает снимок в каталог dir: сначала во временный файл, затем fsync и rename.
+// Возвращает путь к файлу снимка.
+func Write(dir string, lsn uint64, entries []storage.Entry) (path string, err error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path = filepath.Join(dir, fileName(lsn))
+	tmpPath := path + ".tmp"
+
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(tmpPath)
+		}
+	}()
+
+	hash := crc32.New(crcTable)
+	w := bufio.NewWriter(io.MultiWriter(f, hash))
+
+	var buf [binary.MaxVarintLen64]byte
+	writeUvarint := func(v uint64) {
+		n := binary.PutUvarint(buf[:], v)
+		_, _ = w.Write(buf[:n])
+	}
+	writeString := func(s string) {
+		writeUvarint(uint64(len(s)))
+		_, _ = w.WriteString(s)
+	}
+
+	_, _ = w.WriteString(fileMagic)
+	_ = binary.Write(w, binary.LittleEndian, fileVersion)
+	_ = binary.Write(w, binary.LittleEndian, lsn)
+	writeUvarint(uint64(len(entries)))
+	for _, e := range entries {
+		writeString(e.Key)
+		writeString(e.Value)
+		var expireAt int64
+		if !e.ExpireAt.IsZero() {
+			expireAt = e.ExpireAt.UnixMilli()
+		}
+		n := binary.PutVarint(buf[:], expireAt)
+		_, _ = w.Write(buf[:n])
+		writeUvarint(e.Version)
+		_ = w.WriteByte(byte(e.Type))
+		writeUvarint(uint64(len(e.Items)))
+		for _, item := range e.Items {
+			writeString(item)
+		}
+	}
+	// Ошибки записи bufio.Writer "залипают" и всплывут во Flush
+	if err = w.Flush(); err != nil {
+		return "", err
+	}
+	if err = binary.Write(f, binary.LittleEndian, hash.Sum32()); err != nil {
+		return "", err
+	}
+	if err = f.Sync(); err != nil {
+		return "", err
+	}
+	if err = f.Close(); err != nil {
+		return "", err
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return "", err
+	}
+	return path, syncDir(dir)
+}
+
+// Load находит самый свежий целый снимок в каталоге и возвращает его LSN и записи.
+// Если снимков нет, возвращает LSN 0 и пустой список. Повреждённые снимки пропускаются.
+func Load(dir string, logger *zap.Logger) (uint64, []storage.Entry, error) {
+	files, err := listSnapshots(dir)
+	if err != nil {
+		return 0, nil, err
+	}
+	// От нового к старому
+	for i := len(files) - 1; i >= 0; i-- {
+		lsn, entries, err := readFile(files[i])
+		if err != nil {
+			logger.Warn("skipping broken snapshot", zap.String("file", files[i]), zap.Error(err))
+			continue
+		}
+		logger.Info("Loaded snapshot",
+			zap.String("file", files[i]),
+			zap.Uint64("lsn", lsn),
+			zap.Int("entries", len(entries)),
+		)
+		return lsn, entries, nil
+	}
+	return 0, nil, nil
+}
+
+// readFile читает и проверяет один файл снимка
+func readFile(path string) (uint64, []storage.Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(data) < len(fileMagic)+4+8+4 {
+		return 0, nil, errors.New("snapshot file is too short")
+	}
+	body, trailer := data[:len(data)-4], data[len(data)-4:]
+	if crc32.Checksum(body, crcTable) != binary.LittleEndian.Uint32(trailer) {
+		return 0, nil, errors.New("snapshot checksum mismatch")
+	}
+	if string(body[:len(fileMagic)]) != fileMagic {
+		return 0, nil, errors.New("not a snapshot file")
+	}
+	body = body[len(fileMagic):]
+	version := binary.LittleEndian.Uint32(body)
+	if version < 1 || version > fileVersion {
+		return 0, nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	lsn := binary.LittleEndian.Uint64(body[4:])
+	body = body[12:]
+
+	readUvarint := func() (uint64, error) {
+		v, n := binary.Uvarint(body)
+		if n <= 0 {
+			return 0, errors.New("malformed snapshot entry")
+		}
+		body = body[n:]
+		return v, nil
+	}
+	readString := func() (string, error) {
+		l, err := readUvarint()
+		if err != nil {
+			return "", err
+		}
+		if uint64(len(body)) < l {
+			return "", errors.New("malformed snapshot entry")
+		}
+		s := string(body[:l])
+		body = body[l:]
+		return s, nil
+	}
+
+	count, err := readUvarint()
+	if err != nil {
+		return 0, nil, err
+	}
+	entries := make([]storage.Entry, 0, count)
+	for i := uint64(0); i < count; i++ {
+		key, err := readString()
+		if err != nil {
+			return 0, nil, err
+		}
+		value, err := readString()
+		if err != nil {
+			return 0, nil, err
+		}
+		expireAt, n := binary.Varint(body)
+		if n <= 0 {
+			return 0, nil, errors.New("malformed snapshot entry")
+		}
+		body = body[n:]
+
+		entry := storage.Entry{Key: key, Value: value}
+		if expireAt != 0 {
+			entry.ExpireAt = time.UnixMilli(expireAt)
+		}
+		if version >= 2 {
+			if entry.Version, err = readUvarint(); err != nil {
+				return 0, nil, err
+			}
+		}
+		if version >= 3 {
+			if len(body) == 0 {
+				return 0, nil, errors.New("malformed snapshot entry")
+			}
+			entry.Type = storage.Type(body[0])
+			body = body[1:]
+			n, err := readUvarint()
+			if err != nil {
+				return 0, nil, err
+			}
+			if n > uint64(len(body)) {
+				return 0, nil, errors.New("malformed snapshot entry")
+			}
+			if n > 0 {
+				entry.Items = make([]string, n)
+			}
+			for j := range entry.Items {
+				if entry.Items[j], err = readString(); err != nil {
+					return 0, nil, err
+				}
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return lsn, entries, nil
+}
+
+// prune удаляет старые снимки, оставляя retainSnapshots последних
+func prune(dir string) error {
+	files, err := listSnapshots(dir)
+	if err != nil {
+		return err
+	}
+	for len(files) > retainSnapshots {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// oldestLSN возвращает LSN самого старого из хранимых снимков.
+// Пока снимков меньше retainSnapshots, возвращает 0: запасного снимка ещё нет.
+func oldestLSN(dir string) (uint64, error) {
+	files, err := listSnapshots(dir)
+	if err != nil || len(files) < retainSnapshots {
+		return 0, err
+	}
+	var lsn uint64
+	if _, err := fmt.Sscanf(filepath.Base(files[0]), "snapshot_%d.snap", &lsn); err != nil {
+		return 0, fmt.Errorf("unexpected snapshot file name %s: %w", files[0], err)
+	}
+	return lsn, nil
+}
+
+// listSnapshots возвращает файлы снимков, отсортированные по возрастанию LSN
+func listSnapshots(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, filePattern))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// syncDir делает fsync каталога, чтобы rename пережил падение
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
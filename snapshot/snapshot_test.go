@@ -0,0 +1,271 @@
+package snapshot_test
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"imkvdb/compute"
+	"imkvdb/compute/parser"
+	"imkvdb/config"
+	"imkvdb/snapshot"
+	"imkvdb/storage"
+	"imkvdb/storage/engine"
+	"imkvdb/wal"
+
+	"go.uber.org/zap"
+)
+
+func TestWriteLoad_RoundTrip(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	dir := t.TempDir()
+
+	expireAt := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+	entries := []storage.Entry{
+		{Key: "k1", Value: "v1"},
+		{Key: "k2", Value: "value with spaces\nand newline", ExpireAt: expireAt},
+		{Key: "h", Type: storage.TypeHash, Items: []string{"f1", "v1", "field 2", "v\x002"}, Version: 7},
+	}
+	if _, err := snapshot.Write(dir, 42, entries); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+
+	lsn, got, err := snapshot.Load(dir, logger)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if lsn != 42 {
+		t.Errorf("got lsn=%d, want 42", lsn)
+	}
+	if len(got) != 3 || !reflect.DeepEqual(got[0], entries[0]) || got[1].Key != "k2" ||
+		got[1].Value != entries[1].Value || !got[1].ExpireAt.Equal(expireAt) ||
+		!reflect.DeepEqual(got[2], entries[2]) {
+		t.Errorf("got entries %+v, want %+v", got, entries)
+	}
+}
+
+func TestLoad_SkipsBrokenSnapshot(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	dir := t.TempDir()
+
+	if _, err := snapshot.Write(dir, 1, []storage.Entry{{Key: "old", Value: "v"}}); err != nil {
+		t.Fatal(err)
+	}
+	path, err := snapshot.Write(dir, 2, []storage.Entry{{Key: "new", Value: "v"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Портим последний снимок
+	data, _ := os.ReadFile(path)
+	data[len(data)-6] ^= 0xFF
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lsn, got, err := snapshot.Load(dir, logger)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if lsn != 1 || len(got) != 1 || got[0].Key != "old" {
+		t.Errorf("expected fallback to snapshot 1, got lsn=%d entries=%+v", lsn, got)
+	}
+}
+
+// Снимок, снятый во время записей, вместе с записями WAL после его LSN даёт то же состояние:
+// ни одна запись не теряется и не применяется дважды (LPUSH и RPOP не идемпотентны)
+func TestSnapshot_ConcurrentWritesReplay(t *testing.T) {
+	logger := zap.NewNop()
+	dir := t.TempDir()
+	w, err := wal.NewFileWAL(config.WALConfig{
+		Enabled:              true,
+		FlushingBatchSize:    50,
+		FlushingBatchTimeout: time.Millisecond,
+		MaxSegmentSize:       "10MB",
+		DataDirectory:        dir,
+	}, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eng := engine.NewInMemoryEngine(logger)
+	cmp := compute.NewCompute(parser.NewParser(), eng, w, logger)
+
+	type snap struct {
+		lsn     uint64
+		entries []storage.Entry
+	}
+	var (
+		writers sync.WaitGroup
+		snaps   []snap
+		stop    = make(chan struct{})
+		taken   = make(chan struct{})
+	)
+	for i := 0; i < 4; i++ {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			key := fmt.Sprintf("list%d", i%2)
+			for j := 0; j < 300; j++ {
+				cmp.Process(fmt.Sprintf("LPUSH %s v%d", key, j))
+				if j%3 == 0 {
+					cmp.Process("RPOP " + key)
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(taken)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			lsn, entries, err := cmp.Snapshot()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			snaps = append(snaps, snap{lsn, entries})
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	writers.Wait()
+	close(stop)
+	<-taken
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := sortedEntries(eng.Dump())
+	for _, sn := range snaps {
+		restored := engine.NewInMemoryEngine(logger)
+		restored.Restore(sn.entries)
+		if _, err := wal.ReplayWAL(dir, sn.lsn, restored, logger); err != nil {
+			t.Fatalf("ReplayWAL after LSN %d: %v", sn.lsn, err)
+		}
+		if got := sortedEntries(restored.Dump()); !reflect.DeepEqual(got, want) {
+			t.Fatalf("snapshot at LSN %d + WAL differs from live data:\ngot  %+v\nwant %+v", sn.lsn, got, want)
+		}
+	}
+}
+
+func sortedEntries(entries []storage.Entry) []storage.Entry {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries
+}
+
+func TestManager_CheckpointAndRecover(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	walDir := t.TempDir()
+	snapCfg := config.SnapshotConfig{
+		Enabled:       true,
+		Interval:      time.Hour, // контрольные точки вызываем вручную
+		DataDirectory: t.TempDir(),
+	}
+	walCfg := config.WALConfig{
+		Enabled:              true,
+		FlushingBatchSize:    1,
+		FlushingBatchTimeout: 10 * time.Millisecond,
+		MaxSegmentSize:       "16", // по сегменту на запись, чтобы было что подрезать
+		DataDirectory:        walDir,
+	}
+
+	w, err := wal.NewFileWAL(walCfg, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eng := engine.NewInMemoryEngine(logger)
+	cmp := compute.NewCompute(parser.NewParser(), eng, w, logger)
+	mgr := snapshot.NewManager(snapCfg, cmp, w, logger)
+
+	mustProcess := func(input string) {
+		if err := cmp.Process(input).Err(); err != nil {
+			t.Fatalf("Process(%q) error: %v", input, err)
+		}
+	}
+
+	for _, input := range []string{"SET a 1", "SET b 2", "SET c 3", "DEL b"} {
+		mustProcess(input)
+	}
+	if err := mgr.Checkpoint(); err != nil {
+		t.Fatalf("first checkpoint error: %v", err)
+	}
+	mustProcess("SET d 4")
+	if err := mgr.Checkpoint(); err != nil {
+		t.Fatalf("second checkpoint error: %v", err)
+	}
+	// Изменения после последнего снимка должны восстановиться из WAL
+	mustProcess("SET a 10")
+	mustProcess("DEL c")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// "Перезапуск": снимок + хвост WAL
+	lsn, entries, err := snapshot.Load(snapCfg.DataDirectory, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lsn != 5 {
+		t.Errorf("got snapshot lsn=%d, want 5", lsn)
+	}
+	restored := engine.NewInMemoryEngine(logger)
+	restored.Restore(entries)
+	last, err := wal.ReplayWAL(walDir, lsn, restored, logger)
+	if err != nil {
+		t.Fatalf("ReplayWAL error: %v", err)
+	}
+	if last != 7 {
+		t.Errorf("got last lsn=%d, want 7", last)
+	}
+
+	want := map[string]string{"a": "10", "d": "4"}
+	for key, val := range want {
+		if got, ok, _ := restored.Get(key); !ok || got != val {
+			t.Errorf("key %q: got %q, found=%v, want %q", key, got, ok, val)
+		}
+	}
+	for _, key := range []string{"b", "c"} {
+		if _, ok, _ := restored.Get(key); ok {
+			t.Errorf("expected key %q to be deleted", key)
+		}
+	}
+
+	// Сегменты, целиком покрытые более старым из двух снимков (LSN <= 4), удалены:
+	// полный реплей WAL с нуля не должен встретить ни одной из этих записей
+	rec := &recordingReplayer{Replayer: engine.NewInMemoryEngine(logger)}
+	if _, err := wal.ReplayWAL(walDir, 0, rec, logger); err != nil {
+		t.Fatalf("ReplayWAL error: %v", err)
+	}
+	for _, key := range rec.setKeys {
+		if key == "b" || key == "c" {
+			t.Errorf("record for key %q is covered by snapshot and should have been removed", key)
+		}
+	}
+}
+
+// recordingReplayer запоминает ключи, для которых при реплее вызывался Set
+type recordingReplayer struct {
+	wal.Replayer
+	setKeys []string
+}
+
+func (r *recordingReplayer) Atomic(fn func(tx storage.Tx) error) error {
+	return r.Replayer.Atomic(func(tx storage.Tx) error {
+		return fn(&recordingTx{Tx: tx, r: r})
+	})
+}
+
+type recordingTx struct {
+	storage.Tx
+	r *recordingReplayer
+}
+
+func (t *recordingTx) Set(key, value string) error {
+	t.r.setKeys = append(t.r.setKeys, key)
+	return t.Tx.Set(key, value)
+}
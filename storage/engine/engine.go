@@ -2,81 +2,498 @@ package engine
 
 import (
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+	"imkvdb/storage"
 )
 
+// DefaultSweepInterval – период фоновой очистки просроченных ключей по умолчанию
+const DefaultSweepInterval = 100 * time.Millisecond
+
+// sweepLimit – сколько ключей с TTL максимум проверяется за один проход очистки,
+// чтобы не держать блокировку слишком долго
+const sweepLimit = 1000
+
 // Engine – это интерфейс, определяющий методы для работы с хранилищем.
 // В данном случае повторяет методы storage.Storage, но может быть расширен,
 // если у нас появятся специфичные для engine методы (например, сброс на диск, статистика и т.д.).
 type Engine interface {
-	Set(key, value string) error
-	Get(key string) (string, bool)
-	Del(key string) bool
+	storage.Storage
+
+	// Restore загружает записи снимка
+	Restore(entries []storage.Entry)
+	// StartSweeper запускает фоновую очистку ключей с истёкшим TTL
+	StartSweeper(interval time.Duration)
+	// MemoryUsage – оценка памяти, занятой данными, в байтах
+	MemoryUsage() int64
+	// Evict выбирает ключ по политике вытеснения (config.Evict*) и удаляет его;
+	// false – подходящих ключей нет
+	Evict(policy string) (key string, ok bool)
+	// Close останавливает фоновые горутины движка
+	Close() error
 }
 
 // InMemoryEngine – простая in-memory реализация Engine
 type InMemoryEngine struct {
-	mu   sync.RWMutex
-	data map[string]string
+	mu      sync.RWMutex
+	data    map[string]value
+	expires map[string]time.Time // время истечения для ключей с TTL
+	// versions – версия каждого ключа. Берётся из общего монотонного счётчика lastVersion,
+	// поэтому удалённый и заново созданный ключ никогда не получит прежнюю версию
+	versions    map[string]uint64
+	lastVersion uint64
+	// index – ключи по возрастанию для Range; nil – движок без упорядоченного индекса
+	index *skiplist
+	// scanIndex – ключи по возрастанию (scanHash, ключ) для SCAN (см. scan.go)
+	scanIndex skiplist
+	// used – оценка памяти данных (см. memory.go). Меняется под блокировкой на запись,
+	// читается без неё
+	used atomic.Int64
 
 	logger *zap.Logger
+	now    func() time.Time // источник времени (подменяется в тестах)
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// InMemoryOptions – опции движка "in_memory" из engine.options
+type InMemoryOptions struct {
+	// SweepInterval – период фоновой очистки просроченных ключей; 0 – не запускать
+	SweepInterval time.Duration `yaml:"sweep_interval"`
+}
+
+func init() {
+	Register("in_memory", func(options *yaml.Node, logger *zap.Logger) (Engine, error) {
+		opts := InMemoryOptions{SweepInterval: DefaultSweepInterval}
+		if err := DecodeOptions(options, &opts); err != nil {
+			return nil, err
+		}
+		e := NewInMemoryEngine(logger)
+		if opts.SweepInterval > 0 {
+			e.StartSweeper(opts.SweepInterval)
+		}
+		return e, nil
+	})
 }
 
 // NewInMemoryEngine – конструктор для InMemoryEngine
 func NewInMemoryEngine(logger *zap.Logger) *InMemoryEngine {
 	return &InMemoryEngine{
-		data:   make(map[string]string),
-		logger: logger,
+		data:      make(map[string]value),
+		expires:   make(map[string]time.Time),
+		versions:  make(map[string]uint64),
+		scanIndex: newSkiplist(),
+		logger:    logger,
+		now:       time.Now,
 	}
 }
 
+// Set сохраняет значение и сбрасывает TTL ключа (как SET в Redis)
 func (e *InMemoryEngine) Set(key, value string) error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
+	return e.setLocked(key, value)
+}
+
+func (e *InMemoryEngine) Get(key string) (string, bool, error) {
+	e.mu.RLock()
+	val, ok := e.data[key]
+	expired := ok && e.isExpired(key)
+	if ok && !expired {
+		val.usage.touch(e.now())
+	}
+	e.mu.RUnlock()
+
+	// Ленивое удаление: ключ истёк, но фоновая очистка до него ещё не добралась
+	if expired {
+		e.removeIfExpired(key)
+		val, ok = value{}, false
+	}
+	return e.getString(key, val, ok)
+}
 
-	e.data[key] = value
-	e.logger.Info("Set value",
+func (e *InMemoryEngine) Del(key string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.delLocked(key)
+}
+
+// Expire задаёт абсолютное время истечения ключа.
+// Если время уже прошло, ключ удаляется сразу. Возвращает false, если ключа нет.
+func (e *InMemoryEngine) Expire(key string, at time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.expireLocked(key, at)
+}
+
+// Persist снимает TTL с ключа. Возвращает false, если ключа нет или TTL не был задан.
+func (e *InMemoryEngine) Persist(key string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.persistLocked(key)
+}
+
+// ExpireTime возвращает время истечения ключа.
+// Нулевое время означает, что TTL не задан; false – ключа нет.
+func (e *InMemoryEngine) ExpireTime(key string) (time.Time, bool) {
+	e.mu.RLock()
+	_, ok := e.data[key]
+	at, hasTTL := e.expires[key]
+	expired := hasTTL && !at.After(e.now())
+	e.mu.RUnlock()
+
+	if !ok {
+		return time.Time{}, false
+	}
+	if expired {
+		e.removeIfExpired(key)
+		return time.Time{}, false
+	}
+	return at, true
+}
+
+// Version возвращает версию ключа (0 – ключа нет)
+func (e *InMemoryEngine) Version(key string) uint64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.isExpired(key) {
+		return 0
+	}
+	return e.versions[key]
+}
+
+// SetVersion выставляет версию существующему ключу (нужно при реплее WAL)
+func (e *InMemoryEngine) SetVersion(key string, version uint64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.setVersionLocked(key, version)
+}
+
+// Atomic выполняет fn под блокировкой движка на запись: все операции tx
+// видны другим клиентам только целиком
+func (e *InMemoryEngine) Atomic(fn func(tx storage.Tx) error) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return fn(lockedView{e})
+}
+
+// Dump возвращает копию всех живых ключей. Копирование идёт под блокировкой на чтение,
+// запись в файл снимка – уже без неё.
+func (e *InMemoryEngine) Dump() []storage.Entry {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	now := e.now()
+	entries := make([]storage.Entry, 0, len(e.data))
+	for key, val := range e.data {
+		at, hasTTL := e.expires[key]
+		if hasTTL && !at.After(now) {
+			continue
+		}
+		entry := val.entry()
+		entry.Key, entry.ExpireAt, entry.Version = key, at, e.versions[key]
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Restore загружает записи снимка поверх текущих данных. Истёкшие записи пропускаются.
+func (e *InMemoryEngine) Restore(entries []storage.Entry) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := e.now()
+	for _, entry := range entries {
+		if !entry.ExpireAt.IsZero() && !entry.ExpireAt.After(now) {
+			continue
+		}
+		e.putKey(entry.Key, valueFromEntry(entry))
+		if entry.ExpireAt.IsZero() {
+			delete(e.expires, entry.Key)
+		} else {
+			e.expires[entry.Key] = entry.ExpireAt
+		}
+		e.setVersionLocked(entry.Key, entry.Version)
+	}
+	e.logger.Info("Restored entries from snapshot", zap.Int("count", len(entries)))
+}
+
+// StartSweeper запускает фоновую горутину, которая периодически удаляет просроченные ключи.
+// Останавливается через Close.
+func (e *InMemoryEngine) StartSweeper(interval time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stopCh != nil {
+		return // уже запущена
+	}
+	e.stopCh = make(chan struct{})
+
+	e.wg.Add(1)
+	go func(stopCh chan struct{}) {
+		defer e.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+				e.sweepExpired()
+			}
+		}
+	}(e.stopCh)
+}
+
+// Close останавливает фоновую очистку (если она была запущена)
+func (e *InMemoryEngine) Close() error {
+	e.mu.Lock()
+	stopCh := e.stopCh
+	e.stopCh = nil
+	e.mu.Unlock()
+
+	if stopCh != nil {
+		close(stopCh)
+		e.wg.Wait()
+	}
+	return nil
+}
+
+// sweepExpired – один проход фоновой очистки. Возвращает количество удалённых ключей.
+func (e *InMemoryEngine) sweepExpired() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := e.now()
+	checked, removed := 0, 0
+	// Порядок обхода map случайный, поэтому при ограничении sweepLimit
+	// от прохода к проходу проверяются разные ключи
+	for key, at := range e.expires {
+		if checked >= sweepLimit {
+			break
+		}
+		checked++
+		if !at.After(now) {
+			e.deleteKey(key)
+			removed++
+		}
+	}
+	if removed > 0 {
+		e.logger.Debug("Expired keys swept", zap.Int("count", removed))
+	}
+	return removed
+}
+
+// lockedView – операции над данными без взятия блокировки.
+// Используется внутри Atomic, где e.mu уже захвачен на запись.
+type lockedView struct {
+	e *InMemoryEngine
+}
+
+func (v lockedView) Set(key, value string) error { return v.e.setLocked(key, value) }
+
+func (v lockedView) Get(key string) (string, bool, error) {
+	ok := v.e.existsLocked(key)
+	return v.e.getString(key, v.e.data[key], ok)
+}
+
+func (v lockedView) Del(key string) bool { return v.e.delLocked(key) }
+
+func (v lockedView) Expire(key string, at time.Time) bool { return v.e.expireLocked(key, at) }
+
+func (v lockedView) Persist(key string) bool { return v.e.persistLocked(key) }
+
+func (v lockedView) ExpireTime(key string) (time.Time, bool) {
+	if !v.e.existsLocked(key) {
+		return time.Time{}, false
+	}
+	return v.e.expires[key], true
+}
+
+func (v lockedView) Version(key string) uint64 {
+	if !v.e.existsLocked(key) {
+		return 0
+	}
+	return v.e.versions[key]
+}
+
+func (v lockedView) SetVersion(key string, version uint64) { v.e.setVersionLocked(key, version) }
+
+// Ниже – реализация операций. Все *Locked-методы вызываются под e.mu на запись.
+// Логи отдельных операций – на уровне Debug: при выключенном уровне zap отсекает их
+// до форматирования полей, и под блокировкой не тратится время на запись логов.
+
+func (e *InMemoryEngine) setLocked(key, value string) error {
+	e.putKey(key, stringValue(value))
+	delete(e.expires, key)
+	e.bumpVersion(key)
+	e.logger.Debug("Set value",
 		zap.String("key", key),
 		zap.String("value", value),
 	)
 	return nil
 }
 
-func (e *InMemoryEngine) Get(key string) (string, bool) {
-	e.mu.RLock()
-	defer e.mu.RUnlock()
-
-	val, ok := e.data[key]
+func (e *InMemoryEngine) delLocked(key string) bool {
+	ok := e.existsLocked(key)
 	if ok {
-		e.logger.Info("Get value",
+		e.deleteKey(key)
+		e.logger.Debug("Del value",
 			zap.String("key", key),
-			zap.String("value", val),
 		)
 	} else {
-		e.logger.Info("Get value - not found",
+		e.logger.Debug("Del value - not found",
 			zap.String("key", key),
 		)
 	}
+	return ok
+}
 
-	return val, ok
+func (e *InMemoryEngine) expireLocked(key string, at time.Time) bool {
+	if !e.existsLocked(key) {
+		return false
+	}
+	if !at.After(e.now()) {
+		e.deleteKey(key)
+		e.logger.Debug("Expire value - deleted immediately",
+			zap.String("key", key),
+		)
+		return true
+	}
+	e.expires[key] = at
+	e.bumpVersion(key)
+	e.logger.Debug("Expire value",
+		zap.String("key", key),
+		zap.Time("at", at),
+	)
+	return true
 }
 
-func (e *InMemoryEngine) Del(key string) bool {
-	e.mu.Lock()
-	defer e.mu.Unlock()
+func (e *InMemoryEngine) persistLocked(key string) bool {
+	if !e.existsLocked(key) {
+		return false
+	}
+	if _, ok := e.expires[key]; !ok {
+		return false
+	}
+	delete(e.expires, key)
+	e.bumpVersion(key)
+	e.logger.Debug("Persist value",
+		zap.String("key", key),
+	)
+	return true
+}
 
-	_, ok := e.data[key]
-	if ok {
-		delete(e.data, key)
-		e.logger.Info("Del value",
+// bumpVersion присваивает ключу новую версию. Вызывается под блокировкой на запись.
+func (e *InMemoryEngine) bumpVersion(key string) {
+	e.lastVersion++
+	e.versions[key] = e.lastVersion
+}
+
+// setVersionLocked выставляет версию существующему ключу и подтягивает общий счётчик
+func (e *InMemoryEngine) setVersionLocked(key string, version uint64) {
+	if _, ok := e.data[key]; !ok || version == 0 {
+		return
+	}
+	e.versions[key] = version
+	if version > e.lastVersion {
+		e.lastVersion = version
+	}
+}
+
+// getString – результат Get для найденного (ok) значения val
+func (e *InMemoryEngine) getString(key string, val value, ok bool) (string, bool, error) {
+	if !ok {
+		e.logger.Debug("Get value - not found",
 			zap.String("key", key),
 		)
+		return "", false, nil
+	}
+	if val.typ != storage.TypeString {
+		return "", false, storage.ErrWrongType
+	}
+	e.logger.Debug("Get value",
+		zap.String("key", key),
+		zap.String("value", val.str),
+	)
+	return val.str, true, nil
+}
+
+// removeIfExpired удаляет ключ под блокировкой на запись, если он всё ещё просрочен
+func (e *InMemoryEngine) removeIfExpired(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.isExpired(key) {
+		e.deleteKey(key)
+	}
+}
+
+// existsLocked – ключ существует и не просрочен (просроченный заодно удаляется).
+// Как и lookup, отмечает обращение к ключу. Вызывается под блокировкой на запись.
+func (e *InMemoryEngine) existsLocked(key string) bool {
+	val, ok := e.data[key]
+	if !ok {
+		return false
+	}
+	if e.isExpired(key) {
+		e.deleteKey(key)
+		return false
+	}
+	val.usage.touch(e.now())
+	return true
+}
+
+// lookup – живое значение ключа без ленивого удаления (годится и под блокировкой на чтение).
+// Отмечает обращение к ключу для вытеснения LRU/LFU.
+func (e *InMemoryEngine) lookup(key string) (value, bool) {
+	val, ok := e.data[key]
+	if !ok || e.isExpired(key) {
+		return value{}, false
+	}
+	val.usage.touch(e.now())
+	return val, true
+}
+
+// isExpired – истёк ли TTL ключа. Вызывается под блокировкой.
+func (e *InMemoryEngine) isExpired(key string) bool {
+	at, ok := e.expires[key]
+	return ok && !at.After(e.now())
+}
+
+// putKey записывает значение ключа (новый ключ попадает и в упорядоченный индекс).
+// Сведения об обращениях при замене значения сохраняются. Вызывается под блокировкой на запись.
+func (e *InMemoryEngine) putKey(key string, val value) {
+	now := e.now()
+	if old, ok := e.data[key]; ok {
+		e.used.Add(-entrySize(key, old))
+		val.usage = old.usage
+		val.usage.touch(now)
 	} else {
-		e.logger.Info("Del value - not found",
-			zap.String("key", key),
-		)
+		if e.index != nil {
+			e.index.insert(0, key)
+		}
+		e.scanIndex.insert(float64(scanHash(key)), key)
+		val.usage = newUsage(now)
 	}
+	e.data[key] = val
+	e.used.Add(entrySize(key, val))
+}
 
-	return ok
+// deleteKey удаляет ключ вместе с его TTL и версией. Вызывается под блокировкой на запись.
+func (e *InMemoryEngine) deleteKey(key string) {
+	if old, ok := e.data[key]; ok {
+		if e.index != nil {
+			e.index.delete(0, key)
+		}
+		e.scanIndex.delete(float64(scanHash(key)), key)
+		e.used.Add(-entrySize(key, old))
+	}
+	delete(e.data, key)
+	delete(e.expires, key)
+	delete(e.versions, key)
 }
@@ -1,9 +1,20 @@
 package engine
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+	"imkvdb/config"
+	"imkvdb/storage"
 )
 
 func TestInMemoryEngine(t *testing.T) {
@@ -11,7 +22,7 @@ func TestInMemoryEngine(t *testing.T) {
 	engine := NewInMemoryEngine(logger)
 
 	// Проверяем, что GET неизвестного ключа
-	if _, found := engine.Get("unknown"); found {
+	if _, found, _ := engine.Get("unknown"); found {
 		t.Error("expected 'unknown' key to not be found")
 	}
 
@@ -21,7 +32,7 @@ func TestInMemoryEngine(t *testing.T) {
 	}
 
 	// Теперь GET должен вернуть k1
-	if val, found := engine.Get("k1"); !found || val != "v1" {
+	if val, found, _ := engine.Get("k1"); !found || val != "v1" {
 		t.Errorf("got = %v, found=%v, want v1, true", val, found)
 	}
 
@@ -35,3 +46,870 @@ func TestInMemoryEngine(t *testing.T) {
 		t.Error("expected k1 deletion to fail on second time")
 	}
 }
+
+func TestInMemoryEngine_Expire(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	engine := NewInMemoryEngine(logger)
+
+	// Управляемые часы, чтобы не зависеть от реального времени
+	now := time.Unix(1000, 0)
+	engine.now = func() time.Time { return now }
+
+	_ = engine.Set("k1", "v1")
+	if !engine.Expire("k1", now.Add(10*time.Second)) {
+		t.Fatal("expected Expire on existing key to succeed")
+	}
+	if engine.Expire("missing", now.Add(10*time.Second)) {
+		t.Error("expected Expire on missing key to fail")
+	}
+
+	if at, ok := engine.ExpireTime("k1"); !ok || !at.Equal(now.Add(10*time.Second)) {
+		t.Errorf("got expire time %v, found=%v", at, ok)
+	}
+
+	// Время вышло -> ключ пропадает при чтении (ленивое удаление)
+	now = now.Add(10 * time.Second)
+	if _, found, _ := engine.Get("k1"); found {
+		t.Error("expected k1 to be expired")
+	}
+	if _, ok := engine.data["k1"]; ok {
+		t.Error("expected expired k1 to be removed from data")
+	}
+
+	// Повторный SET сбрасывает TTL, PERSIST снимает его
+	_ = engine.Set("k2", "v2")
+	engine.Expire("k2", now.Add(time.Minute))
+	if !engine.Persist("k2") {
+		t.Error("expected Persist to remove TTL")
+	}
+	if engine.Persist("k2") {
+		t.Error("expected second Persist to fail: no TTL")
+	}
+	if at, ok := engine.ExpireTime("k2"); !ok || !at.IsZero() {
+		t.Errorf("expected k2 without TTL, got %v, found=%v", at, ok)
+	}
+
+	// Время в прошлом удаляет ключ сразу
+	if !engine.Expire("k2", now.Add(-time.Second)) {
+		t.Error("expected Expire in the past to succeed")
+	}
+	if _, found, _ := engine.Get("k2"); found {
+		t.Error("expected k2 to be deleted by Expire in the past")
+	}
+
+	// Истёкший, но ещё не удалённый ключ не виден ни в Len, ни в Scan
+	_ = engine.Set("k3", "v3")
+	_ = engine.Set("k4", "v4")
+	engine.Expire("k4", now.Add(time.Second))
+	now = now.Add(time.Second)
+	if n := engine.Len(); n != 1 {
+		t.Errorf("Len: got %d, want 1", n)
+	}
+	if _, keys := engine.Scan(0, 10); len(keys) != 1 || keys[0] != "k3" {
+		t.Errorf("Scan: got %q, want [k3]", keys)
+	}
+}
+
+func TestInMemoryEngine_Sweeper(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	engine := NewInMemoryEngine(logger)
+
+	_ = engine.Set("short", "v")
+	_ = engine.Set("long", "v")
+	engine.Expire("short", time.Now().Add(20*time.Millisecond))
+	engine.Expire("long", time.Now().Add(time.Hour))
+
+	engine.StartSweeper(5 * time.Millisecond)
+	defer engine.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		engine.mu.RLock()
+		_, ok := engine.data["short"]
+		engine.mu.RUnlock()
+		if !ok {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	engine.mu.RLock()
+	_, shortOK := engine.data["short"]
+	_, longOK := engine.data["long"]
+	engine.mu.RUnlock()
+	if shortOK {
+		t.Error("expected sweeper to remove expired key without reads")
+	}
+	if !longOK {
+		t.Error("expected key with future TTL to survive the sweep")
+	}
+}
+
+func TestInMemoryEngine_Versions(t *testing.T) {
+	engine := NewInMemoryEngine(zap.NewNop())
+
+	if v := engine.Version("k"); v != 0 {
+		t.Errorf("expected version 0 for missing key, got %d", v)
+	}
+	_ = engine.Set("k", "v1")
+	v1 := engine.Version("k")
+	_ = engine.Set("k", "v2")
+	v2 := engine.Version("k")
+	if v1 == 0 || v2 <= v1 {
+		t.Errorf("expected growing versions, got %d then %d", v1, v2)
+	}
+
+	// Удалённый и заново созданный ключ не получает прежнюю версию
+	engine.Del("k")
+	if v := engine.Version("k"); v != 0 {
+		t.Errorf("expected version 0 after delete, got %d", v)
+	}
+	_ = engine.Set("k", "v2")
+	if v := engine.Version("k"); v <= v2 {
+		t.Errorf("expected new version > %d after recreate, got %d", v2, v)
+	}
+
+	// SetVersion (реплей) подтягивает общий счётчик
+	engine.SetVersion("k", 100)
+	_ = engine.Set("other", "v")
+	if v := engine.Version("other"); v != 101 {
+		t.Errorf("expected version 101 after SetVersion(100), got %d", v)
+	}
+}
+
+func TestInMemoryEngine_Hash(t *testing.T) {
+	engine := NewInMemoryEngine(zap.NewNop())
+	now := time.Unix(1000, 0)
+	engine.now = func() time.Time { return now }
+
+	if added, err := engine.HSet("user:1", []string{"name", "Ann", "age", "30"}); err != nil || added != 2 {
+		t.Fatalf("HSet: got %d, %v; want 2, nil", added, err)
+	}
+	// Перезапись поля не считается добавлением, но меняет версию
+	v1 := engine.Version("user:1")
+	engine.Expire("user:1", now.Add(time.Minute))
+	if added, _ := engine.HSet("user:1", []string{"age", "31", "city", "Oslo"}); added != 1 {
+		t.Errorf("HSet existing: got %d new fields, want 1", added)
+	}
+	if engine.Version("user:1") <= v1 {
+		t.Error("expected HSet to bump version")
+	}
+	if at, _ := engine.ExpireTime("user:1"); !at.Equal(now.Add(time.Minute)) {
+		t.Errorf("expected HSet to keep TTL, got %v", at)
+	}
+	if val, ok, _ := engine.HGet("user:1", "age"); !ok || val != "31" {
+		t.Errorf("HGet: got %q, %v", val, ok)
+	}
+	if _, ok, _ := engine.HGet("user:1", "missing"); ok {
+		t.Error("expected missing field to be absent")
+	}
+	pairs, _ := engine.HGetAll("user:1")
+	if want := []string{"age", "31", "city", "Oslo", "name", "Ann"}; strings.Join(pairs, ",") != strings.Join(want, ",") {
+		t.Errorf("HGetAll: got %q, want %q", pairs, want)
+	}
+
+	// Операции другого типа – ErrWrongType
+	_ = engine.Set("str", "v")
+	if _, err := engine.HSet("str", []string{"f", "v"}); err != storage.ErrWrongType {
+		t.Errorf("HSet on string: got %v, want ErrWrongType", err)
+	}
+	if _, _, err := engine.Get("user:1"); err != storage.ErrWrongType {
+		t.Errorf("Get on hash: got %v, want ErrWrongType", err)
+	}
+	if n, err := engine.HLen("missing"); err != nil || n != 0 {
+		t.Errorf("HLen missing: got %d, %v", n, err)
+	}
+
+	// Хеш без полей удаляется вместе с TTL и версией
+	if n, _ := engine.HDel("user:1", []string{"name", "age", "city", "nope"}); n != 3 {
+		t.Errorf("HDel: got %d, want 3", n)
+	}
+	if engine.Version("user:1") != 0 {
+		t.Error("expected empty hash to be deleted")
+	}
+
+	// Снимок и восстановление сохраняют тип
+	_, _ = engine.HSet("h", []string{"f", "v"})
+	restored := NewInMemoryEngine(zap.NewNop())
+	restored.Restore(engine.Dump())
+	if val, ok, _ := restored.HGet("h", "f"); !ok || val != "v" {
+		t.Errorf("restored HGet: got %q, %v", val, ok)
+	}
+	if val, _, _ := restored.Get("str"); val != "v" {
+		t.Errorf("restored Get: got %q", val)
+	}
+}
+
+func TestInMemoryEngine_List(t *testing.T) {
+	engine := NewInMemoryEngine(zap.NewNop())
+
+	if n, err := engine.RPush("q", []string{"b", "c"}); err != nil || n != 2 {
+		t.Fatalf("RPush: got %d, %v", n, err)
+	}
+	if n, _ := engine.LPush("q", []string{"a", "z"}); n != 4 {
+		t.Errorf("LPush: got length %d, want 4", n)
+	}
+	// Буфер растёт при переполнении, порядок сохраняется
+	for i := 0; i < 10; i++ {
+		_, _ = engine.RPush("q", []string{strconv.Itoa(i)})
+	}
+	for _, tt := range []struct {
+		start, stop int
+		want        string
+	}{
+		{0, 3, "z,a,b,c"},
+		{-3, -1, "7,8,9"},
+		{12, 100, "8,9"},
+		{5, 2, ""},
+		{-100, 0, "z"},
+	} {
+		items, _ := engine.LRange("q", tt.start, tt.stop)
+		if got := strings.Join(items, ","); got != tt.want {
+			t.Errorf("LRange(%d, %d): got %q, want %q", tt.start, tt.stop, got, tt.want)
+		}
+	}
+
+	if v, ok, _ := engine.LPop("q"); !ok || v != "z" {
+		t.Errorf("LPop: got %q, %v", v, ok)
+	}
+	if v, ok, _ := engine.RPop("q"); !ok || v != "9" {
+		t.Errorf("RPop: got %q, %v", v, ok)
+	}
+	for {
+		if _, ok, _ := engine.LPop("q"); !ok {
+			break
+		}
+	}
+	// Пустой список удаляется
+	if engine.Version("q") != 0 {
+		t.Error("expected empty list to be deleted")
+	}
+
+	_ = engine.Set("str", "v")
+	if _, err := engine.LPush("str", []string{"x"}); err != storage.ErrWrongType {
+		t.Errorf("LPush on string: got %v, want ErrWrongType", err)
+	}
+	if _, _, err := engine.LPop("str"); err != storage.ErrWrongType {
+		t.Errorf("LPop on string: got %v, want ErrWrongType", err)
+	}
+
+	_, _ = engine.RPush("l", []string{"1", "2", "3"})
+	_, _, _ = engine.LPop("l")
+	restored := NewInMemoryEngine(zap.NewNop())
+	restored.Restore(engine.Dump())
+	if items, _ := restored.LRange("l", 0, -1); strings.Join(items, ",") != "2,3" {
+		t.Errorf("restored list: got %q", items)
+	}
+}
+
+func TestInMemoryEngine_ZSet(t *testing.T) {
+	engine := NewInMemoryEngine(zap.NewNop())
+
+	// Достаточно элементов, чтобы skip list вырос на несколько уровней
+	var members []storage.ScoredMember
+	for i := 99; i >= 0; i-- {
+		members = append(members, storage.ScoredMember{Member: "m" + strconv.Itoa(i), Score: float64(i / 2)})
+	}
+	if n, err := engine.ZAdd("z", members); err != nil || n != 100 {
+		t.Fatalf("ZAdd: got %d, %v", n, err)
+	}
+	// Повторное добавление меняет счёт, но не считается новым
+	if n, _ := engine.ZAdd("z", []storage.ScoredMember{{Member: "m0", Score: 1000}, {Member: "new", Score: -1}}); n != 1 {
+		t.Errorf("ZAdd update: got %d, want 1", n)
+	}
+
+	join := func(members []storage.ScoredMember) string {
+		parts := make([]string, len(members))
+		for i, m := range members {
+			parts[i] = m.Member
+		}
+		return strings.Join(parts, ",")
+	}
+	// При равных счетах порядок – по элементу
+	if got, _ := engine.ZRange("z", 0, 3); join(got) != "new,m1,m2,m3" {
+		t.Errorf("ZRange(0, 3): got %q", join(got))
+	}
+	if got, _ := engine.ZRange("z", -2, -1); join(got) != "m99,m0" {
+		t.Errorf("ZRange(-2, -1): got %q", join(got))
+	}
+	if rank, ok, _ := engine.ZRank("z", "m50"); !ok || rank != 50 {
+		t.Errorf("ZRank(m50): got %d, %v, want 50", rank, ok)
+	}
+	if _, ok, _ := engine.ZRank("z", "missing"); ok {
+		t.Error("ZRank(missing): expected not found")
+	}
+
+	for _, tt := range []struct {
+		min, max      storage.ScoreBound
+		offset, count int
+		want          string
+	}{
+		{storage.ScoreBound{Value: 10}, storage.ScoreBound{Value: 11}, 0, -1, "m20,m21,m22,m23"},
+		{storage.ScoreBound{Value: 10, Exclusive: true}, storage.ScoreBound{Value: 12, Exclusive: true}, 0, -1, "m22,m23"},
+		{storage.ScoreBound{Value: 10}, storage.ScoreBound{Value: 11}, 1, 2, "m21,m22"},
+		{storage.ScoreBound{Value: math.Inf(-1)}, storage.ScoreBound{Value: 0}, 0, -1, "new,m1"},
+		{storage.ScoreBound{Value: 49}, storage.ScoreBound{Value: math.Inf(1)}, 0, -1, "m98,m99,m0"},
+		{storage.ScoreBound{Value: 5}, storage.ScoreBound{Value: 4}, 0, -1, ""},
+	} {
+		got, _ := engine.ZRangeByScore("z", tt.min, tt.max, tt.offset, tt.count)
+		if join(got) != tt.want {
+			t.Errorf("ZRangeByScore(%v, %v, %d, %d): got %q, want %q", tt.min, tt.max, tt.offset, tt.count, join(got), tt.want)
+		}
+	}
+
+	if n, _ := engine.ZRem("z", []string{"m0", "m0", "missing"}); n != 1 {
+		t.Errorf("ZRem: got %d, want 1", n)
+	}
+	if rank, _, _ := engine.ZRank("z", "m50"); rank != 50 {
+		t.Errorf("ZRank(m50) after ZRem: got %d, want 50", rank)
+	}
+
+	_ = engine.Set("str", "v")
+	if _, err := engine.ZAdd("str", members[:1]); err != storage.ErrWrongType {
+		t.Errorf("ZAdd on string: got %v, want ErrWrongType", err)
+	}
+	if _, err := engine.ZRange("str", 0, -1); err != storage.ErrWrongType {
+		t.Errorf("ZRange on string: got %v, want ErrWrongType", err)
+	}
+
+	restored := NewInMemoryEngine(zap.NewNop())
+	restored.Restore(engine.Dump())
+	if got, _ := restored.ZRange("z", 0, 2); join(got) != "new,m1,m2" {
+		t.Errorf("restored zset: got %q", join(got))
+	}
+
+	// Пустое множество удаляется
+	_, _ = engine.ZAdd("small", []storage.ScoredMember{{Member: "a", Score: 1}})
+	_, _ = engine.ZRem("small", []string{"a"})
+	if engine.Version("small") != 0 {
+		t.Error("expected empty zset to be deleted")
+	}
+}
+
+func TestInMemoryEngine_Set(t *testing.T) {
+	engine := NewInMemoryEngine(zap.NewNop())
+
+	if n, err := engine.SAdd("s", []string{"b", "a", "c", "a"}); err != nil || n != 3 {
+		t.Fatalf("SAdd: got %d, %v", n, err)
+	}
+	version := engine.Version("s")
+	// Повторное добавление ничего не меняет, и версия остаётся прежней
+	if n, _ := engine.SAdd("s", []string{"a"}); n != 0 || engine.Version("s") != version {
+		t.Errorf("SAdd existing: got %d, version changed=%v", n, engine.Version("s") != version)
+	}
+	if members, _ := engine.SMembers("s"); strings.Join(members, ",") != "a,b,c" {
+		t.Errorf("SMembers: got %q", members)
+	}
+	if ok, _ := engine.SIsMember("s", "b"); !ok {
+		t.Error("SIsMember(b): expected true")
+	}
+	if ok, _ := engine.SIsMember("missing", "b"); ok {
+		t.Error("SIsMember on missing key: expected false")
+	}
+	if n, _ := engine.SRem("s", []string{"b", "x"}); n != 1 {
+		t.Errorf("SRem: got %d, want 1", n)
+	}
+
+	_ = engine.Set("str", "v")
+	if _, err := engine.SAdd("str", []string{"x"}); err != storage.ErrWrongType {
+		t.Errorf("SAdd on string: got %v, want ErrWrongType", err)
+	}
+	if _, err := engine.SIsMember("str", "x"); err != storage.ErrWrongType {
+		t.Errorf("SIsMember on string: got %v, want ErrWrongType", err)
+	}
+
+	restored := NewInMemoryEngine(zap.NewNop())
+	restored.Restore(engine.Dump())
+	if members, _ := restored.SMembers("s"); strings.Join(members, ",") != "a,c" {
+		t.Errorf("restored set: got %q", members)
+	}
+
+	// Пустое множество удаляется
+	_, _ = engine.SRem("s", []string{"a", "c"})
+	if engine.Version("s") != 0 {
+		t.Error("expected empty set to be deleted")
+	}
+}
+
+func TestEngines_Scan(t *testing.T) {
+	for name, e := range map[string]Engine{
+		"in_memory": NewInMemoryEngine(zap.NewNop()),
+		"sharded":   NewShardedEngine(4, zap.NewNop()),
+	} {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 500; i++ {
+				_ = e.Set("k"+strconv.Itoa(i), "v")
+			}
+			if n := e.Len(); n != 500 {
+				t.Fatalf("Len: got %d, want 500", n)
+			}
+
+			// Ключи k0..k249 живут весь обход; остальные удаляются, а новые добавляются по ходу
+			seen := make(map[string]int)
+			cursor, round := uint64(0), 0
+			for {
+				next, keys := e.Scan(cursor, 7)
+				for _, key := range keys {
+					seen[key]++
+				}
+				_ = e.Set("new"+strconv.Itoa(round), "v")
+				e.Del("k" + strconv.Itoa(250+round))
+				round++
+				if cursor = next; cursor == 0 {
+					break
+				}
+			}
+			for i := 0; i < 250; i++ {
+				if n := seen["k"+strconv.Itoa(i)]; n != 1 {
+					t.Errorf("k%d returned %d times, want 1", i, n)
+				}
+			}
+			for key, n := range seen {
+				if n != 1 {
+					t.Errorf("%s returned %d times", key, n)
+				}
+			}
+
+			if next, keys := e.Scan(0, -1); next != 0 || len(keys) != e.Len() {
+				t.Errorf("full Scan: got next=%d and %d keys, want 0 and %d", next, len(keys), e.Len())
+			}
+		})
+	}
+}
+
+// Порция, которая кончается ключом с максимальным хэшем, – последняя: курсор 0
+func TestScanPage_LastHash(t *testing.T) {
+	const maxHash = 1<<scanHashBits - 1
+	items := []scanItem{{hash: maxHash - 1, key: "a"}, {hash: maxHash, key: "b"}, {hash: maxHash, key: "c"}}
+	if next, keys := scanPage(items, 1); next != maxHash || len(keys) != 1 {
+		t.Errorf("first page: got next=%d and %q, want %d and one key", next, keys, uint64(maxHash))
+	}
+	if next, keys := scanPage(items[1:], 1); next != 0 || len(keys) != 2 {
+		t.Errorf("last page: got next=%d and %q, want 0 and both keys with the last hash", next, keys)
+	}
+}
+
+// Индекс обхода меняется вместе с data: ключ попадает в него при создании
+// и убирается при любом удалении – DEL, опустевшей коллекции, истечении, вытеснении
+func TestInMemoryEngine_ScanIndex(t *testing.T) {
+	e := NewInMemoryEngine(zap.NewNop())
+	now := time.Unix(1000, 0)
+	e.now = func() time.Time { return now }
+
+	for i := 0; i < 100; i++ {
+		_ = e.Set("k"+strconv.Itoa(i), "v")
+	}
+	_ = e.Set("k0", "v2")
+	_, _ = e.HSet("h", []string{"f", "v"})
+	_, _ = e.HDel("h", []string{"f"})
+	e.Del("k1")
+	e.Expire("k2", now.Add(time.Second))
+	now = now.Add(time.Second)
+	e.sweepExpired()
+	e.Evict(config.EvictRandom)
+	e.Restore([]storage.Entry{{Key: "restored", Value: "v"}})
+
+	if e.scanIndex.length != len(e.data) {
+		t.Fatalf("scan index has %d keys, data has %d", e.scanIndex.length, len(e.data))
+	}
+	var prev scanItem
+	for x, i := e.scanIndex.header.level[0].forward, 0; x != nil; x, i = x.level[0].forward, i+1 {
+		item := scanItem{hash: uint64(x.score), key: x.member}
+		if _, ok := e.data[x.member]; !ok || item.hash != scanHash(x.member) {
+			t.Errorf("stale index node %q", x.member)
+		}
+		if i > 0 && !prev.less(item) {
+			t.Errorf("index out of order: %q after %q", x.member, prev.key)
+		}
+		prev = item
+	}
+}
+
+func TestOrderedEngine_Range(t *testing.T) {
+	engine := NewOrderedEngine(zap.NewNop())
+	now := time.Unix(1000, 0)
+	engine.now = func() time.Time { return now }
+
+	for _, key := range []string{
+		"metrics:2026-10-16:cpu", "metrics:2026-10-15:cpu", "metrics:2026-10-17:cpu",
+		"metrics:2026-10-16:mem", "users:1", "a", "metrics:\xff",
+	} {
+		_ = engine.Set(key, "v")
+	}
+	_, _ = engine.HSet("metrics:2026-10-16:disk", []string{"f", "v"})
+	// Удалённый и просроченный ключи в выдачу не попадают
+	engine.Del("users:1")
+	_ = engine.Set("metrics:2026-10-16:tmp", "v")
+	engine.Expire("metrics:2026-10-16:tmp", now.Add(time.Second))
+	now = now.Add(time.Second)
+
+	for _, tt := range []struct {
+		start, end string
+		limit      int
+		reverse    bool
+		want       string
+	}{
+		{"metrics:2026-10-16", "metrics:2026-10-17", -1, false,
+			"metrics:2026-10-16:cpu,metrics:2026-10-16:disk,metrics:2026-10-16:mem"},
+		{"metrics:2026-10-16", "metrics:2026-10-17", 2, true,
+			"metrics:2026-10-16:mem,metrics:2026-10-16:disk"},
+		{"metrics:2026-10-17", "", -1, false, "metrics:2026-10-17:cpu,metrics:\xff"},
+		{"", "metrics:2026-10-16", -1, true, "metrics:2026-10-15:cpu,a"},
+		{"", "", 1, false, "a"},
+		{"z", "", -1, false, ""},
+		{"b", "a", -1, false, ""},
+	} {
+		keys, err := engine.Range(tt.start, tt.end, tt.limit, tt.reverse)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(keys, ","); got != tt.want {
+			t.Errorf("Range(%q, %q, %d, %v): got %q, want %q", tt.start, tt.end, tt.limit, tt.reverse, got, tt.want)
+		}
+	}
+
+	// Порядок переживает снимок
+	restored := NewOrderedEngine(zap.NewNop())
+	restored.Restore(engine.Dump())
+	if keys, _ := restored.Range("metrics:2026-10-15", "metrics:2026-10-16:d", -1, false); strings.Join(keys, ",") != "metrics:2026-10-15:cpu,metrics:2026-10-16:cpu" {
+		t.Errorf("restored Range: got %q", keys)
+	}
+
+	if _, err := NewInMemoryEngine(zap.NewNop()).Range("", "", -1, false); err != storage.ErrUnordered {
+		t.Errorf("in_memory Range: got %v, want ErrUnordered", err)
+	}
+}
+
+func TestShardedEngine(t *testing.T) {
+	engine := NewShardedEngine(4, zap.NewNop())
+
+	for i := 0; i < 100; i++ {
+		key := "k" + strconv.Itoa(i)
+		if err := engine.Set(key, "v"+strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Ключи должны разойтись по всем партициям
+	for i, shard := range engine.shards {
+		if len(shard.data) == 0 {
+			t.Errorf("shard %d is empty", i)
+		}
+	}
+	if val, ok, _ := engine.Get("k42"); !ok || val != "v42" {
+		t.Errorf("got = %v, found=%v, want v42, true", val, ok)
+	}
+	if !engine.Del("k42") || engine.Del("k42") {
+		t.Error("expected first Del to succeed and second to fail")
+	}
+
+	// Atomic видит ключи всех партиций
+	err := engine.Atomic(func(tx storage.Tx) error {
+		for i := 0; i < 10; i++ {
+			key := "k" + strconv.Itoa(i)
+			val, _, _ := tx.Get(key)
+			if err := tx.Set(key, val+"!"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val, _, _ := engine.Get("k7"); val != "v7!" {
+		t.Errorf("got %q after Atomic, want v7!", val)
+	}
+
+	// Dump/Restore через движок с другим числом партиций
+	restored := NewShardedEngine(3, zap.NewNop())
+	restored.Restore(engine.Dump())
+	if got := len(restored.Dump()); got != 99 {
+		t.Errorf("restored %d keys, want 99", got)
+	}
+	if val, _, _ := restored.Get("k7"); val != "v7!" {
+		t.Errorf("got %q after Restore, want v7!", val)
+	}
+}
+
+// benchmarkMixed – параллельная смешанная нагрузка: readPercent% GET, остальное SET
+func benchmarkMixed(b *testing.B, e Engine, readPercent int) {
+	const keys = 10000
+	names := make([]string, keys)
+	for i := range names {
+		names[i] = "key:" + strconv.Itoa(i)
+		_ = e.Set(names[i], "value")
+	}
+
+	var seed atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(seed.Add(1)))
+		for pb.Next() {
+			key := names[r.Intn(keys)]
+			if r.Intn(100) < readPercent {
+				e.Get(key)
+			} else {
+				_ = e.Set(key, "value")
+			}
+		}
+	})
+}
+
+func BenchmarkEngines_ParallelMixed(b *testing.B) {
+	engines := map[string]func() Engine{
+		"in_memory": func() Engine { return NewInMemoryEngine(zap.NewNop()) },
+		"sharded":   func() Engine { return NewShardedEngine(DefaultShards, zap.NewNop()) },
+	}
+	for _, readPercent := range []int{90, 50, 10} {
+		for _, name := range []string{"in_memory", "sharded"} {
+			b.Run(fmt.Sprintf("%s/read%d", name, readPercent), func(b *testing.B) {
+				benchmarkMixed(b, engines[name](), readPercent)
+			})
+		}
+	}
+}
+
+func TestNew_Registry(t *testing.T) {
+	parse := func(content string) config.EngineConfig {
+		t.Helper()
+		var cfg config.Config
+		if err := yaml.Unmarshal([]byte(content), &cfg); err != nil {
+			t.Fatal(err)
+		}
+		return cfg.Engine
+	}
+
+	// Без опций – значения по умолчанию
+	eng, err := New(parse("engine:\n  type: in_memory\n"), zap.NewNop())
+	if err != nil {
+		t.Fatalf("in_memory: %v", err)
+	}
+	_ = eng.Close()
+
+	// Опции конкретного движка
+	eng, err = New(parse("engine:\n  type: sharded\n  options:\n    shards: 4\n    sweep_interval: 0s\n"), zap.NewNop())
+	if err != nil {
+		t.Fatalf("sharded: %v", err)
+	}
+	if sharded, ok := eng.(*ShardedEngine); !ok || len(sharded.shards) != 4 {
+		t.Errorf("expected ShardedEngine with 4 shards, got %#v", eng)
+	}
+	_ = eng.Close()
+
+	eng, err = New(parse("engine:\n  type: ordered\n"), zap.NewNop())
+	if err != nil {
+		t.Fatalf("ordered: %v", err)
+	}
+	if _, err := eng.Range("", "", -1, false); err != nil {
+		t.Errorf("ordered Range: %v", err)
+	}
+	_ = eng.Close()
+
+	// Неизвестный тип и опечатка в опциях – понятные ошибки
+	if _, err := New(parse("engine:\n  type: rocksdb\n"), zap.NewNop()); err == nil ||
+		!strings.Contains(err.Error(), `unknown engine type "rocksdb"`) || !strings.Contains(err.Error(), "in_memory") {
+		t.Errorf("unexpected error for unknown type: %v", err)
+	}
+	if _, err := New(parse("engine:\n  type: sharded\n  options:\n    shard: 4\n"), zap.NewNop()); err == nil {
+		t.Error("expected error for unknown option")
+	}
+}
+
+func TestEngines_MemoryUsage(t *testing.T) {
+	for name, eng := range map[string]Engine{
+		"in_memory": NewInMemoryEngine(zap.NewNop()),
+		"sharded":   NewShardedEngine(4, zap.NewNop()),
+	} {
+		if got := eng.MemoryUsage(); got != 0 {
+			t.Errorf("%s: empty engine uses %d bytes", name, got)
+		}
+		_ = eng.Set("str", strings.Repeat("x", 1000))
+		afterSet := eng.MemoryUsage()
+		if afterSet < 1000 {
+			t.Errorf("%s: 1000-byte string estimated as %d bytes", name, afterSet)
+		}
+		_, _ = eng.RPush("list", []string{"a", "b", "c"})
+		_, _ = eng.HSet("hash", []string{"f", "v"})
+		_, _ = eng.SAdd("set", []string{"m"})
+		_, _ = eng.ZAdd("zset", []storage.ScoredMember{{Member: "m", Score: 1}})
+		full := eng.MemoryUsage()
+		if full <= afterSet {
+			t.Errorf("%s: collections did not add to the estimate: %d <= %d", name, full, afterSet)
+		}
+		for _, key := range []string{"str", "list", "hash", "set", "zset"} {
+			eng.Del(key)
+		}
+		if got := eng.MemoryUsage(); got != 0 {
+			t.Errorf("%s: %d bytes left after deleting all keys", name, got)
+		}
+	}
+}
+
+// TestInMemoryEngine_MemoryAccounting – оценка, которую движок ведёт по ходу изменений,
+// совпадает с подсчётом по всем ключам
+func TestInMemoryEngine_MemoryAccounting(t *testing.T) {
+	e := NewInMemoryEngine(zap.NewNop())
+	now := time.Now()
+	e.now = func() time.Time { return now }
+
+	check := func(step string) {
+		t.Helper()
+		want := int64(0)
+		for key, val := range e.data {
+			want += entrySize(key, val)
+		}
+		if got := e.MemoryUsage(); got != want {
+			t.Fatalf("%s: MemoryUsage() = %d, want %d", step, got, want)
+		}
+	}
+
+	_ = e.Set("s", "value")
+	_ = e.Set("s", "longer value")
+	check("overwrite string")
+	_, _ = e.HSet("h", []string{"f1", "v1", "f2", "v2"})
+	_, _ = e.HSet("h", []string{"f1", "much longer value"})
+	_, _ = e.HDel("h", []string{"f2", "missing"})
+	check("hash")
+	for i := 0; i < 20; i++ {
+		_, _ = e.RPush("l", []string{strconv.Itoa(i)})
+	}
+	_, _ = e.LPush("l", []string{"a", "bb"})
+	_, _, _ = e.LPop("l")
+	_, _, _ = e.RPop("l")
+	check("list")
+	_, _ = e.SAdd("set", []string{"a", "b", "c"})
+	_, _ = e.SRem("set", []string{"b"})
+	_, _ = e.ZAdd("z", []storage.ScoredMember{{Member: "a", Score: 1}, {Member: "b", Score: 2}})
+	_, _ = e.ZAdd("z", []storage.ScoredMember{{Member: "a", Score: 3}})
+	_, _ = e.ZRem("z", []string{"b"})
+	check("set and zset")
+	_ = e.Set("h", "string replaces hash")
+	_ = e.Atomic(func(tx storage.Tx) error {
+		_, _ = tx.SAdd("set2", []string{"x"})
+		tx.Del("set")
+		return nil
+	})
+	check("replace and atomic")
+	e.Expire("s", now.Add(time.Second))
+	now = now.Add(2 * time.Second)
+	e.sweepExpired()
+	check("expired")
+	e.Restore([]storage.Entry{
+		{Key: "l", Type: storage.TypeString, Value: "restored"},
+		{Key: "r", Type: storage.TypeHash, Items: []string{"f", "v"}},
+	})
+	check("restore")
+	for _, key := range []string{"h", "l", "set2", "z", "r"} {
+		e.Del(key)
+	}
+	check("all deleted")
+	if got := e.MemoryUsage(); got != 0 {
+		t.Errorf("%d bytes left after deleting all keys", got)
+	}
+}
+
+func TestInMemoryEngine_EvictionPolicies(t *testing.T) {
+	e := NewInMemoryEngine(zap.NewNop())
+	now := time.Now()
+	e.now = func() time.Time { return now }
+	tick := func() { now = now.Add(time.Second) }
+
+	// Ключей не больше evictionSamples – выборка охватывает все, выбор детерминирован.
+	// hot создан раньше всех, но к нему часто обращаются; cold записан последним.
+	_ = e.Set("hot", "1")
+	tick()
+	_ = e.Set("old", "2")
+	tick()
+	for i := 0; i < 10; i++ {
+		_, _, _ = e.Get("hot")
+	}
+	tick()
+	_ = e.Set("cold", "3")
+
+	// LRU: дольше всего не было обращений к old
+	if key, ok := e.Evict(config.EvictAllKeysLRU); !ok || key != "old" {
+		t.Errorf("allkeys-lru evicted %q, %v; want old", key, ok)
+	}
+	// LFU: к cold обращались реже, чем к hot, хотя cold новее
+	if key, ok := e.Evict(config.EvictAllKeysLFU); !ok || key != "cold" {
+		t.Errorf("allkeys-lfu evicted %q, %v; want cold", key, ok)
+	}
+
+	// volatile-ttl: только ключи с TTL, ближайший к истечению – первым
+	_ = e.Set("later", "x")
+	e.Expire("later", now.Add(time.Hour))
+	_ = e.Set("sooner", "x")
+	e.Expire("sooner", now.Add(time.Minute))
+	for _, want := range []string{"sooner", "later"} {
+		if key, ok := e.Evict(config.EvictVolatileTTL); !ok || key != want {
+			t.Errorf("volatile-ttl evicted %q, %v; want %s", key, ok, want)
+		}
+	}
+	if key, ok := e.Evict(config.EvictVolatileTTL); ok {
+		t.Errorf("volatile-ttl evicted %q without keys with TTL", key)
+	}
+	if _, ok := e.Evict(config.EvictNoEviction); ok {
+		t.Error("noeviction evicted a key")
+	}
+
+	if key, ok := e.Evict(config.EvictRandom); !ok || key != "hot" {
+		t.Errorf("random evicted %q, %v; want the only key hot", key, ok)
+	}
+	if _, ok := e.Evict(config.EvictRandom); ok {
+		t.Error("evicted a key from an empty engine")
+	}
+}
+
+func TestEvictor(t *testing.T) {
+	fill := func(eng Engine) {
+		for i := 0; i < 100; i++ {
+			_ = eng.Set(fmt.Sprintf("key%d", i), strings.Repeat("v", 100))
+		}
+	}
+
+	if ev, err := NewEvictor(NewInMemoryEngine(zap.NewNop()), config.MemoryConfig{}, zap.NewNop()); ev != nil || err != nil {
+		t.Errorf("no max_memory: got %v, %v; want no limit", ev, err)
+	}
+
+	eng := NewInMemoryEngine(zap.NewNop())
+	ev, err := NewEvictor(eng, config.MemoryConfig{MaxMemory: "4KB", EvictionPolicy: config.EvictNoEviction}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := ev.Evict(); len(keys) != 0 || err != nil {
+		t.Errorf("under the limit: got %v, %v", keys, err)
+	}
+	fill(eng)
+	if keys, err := ev.Evict(); len(keys) != 0 || !errors.Is(err, storage.ErrOOM) {
+		t.Errorf("noeviction over the limit: got %v, %v; want ErrOOM", keys, err)
+	}
+
+	for name, eng := range map[string]Engine{
+		"in_memory": NewInMemoryEngine(zap.NewNop()),
+		"sharded":   NewShardedEngine(4, zap.NewNop()),
+	} {
+		ev, err := NewEvictor(eng, config.MemoryConfig{MaxMemory: "4KB", EvictionPolicy: config.EvictRandom}, zap.NewNop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		fill(eng)
+		keys, err := ev.Evict()
+		if err != nil {
+			t.Fatalf("%s: Evict: %v", name, err)
+		}
+		if got := eng.MemoryUsage(); got > 4096 {
+			t.Errorf("%s: %d bytes used after eviction, limit 4096", name, got)
+		}
+		if len(keys)+eng.Len() != 100 {
+			t.Errorf("%s: evicted %d keys, %d left; want 100 in total", name, len(keys), eng.Len())
+		}
+	}
+
+	// volatile-ttl без ключей с TTL освободить память не может
+	eng = NewInMemoryEngine(zap.NewNop())
+	ev, _ = NewEvictor(eng, config.MemoryConfig{MaxMemory: "4KB", EvictionPolicy: config.EvictVolatileTTL}, zap.NewNop())
+	fill(eng)
+	if _, err := ev.Evict(); !errors.Is(err, storage.ErrOOM) {
+		t.Errorf("volatile-ttl without TTL keys: got %v, want ErrOOM", err)
+	}
+}
@@ -0,0 +1,197 @@
+package engine
+
+import (
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"imkvdb/config"
+	"imkvdb/storage"
+)
+
+// Вытеснение ключей при достижении memory.max_memory. Как и в Redis, LRU и LFU
+// приближённые: из evictionSamples случайных ключей вытесняется худший по политике,
+// поэтому выбор не требует упорядоченных по обращениям структур.
+const evictionSamples = 5
+
+// Счётчик LFU – логарифмический, как в Redis: чем он больше, тем меньше вероятность
+// его увеличить, поэтому в 8 бит помещаются миллионы обращений. Без обращений счётчик
+// уменьшается на 1 за каждый lfuDecayPeriod, чтобы давно популярные ключи не жили вечно.
+const (
+	lfuInitCounter = 5 // счётчик нового ключа: он не вытесняется сразу после записи
+	lfuMaxCounter  = 255
+	lfuLogFactor   = 10
+	lfuDecayPeriod = time.Minute
+)
+
+// usage – обращения к ключу. Обновляется и под блокировкой на чтение, поэтому поля
+// атомарные; одновременные обращения могут потерять приращение – для оценки это не важно.
+type usage struct {
+	lastAccess atomic.Int64  // время последнего обращения, UnixNano
+	counter    atomic.Uint32 // счётчик LFU на момент lastAccess
+}
+
+func newUsage(now time.Time) *usage {
+	u := &usage{}
+	u.lastAccess.Store(now.UnixNano())
+	u.counter.Store(lfuInitCounter)
+	return u
+}
+
+// touch отмечает обращение к ключу
+func (u *usage) touch(now time.Time) {
+	c := u.counterAt(now)
+	if c < lfuMaxCounter {
+		base := float64(c) - lfuInitCounter
+		if base < 0 {
+			base = 0
+		}
+		if rand.Float64() < 1/(base*lfuLogFactor+1) {
+			c++
+		}
+	}
+	u.counter.Store(c)
+	u.lastAccess.Store(now.UnixNano())
+}
+
+// counterAt – счётчик LFU на момент now с учётом затухания
+func (u *usage) counterAt(now time.Time) uint32 {
+	c := u.counter.Load()
+	periods := now.Sub(time.Unix(0, u.lastAccess.Load())) / lfuDecayPeriod
+	if periods <= 0 {
+		return c
+	}
+	if periods >= time.Duration(c) {
+		return 0
+	}
+	return c - uint32(periods)
+}
+
+// Evict удаляет ключ, выбранный по политике вытеснения; false – подходящих ключей нет
+func (e *InMemoryEngine) Evict(policy string) (string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	key, ok := e.evictionCandidate(policy)
+	if !ok {
+		return "", false
+	}
+	e.deleteKey(key)
+	e.logger.Debug("Evicted key",
+		zap.String("key", key),
+		zap.String("policy", policy),
+	)
+	return key, true
+}
+
+// evictionCandidate выбирает ключ для вытеснения. Порядок обхода map случайный,
+// поэтому первые evictionSamples ключей – случайная выборка. Вызывается под блокировкой.
+func (e *InMemoryEngine) evictionCandidate(policy string) (string, bool) {
+	switch policy {
+	case config.EvictRandom:
+		for key := range e.data {
+			return key, true
+		}
+	case config.EvictVolatileTTL:
+		var best string
+		var bestAt time.Time
+		n := 0
+		for key, at := range e.expires {
+			if n == 0 || at.Before(bestAt) {
+				best, bestAt = key, at
+			}
+			if n++; n >= evictionSamples {
+				break
+			}
+		}
+		return best, n > 0
+	case config.EvictAllKeysLRU, config.EvictAllKeysLFU:
+		now := e.now()
+		var best string
+		var bestCounter uint32
+		var bestAccess int64
+		n := 0
+		for key, val := range e.data {
+			counter, access := uint32(0), val.usage.lastAccess.Load()
+			if policy == config.EvictAllKeysLFU {
+				counter = val.usage.counterAt(now)
+			}
+			// LFU: меньший счётчик, при равных – более давнее обращение; LRU: только обращение
+			if n == 0 || counter < bestCounter || (counter == bestCounter && access < bestAccess) {
+				best, bestCounter, bestAccess = key, counter, access
+			}
+			if n++; n >= evictionSamples {
+				break
+			}
+		}
+		return best, n > 0
+	}
+	return "", false
+}
+
+// Evict вытесняет ключ из партиции, выбранной случайно; пустые партиции пропускаются.
+// Выборка ключей для LRU/LFU делается внутри одной партиции.
+func (e *ShardedEngine) Evict(policy string) (string, bool) {
+	start := rand.IntN(len(e.shards))
+	for i := range e.shards {
+		if key, ok := e.shards[(start+i)%len(e.shards)].Evict(policy); ok {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// Evictor держит память движка в пределах memory.max_memory: перед записью вытесняет
+// ключи по memory.eviction_policy. Вытесненные ключи вызывающий (compute) пишет в WAL
+// как удаления, чтобы реплики и реплей WAL пришли к тем же данным.
+type Evictor struct {
+	engine    Engine
+	maxMemory int64
+	policy    string
+	logger    *zap.Logger
+}
+
+// NewEvictor создаёт Evictor для e по cfg; без max_memory лимита нет – возвращается nil
+func NewEvictor(e Engine, cfg config.MemoryConfig, logger *zap.Logger) (*Evictor, error) {
+	if cfg.MaxMemory == "" {
+		return nil, nil
+	}
+	maxMemory, err := config.ParseSize(cfg.MaxMemory)
+	if err != nil {
+		return nil, err
+	}
+	if maxMemory <= 0 {
+		return nil, nil
+	}
+	policy := cfg.EvictionPolicy
+	if policy == "" {
+		policy = config.EvictNoEviction
+	}
+	maxMemoryGauge.Set(float64(maxMemory))
+	return &Evictor{engine: e, maxMemory: int64(maxMemory), policy: policy, logger: logger}, nil
+}
+
+// Evict вытесняет ключи, пока оценка памяти выше лимита, и возвращает их.
+// storage.ErrOOM – лимит превышен, а вытеснять нельзя (noeviction) или больше нечего;
+// ключи, вытесненные до этого, всё равно возвращаются.
+func (ev *Evictor) Evict() ([]string, error) {
+	var evicted []string
+	defer func() { evictedKeys.Add(float64(len(evicted))) }()
+	for ev.engine.MemoryUsage() > ev.maxMemory {
+		if ev.policy == config.EvictNoEviction {
+			oomRejections.Inc()
+			return evicted, storage.ErrOOM
+		}
+		key, ok := ev.engine.Evict(ev.policy)
+		if !ok {
+			oomRejections.Inc()
+			ev.logger.Warn("max_memory reached and no keys can be evicted",
+				zap.String("policy", ev.policy),
+				zap.Int64("max_memory", ev.maxMemory),
+			)
+			return evicted, storage.ErrOOM
+		}
+		evicted = append(evicted, key)
+	}
+	return evicted, nil
+}
@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"go.uber.org/zap"
+	"imkvdb/storage"
+)
+
+// Операции над хешами (storage.HashTx). Записывающие операции берут блокировку на запись,
+// читающие – на чтение; внутри Atomic те же *Locked-методы вызывает lockedView.
+
+func (e *InMemoryEngine) HSet(key string, pairs []string) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.hsetLocked(key, pairs)
+}
+
+func (e *InMemoryEngine) HGet(key, field string) (string, bool, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.hgetLocked(key, field)
+}
+
+func (e *InMemoryEngine) HDel(key string, fields []string) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.hdelLocked(key, fields)
+}
+
+func (e *InMemoryEngine) HGetAll(key string) ([]string, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.hgetallLocked(key)
+}
+
+func (e *InMemoryEngine) HLen(key string) (int, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.hlenLocked(key)
+}
+
+func (v lockedView) HSet(key string, pairs []string) (int, error) { return v.e.hsetLocked(key, pairs) }
+
+func (v lockedView) HGet(key, field string) (string, bool, error) { return v.e.hgetLocked(key, field) }
+
+func (v lockedView) HDel(key string, fields []string) (int, error) {
+	return v.e.hdelLocked(key, fields)
+}
+
+func (v lockedView) HGetAll(key string) ([]string, error) { return v.e.hgetallLocked(key) }
+
+func (v lockedView) HLen(key string) (int, error) { return v.e.hlenLocked(key) }
+
+// hashLocked – хеш ключа; nil без ошибки – ключа нет. Вызывается под блокировкой.
+func (e *InMemoryEngine) hashLocked(key string) (map[string]string, error) {
+	val, ok := e.lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	if val.typ != storage.TypeHash {
+		return nil, storage.ErrWrongType
+	}
+	return val.hash, nil
+}
+
+// hsetLocked создаёт хеш при первой записи; TTL существующего ключа сохраняется
+func (e *InMemoryEngine) hsetLocked(key string, pairs []string) (int, error) {
+	if !e.existsLocked(key) {
+		e.putKey(key, value{typ: storage.TypeHash, hash: make(map[string]string, len(pairs)/2)})
+	}
+	h, err := e.hashLocked(key)
+	if err != nil {
+		return 0, err
+	}
+	added, grown := 0, int64(0)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if old, ok := h[pairs[i]]; ok {
+			grown -= hashFieldSize(pairs[i], old)
+		} else {
+			added++
+		}
+		h[pairs[i]] = pairs[i+1]
+		grown += hashFieldSize(pairs[i], pairs[i+1])
+	}
+	e.used.Add(grown)
+	e.bumpVersion(key)
+	e.logger.Debug("HSet fields",
+		zap.String("key", key),
+		zap.Int("fields", len(pairs)/2),
+	)
+	return added, nil
+}
+
+func (e *InMemoryEngine) hgetLocked(key, field string) (string, bool, error) {
+	h, err := e.hashLocked(key)
+	if err != nil || h == nil {
+		return "", false, err
+	}
+	val, ok := h[field]
+	return val, ok, nil
+}
+
+// hdelLocked удаляет поля; хеш без полей удаляется целиком
+func (e *InMemoryEngine) hdelLocked(key string, fields []string) (int, error) {
+	h, err := e.hashLocked(key)
+	if err != nil || h == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, f := range fields {
+		if val, ok := h[f]; ok {
+			delete(h, f)
+			e.used.Add(-hashFieldSize(f, val))
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	if len(h) == 0 {
+		e.deleteKey(key)
+	} else {
+		e.bumpVersion(key)
+	}
+	e.logger.Debug("HDel fields",
+		zap.String("key", key),
+		zap.Int("removed", removed),
+	)
+	return removed, nil
+}
+
+func (e *InMemoryEngine) hgetallLocked(key string) ([]string, error) {
+	h, err := e.hashLocked(key)
+	if err != nil || h == nil {
+		return nil, err
+	}
+	return hashPairs(h), nil
+}
+
+func (e *InMemoryEngine) hlenLocked(key string) (int, error) {
+	h, err := e.hashLocked(key)
+	return len(h), err
+}
@@ -0,0 +1,231 @@
+package engine
+
+import (
+	"go.uber.org/zap"
+	"imkvdb/storage"
+)
+
+// list – двусторонняя очередь на кольцевом буфере: вставка и извлечение
+// с обоих концов за O(1), поэтому список годится как очередь задач
+type list struct {
+	buf  []string
+	head int // индекс первого элемента в buf
+	n    int // число элементов
+}
+
+func (l *list) len() int { return l.n }
+
+// at – i-й элемент от начала списка
+func (l *list) at(i int) string { return l.buf[(l.head+i)%len(l.buf)] }
+
+func (l *list) pushFront(v string) {
+	l.grow()
+	l.head = (l.head - 1 + len(l.buf)) % len(l.buf)
+	l.buf[l.head] = v
+	l.n++
+}
+
+func (l *list) pushBack(v string) {
+	l.grow()
+	l.buf[(l.head+l.n)%len(l.buf)] = v
+	l.n++
+}
+
+func (l *list) popFront() string {
+	v := l.buf[l.head]
+	l.buf[l.head] = "" // не держим ссылку на строку
+	l.head = (l.head + 1) % len(l.buf)
+	l.n--
+	return v
+}
+
+func (l *list) popBack() string {
+	i := (l.head + l.n - 1) % len(l.buf)
+	v := l.buf[i]
+	l.buf[i] = ""
+	l.n--
+	return v
+}
+
+// slice – копия элементов с from по to (не включая)
+func (l *list) slice(from, to int) []string {
+	res := make([]string, 0, to-from)
+	for i := from; i < to; i++ {
+		res = append(res, l.at(i))
+	}
+	return res
+}
+
+// grow удваивает буфер, когда он заполнен
+func (l *list) grow() {
+	if l.n < len(l.buf) {
+		return
+	}
+	buf := make([]string, max(4, 2*len(l.buf)))
+	for i := 0; i < l.n; i++ {
+		buf[i] = l.at(i)
+	}
+	l.buf, l.head = buf, 0
+}
+
+// Операции над списками (storage.ListTx) – по той же схеме, что и хеши
+
+func (e *InMemoryEngine) LPush(key string, values []string) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.pushLocked(key, values, true)
+}
+
+func (e *InMemoryEngine) RPush(key string, values []string) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.pushLocked(key, values, false)
+}
+
+func (e *InMemoryEngine) LPop(key string) (string, bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.popLocked(key, true)
+}
+
+func (e *InMemoryEngine) RPop(key string) (string, bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.popLocked(key, false)
+}
+
+func (e *InMemoryEngine) LRange(key string, start, stop int) ([]string, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.lrangeLocked(key, start, stop)
+}
+
+func (e *InMemoryEngine) LLen(key string) (int, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.llenLocked(key)
+}
+
+func (v lockedView) LPush(key string, values []string) (int, error) {
+	return v.e.pushLocked(key, values, true)
+}
+
+func (v lockedView) RPush(key string, values []string) (int, error) {
+	return v.e.pushLocked(key, values, false)
+}
+
+func (v lockedView) LPop(key string) (string, bool, error) { return v.e.popLocked(key, true) }
+
+func (v lockedView) RPop(key string) (string, bool, error) { return v.e.popLocked(key, false) }
+
+func (v lockedView) LRange(key string, start, stop int) ([]string, error) {
+	return v.e.lrangeLocked(key, start, stop)
+}
+
+func (v lockedView) LLen(key string) (int, error) { return v.e.llenLocked(key) }
+
+// listLocked – список ключа; nil без ошибки – ключа нет. Вызывается под блокировкой.
+func (e *InMemoryEngine) listLocked(key string) (*list, error) {
+	val, ok := e.lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	if val.typ != storage.TypeList {
+		return nil, storage.ErrWrongType
+	}
+	return val.list, nil
+}
+
+// pushLocked вставляет значения в начало (front) или конец списка, создавая его при необходимости
+func (e *InMemoryEngine) pushLocked(key string, values []string, front bool) (int, error) {
+	if !e.existsLocked(key) {
+		e.putKey(key, value{typ: storage.TypeList, list: &list{}})
+	}
+	l, err := e.listLocked(key)
+	if err != nil {
+		return 0, err
+	}
+	grown := -int64(len(l.buf)) * stringOverhead
+	for _, v := range values {
+		if front {
+			l.pushFront(v)
+		} else {
+			l.pushBack(v)
+		}
+		grown += int64(len(v))
+	}
+	e.used.Add(grown + int64(len(l.buf))*stringOverhead)
+	e.bumpVersion(key)
+	e.logger.Debug("Push to list",
+		zap.String("key", key),
+		zap.Int("count", len(values)),
+		zap.Bool("front", front),
+	)
+	return l.len(), nil
+}
+
+// popLocked забирает элемент из начала (front) или конца; пустой список удаляется
+func (e *InMemoryEngine) popLocked(key string, front bool) (string, bool, error) {
+	l, err := e.listLocked(key)
+	if err != nil || l == nil {
+		return "", false, err
+	}
+	var v string
+	if front {
+		v = l.popFront()
+	} else {
+		v = l.popBack()
+	}
+	e.used.Add(-int64(len(v)))
+	if l.len() == 0 {
+		e.deleteKey(key)
+	} else {
+		e.bumpVersion(key)
+	}
+	e.logger.Debug("Pop from list",
+		zap.String("key", key),
+		zap.Bool("front", front),
+	)
+	return v, true, nil
+}
+
+func (e *InMemoryEngine) lrangeLocked(key string, start, stop int) ([]string, error) {
+	l, err := e.listLocked(key)
+	if err != nil || l == nil {
+		return nil, err
+	}
+	from, to, ok := rangeBounds(l.len(), start, stop)
+	if !ok {
+		return nil, nil
+	}
+	return l.slice(from, to), nil
+}
+
+func (e *InMemoryEngine) llenLocked(key string) (int, error) {
+	l, err := e.listLocked(key)
+	if err != nil || l == nil {
+		return 0, err
+	}
+	return l.len(), nil
+}
+
+// rangeBounds переводит индексы start..stop (включительно, отрицательные – с конца, как в Redis)
+// в полуинтервал [from, to) для коллекции длины n; ok=false – диапазон пуст
+func rangeBounds(n, start, stop int) (from, to int, ok bool) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop || start >= n {
+		return 0, 0, false
+	}
+	return start, stop + 1, true
+}
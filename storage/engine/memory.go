@@ -0,0 +1,80 @@
+package engine
+
+import "imkvdb/storage"
+
+// Оценка занимаемой памяти: длины строк плюс примерные накладные расходы структур Go
+// (заголовки строк, записи map, узлы skip list). Точный учёт аллокатора не нужен –
+// оценка служит для метрик и сравнения с лимитом memory.max_memory.
+//
+// Оценка ведётся по ходу изменений (InMemoryEngine.used): putKey и deleteKey учитывают
+// значение целиком, операции над коллекциями – каждый добавленный или удалённый элемент.
+// Так MemoryUsage не обходит данные и её можно вызывать перед каждой записью.
+const (
+	// keyOverhead – ключ в data, versions, узел индекса SCAN и (если есть) expires/index,
+	// заголовок value и сведения об обращениях (usage)
+	keyOverhead = 240
+	// stringOverhead – заголовок строки
+	stringOverhead = 16
+	// mapEntryOverhead – запись map (ключ, значение, служебные байты бакета)
+	mapEntryOverhead = 48
+	// skiplistNodeOverhead – узел skip list в среднем с 1.33 уровня
+	skiplistNodeOverhead = 80
+)
+
+// Оценки отдельных элементов коллекций
+func hashFieldSize(field, val string) int64 { return mapEntryOverhead + int64(len(field)+len(val)) }
+func setMemberSize(member string) int64     { return mapEntryOverhead + int64(len(member)) }
+func zsetMemberSize(member string) int64 {
+	return mapEntryOverhead + skiplistNodeOverhead + 2*int64(len(member))
+}
+
+// size – оценка памяти значения без ключа
+func (v value) size() int64 {
+	switch v.typ {
+	case storage.TypeHash:
+		n := int64(0)
+		for f, val := range v.hash {
+			n += hashFieldSize(f, val)
+		}
+		return n
+	case storage.TypeList:
+		n := int64(len(v.list.buf)) * stringOverhead
+		for i := 0; i < v.list.len(); i++ {
+			n += int64(len(v.list.at(i)))
+		}
+		return n
+	case storage.TypeZSet:
+		n := int64(0)
+		for member := range v.zset.scores {
+			n += zsetMemberSize(member)
+		}
+		return n
+	case storage.TypeSet:
+		n := int64(0)
+		for member := range v.set {
+			n += setMemberSize(member)
+		}
+		return n
+	default:
+		return int64(len(v.str))
+	}
+}
+
+// entrySize – оценка памяти ключа вместе со значением
+func entrySize(key string, v value) int64 {
+	return keyOverhead + int64(len(key)) + v.size()
+}
+
+// MemoryUsage – оценка памяти, занятой ключами и значениями (включая истёкшие,
+// но ещё не удалённые ключи)
+func (e *InMemoryEngine) MemoryUsage() int64 {
+	return e.used.Load()
+}
+
+func (e *ShardedEngine) MemoryUsage() int64 {
+	n := int64(0)
+	for _, shard := range e.shards {
+		n += shard.MemoryUsage()
+	}
+	return n
+}
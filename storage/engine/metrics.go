@@ -0,0 +1,19 @@
+package engine
+
+import "imkvdb/metrics"
+
+var (
+	keysGauge      = metrics.NewGaugeFunc("imkvdb_keys", "Number of live keys in the engine", nil)
+	memoryGauge    = metrics.NewGaugeFunc("imkvdb_memory_bytes", "Estimated memory used by keys and values", nil)
+	maxMemoryGauge = metrics.NewGauge("imkvdb_memory_max_bytes", "Configured memory.max_memory (0 – no limit)")
+	evictedKeys    = metrics.NewCounter("imkvdb_evicted_keys_total", "Keys evicted because memory.max_memory was reached")
+	oomRejections  = metrics.NewCounter("imkvdb_oom_rejections_total",
+		"Writes rejected because memory.max_memory was reached and nothing could be evicted")
+)
+
+// ExportMetrics отдаёт число ключей и оценку памяти движка e в метрики
+// (вычисляются при каждом сборе метрик)
+func ExportMetrics(e Engine) {
+	keysGauge.Set(func() float64 { return float64(e.Len()) })
+	memoryGauge.Set(func() float64 { return float64(e.MemoryUsage()) })
+}
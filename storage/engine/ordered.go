@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+	"imkvdb/storage"
+)
+
+// Упорядоченный движок "ordered" – InMemoryEngine, который дополнительно держит ключи
+// в skip list (тот же, что у сортированных множеств, со счётом 0 у всех узлов –
+// тогда порядок чисто лексикографический). Get/Set/Del работают как в in_memory,
+// запись нового и удаление ключа стоят ещё O(log n) на индекс, зато Range отдаёт
+// диапазоны и префиксы ключей по порядку.
+
+func init() {
+	Register("ordered", func(options *yaml.Node, logger *zap.Logger) (Engine, error) {
+		opts := InMemoryOptions{SweepInterval: DefaultSweepInterval}
+		if err := DecodeOptions(options, &opts); err != nil {
+			return nil, err
+		}
+		e := NewOrderedEngine(logger)
+		if opts.SweepInterval > 0 {
+			e.StartSweeper(opts.SweepInterval)
+		}
+		return e, nil
+	})
+}
+
+// NewOrderedEngine – InMemoryEngine с упорядоченным индексом ключей
+func NewOrderedEngine(logger *zap.Logger) *InMemoryEngine {
+	e := NewInMemoryEngine(logger)
+	index := newSkiplist()
+	e.index = &index
+	return e
+}
+
+func (e *InMemoryEngine) Range(start, end string, limit int, reverse bool) ([]string, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.rangeLocked(start, end, limit, reverse)
+}
+
+func (v lockedView) Range(start, end string, limit int, reverse bool) ([]string, error) {
+	return v.e.rangeLocked(start, end, limit, reverse)
+}
+
+// rangeLocked обходит индекс от start вперёд или от end назад; просроченные ключи пропускаются.
+// Вызывается под блокировкой (достаточно блокировки на чтение).
+func (e *InMemoryEngine) rangeLocked(start, end string, limit int, reverse bool) ([]string, error) {
+	if e.index == nil {
+		return nil, storage.ErrUnordered
+	}
+	var keys []string
+	full := func() bool { return limit >= 0 && len(keys) >= limit }
+	if !reverse {
+		for x := e.index.firstFromKey(start); x != nil && !full(); x = x.level[0].forward {
+			if end != "" && x.member >= end {
+				break
+			}
+			if !e.isExpired(x.member) {
+				keys = append(keys, x.member)
+			}
+		}
+		return keys, nil
+	}
+	// Обратного указателя у узлов нет: идём по рангам, каждый шаг – O(log n)
+	last := e.index.length
+	if end != "" {
+		last = e.index.countBefore(0, end)
+	}
+	for rank := last - 1; rank >= 0 && !full(); rank-- {
+		x := e.index.byRank(rank)
+		if x.member < start {
+			break
+		}
+		if !e.isExpired(x.member) {
+			keys = append(keys, x.member)
+		}
+	}
+	return keys, nil
+}
+
+// firstFromKey – первый узел индекса не меньше key
+func (sl *skiplist) firstFromKey(key string) *skiplistNode {
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && before(x.level[i].forward, 0, key) {
+			x = x.level[i].forward
+		}
+	}
+	return x.level[0].forward
+}
@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+	"imkvdb/config"
+)
+
+// Factory создаёт движок. options – секция engine.options из YAML,
+// движок разбирает её в свою структуру через DecodeOptions.
+type Factory func(options *yaml.Node, logger *zap.Logger) (Engine, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register регистрирует реализацию движка под именем name (значение engine.type).
+// Обычно вызывается из init() пакета с реализацией. Повторная регистрация имени – паника,
+// как в database/sql.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if factory == nil {
+		panic("engine: Register factory is nil for " + name)
+	}
+	if _, dup := registry[name]; dup {
+		panic("engine: Register called twice for " + name)
+	}
+	registry[name] = factory
+}
+
+// Registered – имена зарегистрированных движков по алфавиту
+func Registered() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New создаёт движок по cfg.Type. Неизвестный тип или неверные опции – ошибка.
+func New(cfg config.EngineConfig, logger *zap.Logger) (Engine, error) {
+	registryMu.RLock()
+	factory, ok := registry[cfg.Type]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown engine type %q (available: %s)",
+			cfg.Type, strings.Join(Registered(), ", "))
+	}
+	eng, err := factory(&cfg.Options, logger)
+	if err != nil {
+		return nil, fmt.Errorf("engine %q: %w", cfg.Type, err)
+	}
+	return eng, nil
+}
+
+// DecodeOptions разбирает опции движка в out. Пустая секция – не ошибка (остаются значения out),
+// неизвестные поля – ошибка, чтобы опечатка в конфиге не проходила молча.
+func DecodeOptions(options *yaml.Node, out any) error {
+	if options == nil || options.Kind == 0 {
+		return nil
+	}
+	raw, err := yaml.Marshal(options)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(raw))
+	dec.KnownFields(true)
+	if err := dec.Decode(out); err != nil {
+		return fmt.Errorf("invalid options: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"sort"
+
+	"imkvdb/storage"
+)
+
+// Обход ключей (SCAN). Курсор – позиция в пространстве 53-битных хэшей ключей (scanHash):
+// порция – ключи с наименьшими хэшами не меньше курсора, следующий курсор – хэш последнего
+// ключа порции плюс 1. Порядок обхода не зависит от порядка в map и от вставок и удалений,
+// поэтому ключ, живущий весь обход, не теряется и не повторяется. Ключи с одинаковым хэшем
+// всегда попадают в одну порцию.
+//
+// Ключи партиции лежат в skip list (scanIndex) по возрастанию (хэш, ключ): хэш – счёт узла,
+// 53 бита точно представимы в float64. Порция ищется от курсора за O(log n) и читается
+// подряд, поэтому один вызов стоит O(log n + count), а не обход всех ключей.
+
+// scanHashBits – разрядность хэша обхода: столько бит мантиссы у float64
+const scanHashBits = 53
+
+// keyHash – хэш ключа (FNV-1a без аллокаций); по нему выбирается партиция ShardedEngine
+func keyHash(key string) uint64 {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	h := uint64(offset64)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= prime64
+	}
+	return h
+}
+
+// scanHash – хэш ключа, по которому упорядочен обход: старшие scanHashBits бит keyHash
+func scanHash(key string) uint64 {
+	return keyHash(key) >> (64 - scanHashBits)
+}
+
+func (e *InMemoryEngine) Scan(cursor uint64, count int) (uint64, []string) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return scanPage(e.scanFromLocked(cursor, count), count)
+}
+
+func (e *InMemoryEngine) Len() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.lenLocked()
+}
+
+func (v lockedView) Scan(cursor uint64, count int) (uint64, []string) {
+	return scanPage(v.e.scanFromLocked(cursor, count), count)
+}
+
+func (v lockedView) Len() int { return v.e.lenLocked() }
+
+// scanItem – ключ вместе с его хэшем
+type scanItem struct {
+	hash uint64
+	key  string
+}
+
+func (a scanItem) less(b scanItem) bool {
+	return a.hash < b.hash || (a.hash == b.hash && a.key < b.key)
+}
+
+// scanFromLocked – count живых ключей (count < 0 – все) с наименьшими хэшами не меньше cursor,
+// по возрастанию (хэш, ключ), и сверх count – остальные ключи с хэшем последнего.
+// Вызывается под блокировкой (годится и блокировка на чтение: просроченные ключи пропускаются).
+func (e *InMemoryEngine) scanFromLocked(cursor uint64, count int) []scanItem {
+	if cursor >= 1<<scanHashBits {
+		return nil
+	}
+	var items []scanItem
+	from := storage.ScoreBound{Value: float64(cursor)}
+	for x := e.scanIndex.firstFrom(from); x != nil; x = x.level[0].forward {
+		hash := uint64(x.score)
+		// Коллизии хэшей: курсор не может указать внутрь группы ключей с одним хэшем
+		if count >= 0 && len(items) > 0 && len(items) >= count && hash != items[len(items)-1].hash {
+			break
+		}
+		if !e.isExpired(x.member) {
+			items = append(items, scanItem{hash: hash, key: x.member})
+		}
+	}
+	return items
+}
+
+// sortScanItems упорядочивает кандидатов по (хэш, ключ)
+func sortScanItems(items []scanItem) []scanItem {
+	sort.Slice(items, func(i, j int) bool { return items[i].less(items[j]) })
+	return items
+}
+
+// scanPage обрезает упорядоченных кандидатов до порции из count ключей (не разрывая группу
+// с одинаковым хэшем) и вычисляет следующий курсор
+func scanPage(items []scanItem, count int) (uint64, []string) {
+	if count >= 0 && len(items) > count {
+		n := count
+		for n < len(items) && n > 0 && items[n].hash == items[n-1].hash {
+			n++
+		}
+		items = items[:n]
+	}
+	keys := make([]string, len(items))
+	for i, item := range items {
+		keys[i] = item.key
+	}
+	if count < 0 || len(items) < count || len(items) == 0 {
+		return 0, keys
+	}
+	next := items[len(items)-1].hash + 1
+	// Хэш последнего ключа максимальный – дальше ключей нет, курсор 1<<scanHashBits
+	// стоил бы клиенту лишнего пустого запроса
+	if next == 1<<scanHashBits {
+		return 0, keys
+	}
+	return next, keys
+}
+
+// lenLocked – число живых ключей. Вызывается под блокировкой.
+func (e *InMemoryEngine) lenLocked() int {
+	n := len(e.data)
+	for key := range e.expires {
+		if e.isExpired(key) {
+			n--
+		}
+	}
+	return n
+}
@@ -0,0 +1,149 @@
+package engine
+
+import (
+	"sort"
+
+	"go.uber.org/zap"
+	"imkvdb/storage"
+)
+
+// Операции над множествами (storage.SetTx), устроены так же, как операции над хешами
+
+func (e *InMemoryEngine) SAdd(key string, members []string) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.saddLocked(key, members)
+}
+
+func (e *InMemoryEngine) SRem(key string, members []string) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.sremLocked(key, members)
+}
+
+func (e *InMemoryEngine) SMembers(key string) ([]string, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.smembersLocked(key)
+}
+
+func (e *InMemoryEngine) SIsMember(key, member string) (bool, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.sismemberLocked(key, member)
+}
+
+func (v lockedView) SAdd(key string, members []string) (int, error) {
+	return v.e.saddLocked(key, members)
+}
+
+func (v lockedView) SRem(key string, members []string) (int, error) {
+	return v.e.sremLocked(key, members)
+}
+
+func (v lockedView) SMembers(key string) ([]string, error) { return v.e.smembersLocked(key) }
+
+func (v lockedView) SIsMember(key, member string) (bool, error) {
+	return v.e.sismemberLocked(key, member)
+}
+
+// setValueLocked – множество ключа; nil без ошибки – ключа нет. Вызывается под блокировкой.
+func (e *InMemoryEngine) setValueLocked(key string) (map[string]struct{}, error) {
+	val, ok := e.lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	if val.typ != storage.TypeSet {
+		return nil, storage.ErrWrongType
+	}
+	return val.set, nil
+}
+
+// saddLocked создаёт множество при первом добавлении; TTL существующего ключа сохраняется.
+// Версия меняется, только если добавлен хотя бы один новый элемент.
+func (e *InMemoryEngine) saddLocked(key string, members []string) (int, error) {
+	if !e.existsLocked(key) {
+		e.putKey(key, value{typ: storage.TypeSet, set: make(map[string]struct{}, len(members))})
+	}
+	set, err := e.setValueLocked(key)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, m := range members {
+		if _, ok := set[m]; !ok {
+			set[m] = struct{}{}
+			e.used.Add(setMemberSize(m))
+			added++
+		}
+	}
+	if len(set) == 0 {
+		// SADD без аргументов не должен оставлять пустое множество
+		e.deleteKey(key)
+		return 0, nil
+	}
+	if added > 0 {
+		e.bumpVersion(key)
+	}
+	e.logger.Debug("SAdd members",
+		zap.String("key", key),
+		zap.Int("added", added),
+	)
+	return added, nil
+}
+
+// sremLocked удаляет элементы; множество без элементов удаляется целиком
+func (e *InMemoryEngine) sremLocked(key string, members []string) (int, error) {
+	set, err := e.setValueLocked(key)
+	if err != nil || set == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, m := range members {
+		if _, ok := set[m]; ok {
+			delete(set, m)
+			e.used.Add(-setMemberSize(m))
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	if len(set) == 0 {
+		e.deleteKey(key)
+	} else {
+		e.bumpVersion(key)
+	}
+	e.logger.Debug("SRem members",
+		zap.String("key", key),
+		zap.Int("removed", removed),
+	)
+	return removed, nil
+}
+
+func (e *InMemoryEngine) smembersLocked(key string) ([]string, error) {
+	set, err := e.setValueLocked(key)
+	if err != nil || set == nil {
+		return nil, err
+	}
+	return setMembers(set), nil
+}
+
+func (e *InMemoryEngine) sismemberLocked(key, member string) (bool, error) {
+	set, err := e.setValueLocked(key)
+	if err != nil {
+		return false, err
+	}
+	_, ok := set[member]
+	return ok, nil
+}
+
+// setMembers – элементы множества по возрастанию
+func setMembers(set map[string]struct{}) []string {
+	members := make([]string, 0, len(set))
+	for m := range set {
+		members = append(members, m)
+	}
+	sort.Strings(members)
+	return members
+}
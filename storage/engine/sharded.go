@@ -0,0 +1,349 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+	"imkvdb/storage"
+)
+
+// DefaultShards – число партиций ShardedEngine по умолчанию
+const DefaultShards = 32
+
+// ShardedEngine – in-memory движок из N независимых партиций (InMemoryEngine),
+// у каждой своя блокировка. Ключ попадает в партицию по хэшу, поэтому операции
+// над разными ключами почти не конкурируют за один мьютекс.
+//
+// Версии ключей монотонны внутри партиции; ключ всегда живёт в одной партиции,
+// так что для WATCH/CAS этого достаточно.
+type ShardedEngine struct {
+	shards []*InMemoryEngine
+	logger *zap.Logger
+}
+
+var _ Engine = (*ShardedEngine)(nil)
+
+// ShardedOptions – опции движка "sharded" из engine.options
+type ShardedOptions struct {
+	Shards int `yaml:"shards"` // число партиций; 0 – DefaultShards
+	// SweepInterval – период фоновой очистки просроченных ключей; 0 – не запускать
+	SweepInterval time.Duration `yaml:"sweep_interval"`
+}
+
+func init() {
+	Register("sharded", func(options *yaml.Node, logger *zap.Logger) (Engine, error) {
+		opts := ShardedOptions{Shards: DefaultShards, SweepInterval: DefaultSweepInterval}
+		if err := DecodeOptions(options, &opts); err != nil {
+			return nil, err
+		}
+		if opts.Shards < 0 {
+			return nil, fmt.Errorf("shards must not be negative, got %d", opts.Shards)
+		}
+		e := NewShardedEngine(opts.Shards, logger)
+		if opts.SweepInterval > 0 {
+			e.StartSweeper(opts.SweepInterval)
+		}
+		return e, nil
+	})
+}
+
+// NewShardedEngine – конструктор; shards <= 0 – DefaultShards
+func NewShardedEngine(shards int, logger *zap.Logger) *ShardedEngine {
+	if shards <= 0 {
+		shards = DefaultShards
+	}
+	e := &ShardedEngine{
+		shards: make([]*InMemoryEngine, shards),
+		logger: logger,
+	}
+	for i := range e.shards {
+		e.shards[i] = NewInMemoryEngine(logger)
+	}
+	return e
+}
+
+// shardFor – партиция ключа
+func (e *ShardedEngine) shardFor(key string) *InMemoryEngine {
+	return e.shards[keyHash(key)%uint64(len(e.shards))]
+}
+
+func (e *ShardedEngine) Set(key, value string) error { return e.shardFor(key).Set(key, value) }
+
+func (e *ShardedEngine) Get(key string) (string, bool, error) { return e.shardFor(key).Get(key) }
+
+func (e *ShardedEngine) Del(key string) bool { return e.shardFor(key).Del(key) }
+
+func (e *ShardedEngine) Expire(key string, at time.Time) bool {
+	return e.shardFor(key).Expire(key, at)
+}
+
+func (e *ShardedEngine) Persist(key string) bool { return e.shardFor(key).Persist(key) }
+
+func (e *ShardedEngine) ExpireTime(key string) (time.Time, bool) {
+	return e.shardFor(key).ExpireTime(key)
+}
+
+func (e *ShardedEngine) Version(key string) uint64 { return e.shardFor(key).Version(key) }
+
+func (e *ShardedEngine) SetVersion(key string, version uint64) {
+	e.shardFor(key).SetVersion(key, version)
+}
+
+func (e *ShardedEngine) HSet(key string, pairs []string) (int, error) {
+	return e.shardFor(key).HSet(key, pairs)
+}
+
+func (e *ShardedEngine) HGet(key, field string) (string, bool, error) {
+	return e.shardFor(key).HGet(key, field)
+}
+
+func (e *ShardedEngine) HDel(key string, fields []string) (int, error) {
+	return e.shardFor(key).HDel(key, fields)
+}
+
+func (e *ShardedEngine) HGetAll(key string) ([]string, error) { return e.shardFor(key).HGetAll(key) }
+
+func (e *ShardedEngine) HLen(key string) (int, error) { return e.shardFor(key).HLen(key) }
+
+func (e *ShardedEngine) LPush(key string, values []string) (int, error) {
+	return e.shardFor(key).LPush(key, values)
+}
+
+func (e *ShardedEngine) RPush(key string, values []string) (int, error) {
+	return e.shardFor(key).RPush(key, values)
+}
+
+func (e *ShardedEngine) LPop(key string) (string, bool, error) { return e.shardFor(key).LPop(key) }
+
+func (e *ShardedEngine) RPop(key string) (string, bool, error) { return e.shardFor(key).RPop(key) }
+
+func (e *ShardedEngine) LRange(key string, start, stop int) ([]string, error) {
+	return e.shardFor(key).LRange(key, start, stop)
+}
+
+func (e *ShardedEngine) LLen(key string) (int, error) { return e.shardFor(key).LLen(key) }
+
+func (e *ShardedEngine) ZAdd(key string, members []storage.ScoredMember) (int, error) {
+	return e.shardFor(key).ZAdd(key, members)
+}
+
+func (e *ShardedEngine) ZRem(key string, members []string) (int, error) {
+	return e.shardFor(key).ZRem(key, members)
+}
+
+func (e *ShardedEngine) ZRange(key string, start, stop int) ([]storage.ScoredMember, error) {
+	return e.shardFor(key).ZRange(key, start, stop)
+}
+
+func (e *ShardedEngine) ZRangeByScore(key string, min, max storage.ScoreBound, offset, count int) ([]storage.ScoredMember, error) {
+	return e.shardFor(key).ZRangeByScore(key, min, max, offset, count)
+}
+
+func (e *ShardedEngine) ZRank(key, member string) (int, bool, error) {
+	return e.shardFor(key).ZRank(key, member)
+}
+
+func (e *ShardedEngine) SAdd(key string, members []string) (int, error) {
+	return e.shardFor(key).SAdd(key, members)
+}
+
+func (e *ShardedEngine) SRem(key string, members []string) (int, error) {
+	return e.shardFor(key).SRem(key, members)
+}
+
+func (e *ShardedEngine) SMembers(key string) ([]string, error) { return e.shardFor(key).SMembers(key) }
+
+func (e *ShardedEngine) SIsMember(key, member string) (bool, error) {
+	return e.shardFor(key).SIsMember(key, member)
+}
+
+// Scan обходит все партиции сразу: курсор – общий для них хэш ключа (см. scan.go),
+// поэтому из каждой партиции берутся её count первых кандидатов, а порция – лучшие из них
+func (e *ShardedEngine) Scan(cursor uint64, count int) (uint64, []string) {
+	var items []scanItem
+	for _, shard := range e.shards {
+		shard.mu.RLock()
+		items = append(items, shard.scanFromLocked(cursor, count)...)
+		shard.mu.RUnlock()
+	}
+	return scanPage(sortScanItems(items), count)
+}
+
+// Len – сумма по партициям
+func (e *ShardedEngine) Len() int {
+	n := 0
+	for _, shard := range e.shards {
+		n += shard.Len()
+	}
+	return n
+}
+
+// Range не поддерживается: ключи разложены по партициям по хэшу
+func (e *ShardedEngine) Range(start, end string, limit int, reverse bool) ([]string, error) {
+	return nil, storage.ErrUnordered
+}
+
+// Atomic захватывает все партиции (всегда в одном порядке, чтобы не было взаимоблокировок),
+// поэтому транзакция видна другим клиентам только целиком
+func (e *ShardedEngine) Atomic(fn func(tx storage.Tx) error) error {
+	for _, shard := range e.shards {
+		shard.mu.Lock()
+	}
+	defer func() {
+		for _, shard := range e.shards {
+			shard.mu.Unlock()
+		}
+	}()
+	return fn(shardedView{e})
+}
+
+// Dump – копия всех живых ключей, партиция за партицией
+func (e *ShardedEngine) Dump() []storage.Entry {
+	var entries []storage.Entry
+	for _, shard := range e.shards {
+		entries = append(entries, shard.Dump()...)
+	}
+	return entries
+}
+
+// Restore раскладывает записи снимка по партициям
+func (e *ShardedEngine) Restore(entries []storage.Entry) {
+	parts := make(map[*InMemoryEngine][]storage.Entry, len(e.shards))
+	for _, entry := range entries {
+		shard := e.shardFor(entry.Key)
+		parts[shard] = append(parts[shard], entry)
+	}
+	for shard, part := range parts {
+		shard.Restore(part)
+	}
+}
+
+// StartSweeper запускает фоновую очистку просроченных ключей в каждой партиции
+func (e *ShardedEngine) StartSweeper(interval time.Duration) {
+	for _, shard := range e.shards {
+		shard.StartSweeper(interval)
+	}
+}
+
+// Close останавливает фоновую очистку всех партиций
+func (e *ShardedEngine) Close() error {
+	for _, shard := range e.shards {
+		_ = shard.Close()
+	}
+	return nil
+}
+
+// shardedView – операции внутри Atomic, когда все партиции уже захвачены
+type shardedView struct {
+	e *ShardedEngine
+}
+
+func (v shardedView) view(key string) lockedView { return lockedView{v.e.shardFor(key)} }
+
+func (v shardedView) Scan(cursor uint64, count int) (uint64, []string) {
+	var items []scanItem
+	for _, shard := range v.e.shards {
+		items = append(items, shard.scanFromLocked(cursor, count)...)
+	}
+	return scanPage(sortScanItems(items), count)
+}
+
+func (v shardedView) Range(start, end string, limit int, reverse bool) ([]string, error) {
+	return nil, storage.ErrUnordered
+}
+
+func (v shardedView) Len() int {
+	n := 0
+	for _, shard := range v.e.shards {
+		n += shard.lenLocked()
+	}
+	return n
+}
+
+func (v shardedView) Set(key, value string) error { return v.view(key).Set(key, value) }
+
+func (v shardedView) Get(key string) (string, bool, error) { return v.view(key).Get(key) }
+
+func (v shardedView) Del(key string) bool { return v.view(key).Del(key) }
+
+func (v shardedView) Expire(key string, at time.Time) bool { return v.view(key).Expire(key, at) }
+
+func (v shardedView) Persist(key string) bool { return v.view(key).Persist(key) }
+
+func (v shardedView) ExpireTime(key string) (time.Time, bool) { return v.view(key).ExpireTime(key) }
+
+func (v shardedView) Version(key string) uint64 { return v.view(key).Version(key) }
+
+func (v shardedView) SetVersion(key string, version uint64) {
+	v.view(key).SetVersion(key, version)
+}
+
+func (v shardedView) HSet(key string, pairs []string) (int, error) {
+	return v.view(key).HSet(key, pairs)
+}
+
+func (v shardedView) HGet(key, field string) (string, bool, error) {
+	return v.view(key).HGet(key, field)
+}
+
+func (v shardedView) HDel(key string, fields []string) (int, error) {
+	return v.view(key).HDel(key, fields)
+}
+
+func (v shardedView) HGetAll(key string) ([]string, error) { return v.view(key).HGetAll(key) }
+
+func (v shardedView) HLen(key string) (int, error) { return v.view(key).HLen(key) }
+
+func (v shardedView) LPush(key string, values []string) (int, error) {
+	return v.view(key).LPush(key, values)
+}
+
+func (v shardedView) RPush(key string, values []string) (int, error) {
+	return v.view(key).RPush(key, values)
+}
+
+func (v shardedView) LPop(key string) (string, bool, error) { return v.view(key).LPop(key) }
+
+func (v shardedView) RPop(key string) (string, bool, error) { return v.view(key).RPop(key) }
+
+func (v shardedView) LRange(key string, start, stop int) ([]string, error) {
+	return v.view(key).LRange(key, start, stop)
+}
+
+func (v shardedView) LLen(key string) (int, error) { return v.view(key).LLen(key) }
+
+func (v shardedView) ZAdd(key string, members []storage.ScoredMember) (int, error) {
+	return v.view(key).ZAdd(key, members)
+}
+
+func (v shardedView) ZRem(key string, members []string) (int, error) {
+	return v.view(key).ZRem(key, members)
+}
+
+func (v shardedView) ZRange(key string, start, stop int) ([]storage.ScoredMember, error) {
+	return v.view(key).ZRange(key, start, stop)
+}
+
+func (v shardedView) ZRangeByScore(key string, min, max storage.ScoreBound, offset, count int) ([]storage.ScoredMember, error) {
+	return v.view(key).ZRangeByScore(key, min, max, offset, count)
+}
+
+func (v shardedView) ZRank(key, member string) (int, bool, error) {
+	return v.view(key).ZRank(key, member)
+}
+
+func (v shardedView) SAdd(key string, members []string) (int, error) {
+	return v.view(key).SAdd(key, members)
+}
+
+func (v shardedView) SRem(key string, members []string) (int, error) {
+	return v.view(key).SRem(key, members)
+}
+
+func (v shardedView) SMembers(key string) ([]string, error) { return v.view(key).SMembers(key) }
+
+func (v shardedView) SIsMember(key, member string) (bool, error) {
+	return v.view(key).SIsMember(key, member)
+}
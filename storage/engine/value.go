@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"sort"
+	"strconv"
+
+	"imkvdb/storage"
+)
+
+// value – значение ключа: строка или коллекция. Заполнено поле, соответствующее typ.
+// Коллекции изменяются на месте под блокировкой движка на запись.
+type value struct {
+	typ  storage.Type
+	str  string
+	hash map[string]string
+	list *list
+	zset *zset
+	set  map[string]struct{}
+
+	// usage – обращения к ключу для вытеснения; общий для всех копий value ключа (см. putKey)
+	usage *usage
+}
+
+func stringValue(s string) value { return value{typ: storage.TypeString, str: s} }
+
+// entry – копия значения в виде записи снимка (без ключа, TTL и версии)
+func (v value) entry() storage.Entry {
+	switch v.typ {
+	case storage.TypeHash:
+		return storage.Entry{Type: v.typ, Items: hashPairs(v.hash)}
+	case storage.TypeList:
+		return storage.Entry{Type: v.typ, Items: v.list.slice(0, v.list.len())}
+	case storage.TypeZSet:
+		return storage.Entry{Type: v.typ, Items: zsetItems(v.zset)}
+	case storage.TypeSet:
+		return storage.Entry{Type: v.typ, Items: setMembers(v.set)}
+	default:
+		return storage.Entry{Type: v.typ, Value: v.str}
+	}
+}
+
+// valueFromEntry – значение из записи снимка
+func valueFromEntry(e storage.Entry) value {
+	switch e.Type {
+	case storage.TypeHash:
+		h := make(map[string]string, len(e.Items)/2)
+		for i := 0; i+1 < len(e.Items); i += 2 {
+			h[e.Items[i]] = e.Items[i+1]
+		}
+		return value{typ: e.Type, hash: h}
+	case storage.TypeList:
+		l := &list{}
+		for _, item := range e.Items {
+			l.pushBack(item)
+		}
+		return value{typ: e.Type, list: l}
+	case storage.TypeZSet:
+		z := newZSet()
+		for i := 0; i+1 < len(e.Items); i += 2 {
+			// Счёт записан через FormatFloat и разбирается без потерь
+			score, _ := strconv.ParseFloat(e.Items[i], 64)
+			z.add(e.Items[i+1], score)
+		}
+		return value{typ: e.Type, zset: z}
+	case storage.TypeSet:
+		set := make(map[string]struct{}, len(e.Items))
+		for _, member := range e.Items {
+			set[member] = struct{}{}
+		}
+		return value{typ: e.Type, set: set}
+	default:
+		return stringValue(e.Value)
+	}
+}
+
+// hashPairs – пары поле/значение хеша, отсортированные по полю
+func hashPairs(h map[string]string) []string {
+	fields := make([]string, 0, len(h))
+	for f := range h {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	pairs := make([]string, 0, 2*len(fields))
+	for _, f := range fields {
+		pairs = append(pairs, f, h[f])
+	}
+	return pairs
+}
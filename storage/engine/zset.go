@@ -0,0 +1,388 @@
+package engine
+
+import (
+	"math/rand/v2"
+	"strconv"
+
+	"go.uber.org/zap"
+	"imkvdb/storage"
+)
+
+// zset – сортированное множество: map элемент -> счёт для поиска по элементу
+// и skip list, упорядоченный по (счёт, элемент), для диапазонов и рангов
+type zset struct {
+	scores map[string]float64
+	sl     skiplist
+}
+
+func newZSet() *zset {
+	return &zset{scores: make(map[string]float64), sl: newSkiplist()}
+}
+
+// add добавляет элемент или меняет его счёт; true – элемент новый
+func (z *zset) add(member string, score float64) bool {
+	old, ok := z.scores[member]
+	if ok {
+		if old == score {
+			return false
+		}
+		z.sl.delete(old, member)
+	}
+	z.scores[member] = score
+	z.sl.insert(score, member)
+	return !ok
+}
+
+func (z *zset) remove(member string) bool {
+	score, ok := z.scores[member]
+	if !ok {
+		return false
+	}
+	delete(z.scores, member)
+	z.sl.delete(score, member)
+	return true
+}
+
+func (z *zset) len() int { return len(z.scores) }
+
+// Skip list с длинами переходов (span), как zskiplist в Redis: ранг элемента
+// и поиск по рангу – за O(log n)
+const (
+	skiplistMaxLevel = 32
+	skiplistP        = 0.25
+)
+
+type skiplistNode struct {
+	member string
+	score  float64
+	level  []skiplistLevel
+}
+
+type skiplistLevel struct {
+	forward *skiplistNode
+	span    int // сколько элементов перепрыгивает forward
+}
+
+type skiplist struct {
+	header *skiplistNode
+	length int
+	level  int
+}
+
+func newSkiplist() skiplist {
+	return skiplist{header: &skiplistNode{level: make([]skiplistLevel, skiplistMaxLevel)}, level: 1}
+}
+
+// Порядок элементов: по счёту, при равных счетах – по элементу.
+// before – узел стоит раньше пары (score, member), after – позже.
+func before(node *skiplistNode, score float64, member string) bool {
+	return node.score < score || (node.score == score && node.member < member)
+}
+
+func after(node *skiplistNode, score float64, member string) bool {
+	return node.score > score || (node.score == score && node.member > member)
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && rand.Float64() < skiplistP {
+		level++
+	}
+	return level
+}
+
+func (sl *skiplist) insert(score float64, member string) {
+	var update [skiplistMaxLevel]*skiplistNode
+	var rank [skiplistMaxLevel]int
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && before(x.level[i].forward, score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = sl.header
+			update[i].level[i].span = sl.length
+		}
+		sl.level = level
+	}
+	node := &skiplistNode{member: member, score: score, level: make([]skiplistLevel, level)}
+	for i := 0; i < level; i++ {
+		node.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = node
+		node.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < sl.level; i++ {
+		update[i].level[i].span++
+	}
+	sl.length++
+}
+
+func (sl *skiplist) delete(score float64, member string) {
+	var update [skiplistMaxLevel]*skiplistNode
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && before(x.level[i].forward, score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return
+	}
+	for i := 0; i < sl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	for sl.level > 1 && sl.header.level[sl.level-1].forward == nil {
+		sl.level--
+	}
+	sl.length--
+}
+
+// rank – позиция элемента с 0
+func (sl *skiplist) rank(score float64, member string) int {
+	rank := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !after(x.level[i].forward, score, member) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != sl.header && x.member == member {
+			return rank - 1
+		}
+	}
+	return -1
+}
+
+// countBefore – сколько узлов стоит раньше пары (score, member)
+func (sl *skiplist) countBefore(score float64, member string) int {
+	rank := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && before(x.level[i].forward, score, member) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+	}
+	return rank
+}
+
+// byRank – узел на позиции rank (с 0)
+func (sl *skiplist) byRank(rank int) *skiplistNode {
+	traversed := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank+1 {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank+1 {
+			return x
+		}
+	}
+	return nil
+}
+
+// firstFrom – первый узел со счётом не меньше min (больше, если min исключён)
+func (sl *skiplist) firstFrom(min storage.ScoreBound) *skiplistNode {
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && belowMin(x.level[i].forward.score, min) {
+			x = x.level[i].forward
+		}
+	}
+	return x.level[0].forward
+}
+
+func belowMin(score float64, min storage.ScoreBound) bool {
+	return score < min.Value || (min.Exclusive && score == min.Value)
+}
+
+func aboveMax(score float64, max storage.ScoreBound) bool {
+	return score > max.Value || (max.Exclusive && score == max.Value)
+}
+
+// Операции над сортированными множествами (storage.ZSetTx) – по той же схеме, что и хеши
+
+func (e *InMemoryEngine) ZAdd(key string, members []storage.ScoredMember) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.zaddLocked(key, members)
+}
+
+func (e *InMemoryEngine) ZRem(key string, members []string) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.zremLocked(key, members)
+}
+
+func (e *InMemoryEngine) ZRange(key string, start, stop int) ([]storage.ScoredMember, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.zrangeLocked(key, start, stop)
+}
+
+func (e *InMemoryEngine) ZRangeByScore(key string, min, max storage.ScoreBound, offset, count int) ([]storage.ScoredMember, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.zrangeByScoreLocked(key, min, max, offset, count)
+}
+
+func (e *InMemoryEngine) ZRank(key, member string) (int, bool, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.zrankLocked(key, member)
+}
+
+func (v lockedView) ZAdd(key string, members []storage.ScoredMember) (int, error) {
+	return v.e.zaddLocked(key, members)
+}
+
+func (v lockedView) ZRem(key string, members []string) (int, error) {
+	return v.e.zremLocked(key, members)
+}
+
+func (v lockedView) ZRange(key string, start, stop int) ([]storage.ScoredMember, error) {
+	return v.e.zrangeLocked(key, start, stop)
+}
+
+func (v lockedView) ZRangeByScore(key string, min, max storage.ScoreBound, offset, count int) ([]storage.ScoredMember, error) {
+	return v.e.zrangeByScoreLocked(key, min, max, offset, count)
+}
+
+func (v lockedView) ZRank(key, member string) (int, bool, error) { return v.e.zrankLocked(key, member) }
+
+// zsetLocked – сортированное множество ключа; nil без ошибки – ключа нет. Вызывается под блокировкой.
+func (e *InMemoryEngine) zsetLocked(key string) (*zset, error) {
+	val, ok := e.lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	if val.typ != storage.TypeZSet {
+		return nil, storage.ErrWrongType
+	}
+	return val.zset, nil
+}
+
+func (e *InMemoryEngine) zaddLocked(key string, members []storage.ScoredMember) (int, error) {
+	if !e.existsLocked(key) {
+		e.putKey(key, value{typ: storage.TypeZSet, zset: newZSet()})
+	}
+	z, err := e.zsetLocked(key)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, m := range members {
+		if z.add(m.Member, m.Score) {
+			e.used.Add(zsetMemberSize(m.Member))
+			added++
+		}
+	}
+	e.bumpVersion(key)
+	e.logger.Debug("ZAdd members",
+		zap.String("key", key),
+		zap.Int("members", len(members)),
+	)
+	return added, nil
+}
+
+// zremLocked удаляет элементы; пустое множество удаляется целиком
+func (e *InMemoryEngine) zremLocked(key string, members []string) (int, error) {
+	z, err := e.zsetLocked(key)
+	if err != nil || z == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, m := range members {
+		if z.remove(m) {
+			e.used.Add(-zsetMemberSize(m))
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	if z.len() == 0 {
+		e.deleteKey(key)
+	} else {
+		e.bumpVersion(key)
+	}
+	e.logger.Debug("ZRem members",
+		zap.String("key", key),
+		zap.Int("removed", removed),
+	)
+	return removed, nil
+}
+
+func (e *InMemoryEngine) zrangeLocked(key string, start, stop int) ([]storage.ScoredMember, error) {
+	z, err := e.zsetLocked(key)
+	if err != nil || z == nil {
+		return nil, err
+	}
+	from, to, ok := rangeBounds(z.len(), start, stop)
+	if !ok {
+		return nil, nil
+	}
+	res := make([]storage.ScoredMember, 0, to-from)
+	for x := z.sl.byRank(from); x != nil && len(res) < to-from; x = x.level[0].forward {
+		res = append(res, storage.ScoredMember{Member: x.member, Score: x.score})
+	}
+	return res, nil
+}
+
+// zrangeByScoreLocked – элементы со счётом в [min, max]; offset пропускается, count < 0 – без ограничения
+func (e *InMemoryEngine) zrangeByScoreLocked(key string, min, max storage.ScoreBound, offset, count int) ([]storage.ScoredMember, error) {
+	z, err := e.zsetLocked(key)
+	if err != nil || z == nil {
+		return nil, err
+	}
+	var res []storage.ScoredMember
+	x := z.sl.firstFrom(min)
+	for ; x != nil && offset > 0 && !aboveMax(x.score, max); x = x.level[0].forward {
+		offset--
+	}
+	for ; x != nil && count != 0 && !aboveMax(x.score, max); x = x.level[0].forward {
+		res = append(res, storage.ScoredMember{Member: x.member, Score: x.score})
+		count--
+	}
+	return res, nil
+}
+
+func (e *InMemoryEngine) zrankLocked(key, member string) (int, bool, error) {
+	z, err := e.zsetLocked(key)
+	if err != nil || z == nil {
+		return 0, false, err
+	}
+	score, ok := z.scores[member]
+	if !ok {
+		return 0, false, nil
+	}
+	return z.sl.rank(score, member), true, nil
+}
+
+// zsetItems – содержимое для снимка: пары счёт/элемент по возрастанию
+func zsetItems(z *zset) []string {
+	items := make([]string, 0, 2*z.len())
+	for x := z.sl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		items = append(items, strconv.FormatFloat(x.score, 'g', -1, 64), x.member)
+	}
+	return items
+}
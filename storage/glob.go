@@ -0,0 +1,84 @@
+package storage
+
+// MatchPattern – соответствует ли s glob-шаблону в стиле Redis:
+//
+//	user:*      – * – любая последовательность байт, в том числе пустая
+//	user:?      – ? – ровно один байт
+//	user:[abc]  – один из перечисленных; [^abc] – любой, кроме них; [a-z] – диапазон
+//	user:\*     – \x – сам символ x (экранирование *, ?, [ и \)
+//
+// Сравнение побайтовое. Незакрытая [ сравнивается как обычный символ.
+func MatchPattern(pattern, s string) bool {
+	p, i := 0, 0
+	// Позиции последней звёздочки: при несовпадении она поглощает ещё один байт
+	starP, starI := -1, 0
+	for i < len(s) {
+		if p < len(pattern) {
+			if pattern[p] == '*' {
+				starP, starI = p, i
+				p++
+				continue
+			}
+			if width, ok := matchByte(pattern[p:], s[i]); ok {
+				p += width
+				i++
+				continue
+			}
+		}
+		if starP < 0 {
+			return false
+		}
+		starI++
+		p, i = starP+1, starI
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchByte сравнивает байт c с первым элементом шаблона (не *); width – длина элемента
+func matchByte(pattern string, c byte) (width int, ok bool) {
+	switch pattern[0] {
+	case '?':
+		return 1, true
+	case '\\':
+		if len(pattern) > 1 {
+			return 2, pattern[1] == c
+		}
+		return 1, c == '\\'
+	case '[':
+		if width, ok, closed := matchClass(pattern, c); closed {
+			return width, ok
+		}
+	}
+	return 1, pattern[0] == c
+}
+
+// matchClass разбирает класс [...] в начале шаблона; closed=false – класс не закрыт
+func matchClass(pattern string, c byte) (width int, ok, closed bool) {
+	i := 1
+	negate := i < len(pattern) && pattern[i] == '^'
+	if negate {
+		i++
+	}
+	for ; i < len(pattern); i++ {
+		switch {
+		case pattern[i] == ']':
+			return i + 1, ok != negate, true
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i++
+			ok = ok || pattern[i] == c
+		case i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']':
+			lo, hi := pattern[i], pattern[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			ok = ok || (lo <= c && c <= hi)
+			i += 2
+		default:
+			ok = ok || pattern[i] == c
+		}
+	}
+	return 0, false, false
+}
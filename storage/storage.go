@@ -1,9 +1,174 @@
 package storage
 
+import (
+	"errors"
+	"time"
+)
+
 // Storage – это верхнеуровневый интерфейс для работы с ключ-значение хранилищем.
 // В реальном приложении он мог бы содержать больше методов (Init, Close, Backup и т.д.).
 type Storage interface {
+	Tx
+
+	// Dump возвращает копию всех живых ключей (для снимка)
+	Dump() []Entry
+	// Atomic выполняет fn под одной блокировкой хранилища:
+	// другие клиенты не видят промежуточного состояния между операциями tx
+	Atomic(fn func(tx Tx) error) error
+}
+
+// Tx – операции над данными. Их реализует и само хранилище, и представление внутри Atomic.
+// Операции над значением конкретного типа возвращают ErrWrongType, если ключ хранит другой тип.
+type Tx interface {
+	// Set записывает строку, заменяя значение любого типа
 	Set(key, value string) error
-	Get(key string) (string, bool)
+	Get(key string) (string, bool, error)
 	Del(key string) bool
+
+	// Expire задаёт абсолютное время истечения ключа
+	Expire(key string, at time.Time) bool
+	// Persist снимает TTL с ключа
+	Persist(key string) bool
+	// ExpireTime возвращает время истечения (нулевое – без TTL); false – ключа нет
+	ExpireTime(key string) (time.Time, bool)
+
+	// Version – версия ключа: меняется при каждом изменении, 0 – ключа нет
+	Version(key string) uint64
+	// SetVersion выставляет версию существующему ключу (при реплее WAL)
+	SetVersion(key string, version uint64)
+
+	// Scan – следующая порция обхода ключей: не больше count живых ключей (count < 0 – все),
+	// начиная с курсора cursor (0 – начало). next=0 – обход закончен. Ключи, которые существуют
+	// всё время обхода, возвращаются ровно один раз, даже если другие ключи добавляются и удаляются.
+	Scan(cursor uint64, count int) (next uint64, keys []string)
+	// Len – число живых ключей
+	Len() int
+	// Range – ключи из диапазона [start, end) по возрастанию (reverse – по убыванию), не больше
+	// limit (limit < 0 – без ограничения); пустой end – без верхней границы.
+	// Только для упорядоченных движков, остальные возвращают ErrUnordered.
+	Range(start, end string, limit int, reverse bool) ([]string, error)
+
+	HashTx
+	ListTx
+	ZSetTx
+	SetTx
+}
+
+// HashTx – операции над хешем (поле -> значение). Хеш создаётся первой записью поля
+// и удаляется вместе с последним полем; TTL ключа при изменении полей сохраняется.
+type HashTx interface {
+	// HSet записывает пары поле/значение (pairs: f1, v1, f2, v2, ...); возвращает число новых полей
+	HSet(key string, pairs []string) (int, error)
+	HGet(key, field string) (string, bool, error)
+	// HDel удаляет поля; возвращает число удалённых
+	HDel(key string, fields []string) (int, error)
+	// HGetAll возвращает пары поле/значение, отсортированные по полю
+	HGetAll(key string) ([]string, error)
+	HLen(key string) (int, error)
+}
+
+// ListTx – операции над списком. Список создаётся первой вставкой
+// и удаляется, когда из него забран последний элемент.
+type ListTx interface {
+	// LPush вставляет значения в начало по одному (LPUSH k a b – список b, a); возвращает длину
+	LPush(key string, values []string) (int, error)
+	// RPush дописывает значения в конец; возвращает длину
+	RPush(key string, values []string) (int, error)
+	LPop(key string) (string, bool, error)
+	RPop(key string) (string, bool, error)
+	// LRange – элементы с start по stop включительно; отрицательные индексы считаются с конца
+	LRange(key string, start, stop int) ([]string, error)
+	LLen(key string) (int, error)
+}
+
+// ZSetTx – операции над сортированным множеством: элементы упорядочены по счёту,
+// при равных счетах – по самому элементу. Пустое множество удаляется.
+type ZSetTx interface {
+	// ZAdd добавляет элементы или обновляет их счёт; возвращает число новых элементов
+	ZAdd(key string, members []ScoredMember) (int, error)
+	// ZRem удаляет элементы; возвращает число удалённых
+	ZRem(key string, members []string) (int, error)
+	// ZRange – элементы с ранга start по stop включительно; отрицательные индексы – с конца
+	ZRange(key string, start, stop int) ([]ScoredMember, error)
+	// ZRangeByScore – элементы со счётом между min и max: offset первых пропускается,
+	// возвращается не больше count (count < 0 – без ограничения)
+	ZRangeByScore(key string, min, max ScoreBound, offset, count int) ([]ScoredMember, error)
+	// ZRank – ранг элемента (с 0); false – элемента нет
+	ZRank(key, member string) (int, bool, error)
+}
+
+// SetTx – операции над множеством строк. Множество создаётся первым добавлением
+// и удаляется вместе с последним элементом.
+type SetTx interface {
+	// SAdd добавляет элементы; возвращает число новых
+	SAdd(key string, members []string) (int, error)
+	// SRem удаляет элементы; возвращает число удалённых
+	SRem(key string, members []string) (int, error)
+	// SMembers возвращает элементы, отсортированные по возрастанию
+	SMembers(key string) ([]string, error)
+	SIsMember(key, member string) (bool, error)
+}
+
+// ScoredMember – элемент сортированного множества со счётом
+type ScoredMember struct {
+	Member string
+	Score  float64
+}
+
+// ScoreBound – граница диапазона счетов; Exclusive – сама граница не входит
+type ScoreBound struct {
+	Value     float64
+	Exclusive bool
+}
+
+// Type – тип значения ключа
+type Type uint8
+
+const (
+	TypeString Type = iota
+	TypeHash
+	TypeList
+	TypeZSet
+	TypeSet
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeString:
+		return "string"
+	case TypeHash:
+		return "hash"
+	case TypeList:
+		return "list"
+	case TypeZSet:
+		return "zset"
+	case TypeSet:
+		return "set"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrWrongType – операция не подходит к типу значения ключа
+var ErrWrongType = errors.New("Operation against a key holding the wrong kind of value")
+
+// ErrOOM – память данных достигла memory.max_memory, а вытеснить ключи нельзя
+// (политика noeviction или подходящих ключей нет)
+var ErrOOM = errors.New("command not allowed when used memory > 'max_memory'")
+
+// ErrUnordered – движок не хранит ключи упорядоченно и не поддерживает Range
+var ErrUnordered = errors.New("range queries require an ordered engine (engine.type: ordered)")
+
+// Entry – ключ со значением и временем истечения; единица снимка (snapshot) данных
+type Entry struct {
+	Key  string
+	Type Type
+	// Value – значение строки (TypeString)
+	Value string
+	// Items – содержимое коллекции: для хеша – пары поле/значение, для списка – элементы по порядку,
+	// для сортированного множества – пары счёт/элемент (счёт – strconv.FormatFloat(s, 'g', -1, 64)),
+	// для множества – элементы по возрастанию
+	Items    []string
+	ExpireAt time.Time // нулевое время – без TTL
+	Version  uint64
 }
@@ -0,0 +1,78 @@
+package tcpserver
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// ConnSet – открытые соединения сервера; нужен, чтобы при остановке разбудить
+// обработчики, ждущие следующую команду, а по истечении срока – закрыть соединения.
+// Заодно ведёт метрики соединений с меткой protocol=Protocol.
+type ConnSet struct {
+	Protocol string
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// Add регистрирует соединение
+func (cs *ConnSet) Add(conn net.Conn) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.conns == nil {
+		cs.conns = make(map[net.Conn]struct{})
+	}
+	cs.conns[conn] = struct{}{}
+	connectionsActive.WithLabelValues(cs.Protocol).Inc()
+}
+
+// Remove снимает соединение с учёта (обработчик завершился)
+func (cs *ConnSet) Remove(conn net.Conn) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if _, ok := cs.conns[conn]; ok {
+		delete(cs.conns, conn)
+		connectionsActive.WithLabelValues(cs.Protocol).Dec()
+	}
+}
+
+// Reject учитывает соединение, отклонённое из-за лимита max_connections
+func (cs *ConnSet) Reject() {
+	connectionsRejected.WithLabelValues(cs.Protocol).Inc()
+}
+
+// each вызывает fn для каждого открытого соединения
+func (cs *ConnSet) each(fn func(conn net.Conn)) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for conn := range cs.conns {
+		fn(conn)
+	}
+}
+
+// Drain – плавная остановка обработчиков (прием новых соединений уже прекращён, quit закрыт).
+//
+// Дедлайн чтения в прошлом прерывает ожидание следующей команды: обработчик доделывает
+// уже прочитанные команды, отправляет ответы и выходит. Если обработчики не успели до
+// отмены ctx, соединения закрываются принудительно и возвращается ctx.Err().
+// В любом случае Drain возвращается только после завершения всех обработчиков (wg).
+func Drain(ctx context.Context, wg *sync.WaitGroup, conns *ConnSet) error {
+	conns.each(func(conn net.Conn) { _ = conn.SetReadDeadline(time.Now()) })
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		conns.each(func(conn net.Conn) { _ = conn.Close() })
+		<-done
+		return ctx.Err()
+	}
+}
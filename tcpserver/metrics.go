@@ -0,0 +1,10 @@
+package tcpserver
+
+import "imkvdb/metrics"
+
+var (
+	connectionsActive = metrics.NewGaugeVec("imkvdb_connections_active",
+		"Open client connections", "protocol")
+	connectionsRejected = metrics.NewCounterVec("imkvdb_connections_rejected_total",
+		"Client connections rejected because network.max_connections was reached", "protocol")
+)
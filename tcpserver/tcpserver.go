@@ -2,6 +2,7 @@ package tcpserver
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -10,8 +11,10 @@ import (
 	"time"
 
 	"go.uber.org/zap"
+	"imkvdb/acl"
 	"imkvdb/compute" // Или относительные пути, если так требуется
 	"imkvdb/config"
+	"imkvdb/tlsutil"
 )
 
 type TCPServer struct {
@@ -21,7 +24,10 @@ type TCPServer struct {
 	listener  net.Listener
 	quitCh    chan struct{}
 	wg        sync.WaitGroup
+	conns     ConnSet
 	connLimit chan struct{}
+	// acl – пользователи и права; nil – аутентификация выключена
+	acl *acl.ACL
 }
 
 // NewTCPServer конструктор
@@ -31,19 +37,29 @@ func NewTCPServer(cfg config.Config, cmp compute.Compute, logger *zap.Logger) *T
 		cmp:       cmp,
 		logger:    logger,
 		quitCh:    make(chan struct{}),
+		conns:     ConnSet{Protocol: "text"},
 		connLimit: make(chan struct{}, cfg.Network.MaxConnections), // Ограничитель
 	}
 }
 
+// SetACL включает аутентификацию: соединение должно выполнить AUTH,
+// а его команды проверяются по правам пользователя. Вызывается до Start.
+func (s *TCPServer) SetACL(a *acl.ACL) {
+	s.acl = a
+}
+
 // Start — запускает слушание порта и приём подключений
 func (s *TCPServer) Start() error {
-	ln, err := net.Listen("tcp", s.cfg.Network.Address)
+	ln, err := tlsutil.Listen(s.cfg.Network.Address, s.cfg.Network.TLS, s.logger)
 	if err != nil {
 		s.logger.Error("failed to listen", zap.Error(err))
 		return err
 	}
 	s.listener = ln
-	s.logger.Info("TCP server started", zap.String("address", s.cfg.Network.Address))
+	s.logger.Info("TCP server started",
+		zap.String("address", s.cfg.Network.Address),
+		zap.Bool("tls", s.cfg.Network.TLS.Enabled()),
+	)
 
 	// Запуск goroutine, которая будет приём соединений
 	go s.acceptLoop()
@@ -58,6 +74,17 @@ func (s *TCPServer) Addr() (string, error) {
 	return s.listener.Addr().String(), nil
 }
 
+// stopping — вызван ли Shutdown; проверяется после продления дедлайна чтения,
+// чтобы не переждать idle timeout, если Drain выставил дедлайн раньше
+func (s *TCPServer) stopping() bool {
+	select {
+	case <-s.quitCh:
+		return true
+	default:
+		return false
+	}
+}
+
 // acceptLoop — бесконечный цикл ожидания новых подключений
 func (s *TCPServer) acceptLoop() {
 	defer s.logger.Info("acceptLoop stopped")
@@ -80,40 +107,70 @@ func (s *TCPServer) acceptLoop() {
 		case s.connLimit <- struct{}{}:
 			// Успех, обрабатываем
 			s.wg.Add(1)
+			s.conns.Add(conn)
 			go s.handleConnection(conn)
 		default:
 			// Нет свободного "слота" -> отклоняем соединение
 			s.logger.Warn("too many connections, rejecting client")
+			s.conns.Reject()
 			_ = conn.Close()
 		}
 	}
 }
 
-// handleConnection — обработка конкретного клиента
+// MaxPipelined – сколько ответов соединения может ждать отправки; дальше чтение команд
+// приостанавливается, пока клиент не заберёт ответы. Тот же предел у RESP-сервера.
+const MaxPipelined = 1024
+
+// handleConnection — обработка конкретного клиента.
+//
+// Команды читаются и выполняются по порядку, но ответ на запись ждёт fsync WAL уже в
+// отдельной горутине (writeReplies): клиент может слать команды, не дожидаясь ответов
+// (pipelining), их записи попадают в один батч WAL, а ответы уходят в порядке запросов.
 func (s *TCPServer) handleConnection(conn net.Conn) {
 	defer s.wg.Done()
 	defer func() {
 		<-s.connLimit // освобождаем слот
+		s.conns.Remove(conn)
 		err := conn.Close()
 		if err != nil {
 			s.logger.Error("failed to close connection", zap.Error(err))
 		}
 	}()
 
-	// Устанавливаем idle timeout, если нужно
-	if s.cfg.Network.IdleTimeout > 0 {
-		_ = conn.SetDeadline(time.Now().Add(s.cfg.Network.IdleTimeout))
-	}
-
 	// Для ограничения сообщения по размеру можно "обёртку" делать или читать посимвольно
 	maxSizeBytes, _ := config.ParseSize(s.cfg.Network.MaxMessageSize) // Обработка ошибки опущена для примера
 
 	reader := bufio.NewReader(conn)
+	// Состояние соединения (открытая транзакция MULTI/EXEC) живёт вместе с ним
+	session := s.cmp.NewSession()
+	// На реплике данные меняет только репликация
+	session.SetReadOnly(s.cfg.Replication.Role == config.RoleReplica)
+	// Пользователь, вошедший через AUTH, – состояние этого соединения
+	if s.acl != nil {
+		session.SetAccess(s.acl.NewConn(conn.RemoteAddr().String()))
+	}
+	// BLPOP держит соединение, пока не придут данные;
+	// отключение клиента или остановка сервера прерывают ожидание
+	session.SetBlockWatcher(ConnWatcher(conn, reader, s.quitCh, s.cfg.Network.IdleTimeout))
+
+	replies := make(chan compute.Pending, MaxPipelined)
+	writerDone := make(chan struct{})
+	go s.writeReplies(conn, replies, writerDone)
+	// Отправляем ответы на всё, что уже прочитано, и только потом закрываем соединение
+	defer func() {
+		close(replies)
+		<-writerDone
+	}()
 
 	for {
 		// Обновим дедлайн на каждый запрос (если хочется сбрасывать таймер)
 		if s.cfg.Network.IdleTimeout > 0 {
-			_ = conn.SetDeadline(time.Now().Add(s.cfg.Network.IdleTimeout))
+			_ = conn.SetReadDeadline(time.Now().Add(s.cfg.Network.IdleTimeout))
+		}
+		// При остановке дочитываем только то, что уже получено
+		if reader.Buffered() == 0 && s.stopping() {
+			return
 		}
 
 		// Читаем строку (до \n)
@@ -134,24 +191,58 @@ func (s *TCPServer) handleConnection(conn net.Conn) {
 			continue
 		}
 
-		// Обработка
-		result, err := s.cmp.Process(line)
-		if err != nil {
-			fmt.Fprintf(conn, "ERROR: %v\n", err)
-		} else {
-			// Отправляем ответ
-			fmt.Fprintf(conn, "%v\n", result)
+		// Команда выполняется сразу, ответ – однострочное представление Result
+		// (см. compute.Result.String) – отправит writeReplies
+		replies <- session.Start(line)
+	}
+}
+
+// writeReplies отправляет ответы в порядке команд: дожидается каждого (fsync WAL)
+// и сбрасывает буфер, когда готовых ответов больше нет. После ошибки записи ответы
+// больше не отправляются, а чтение команд прерывается.
+func (s *TCPServer) writeReplies(conn net.Conn, replies <-chan compute.Pending, done chan<- struct{}) {
+	defer close(done)
+
+	w := bufio.NewWriter(conn)
+	var writeErr error
+	for pending := range replies {
+		result := pending.Wait()
+		if writeErr != nil {
+			continue // дочитываем очередь, чтобы не блокировать читателя
+		}
+		if s.cfg.Network.IdleTimeout > 0 {
+			_ = conn.SetWriteDeadline(time.Now().Add(s.cfg.Network.IdleTimeout))
+		}
+		_, writeErr = fmt.Fprintf(w, "%s\n", result)
+		if writeErr == nil && len(replies) == 0 {
+			writeErr = w.Flush()
+		}
+		if writeErr != nil {
+			s.logger.Info("failed to write reply", zap.Error(writeErr))
+			// Дедлайн в прошлом будит читателя, и соединение закрывается
+			_ = conn.SetReadDeadline(time.Now())
 		}
 	}
 }
 
 // Stop — останавливает сервер
 func (s *TCPServer) Stop() {
+	_ = s.Shutdown(context.Background())
+}
+
+// Shutdown — плавная остановка: новые соединения не принимаются, начатые команды
+// доделываются и получают ответы (см. Drain). По отмене ctx оставшиеся соединения
+// закрываются принудительно и возвращается ctx.Err().
+func (s *TCPServer) Shutdown(ctx context.Context) error {
 	// Закрываем listener -> acceptLoop завершится
 	close(s.quitCh)
 	s.listener.Close()
 
 	// Ждём завершения всех текущих goroutine
-	s.wg.Wait()
+	err := Drain(ctx, &s.wg, &s.conns)
+	if err != nil {
+		s.logger.Warn("shutdown timed out, connections closed", zap.Error(err))
+	}
 	s.logger.Info("server stopped")
+	return err
 }
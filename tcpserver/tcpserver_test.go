@@ -2,19 +2,26 @@ package tcpserver_test
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"imkvdb/wal"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"imkvdb/acl"
 	"imkvdb/compute"
 	"imkvdb/compute/parser"
 	"imkvdb/config"
 	"imkvdb/storage"
 	"imkvdb/storage/engine"
 	"imkvdb/tcpserver"
+	"imkvdb/tlsutil"
+	"imkvdb/tlsutil/tlstest"
 
 	"go.uber.org/zap"
 )
@@ -66,7 +73,7 @@ func TestTCPServer_SimpleFlow(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to read SET response: %v", err)
 	}
-	if !strings.Contains(resp, "OK: SET") {
+	if strings.TrimSpace(resp) != "OK" {
 		t.Errorf("unexpected SET response: %v", resp)
 	}
 
@@ -78,7 +85,7 @@ func TestTCPServer_SimpleFlow(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to read GET response: %v", err)
 	}
-	if !strings.Contains(resp, "value1") {
+	if strings.TrimSpace(resp) != `"value1"` {
 		t.Errorf("unexpected GET response: %v", resp)
 	}
 
@@ -90,7 +97,7 @@ func TestTCPServer_SimpleFlow(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to read DEL response: %v", err)
 	}
-	if !strings.Contains(resp, "OK: DEL") {
+	if strings.TrimSpace(resp) != "(integer) 1" {
 		t.Errorf("unexpected DEL response: %v", resp)
 	}
 }
@@ -100,3 +107,858 @@ func getServerAddr(s *tcpserver.TCPServer) string {
 	addr, _ := s.Addr()
 	return addr
 }
+
+// TestTCPServer_MultiExec — транзакция MULTI/EXEC/DISCARD в рамках одного соединения
+func TestTCPServer_MultiExec(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+
+	cfg := config.Config{}
+	cfg.Network.Address = "127.0.0.1:0"
+	cfg.Network.MaxConnections = 5
+	cfg.Network.MaxMessageSize = "4KB"
+	cfg.Network.IdleTimeout = 2 * time.Second
+
+	st := engine.NewInMemoryEngine(logger)
+	cmp := compute.NewCompute(parser.NewParser(), st, &wal.NoOpWAL{}, logger)
+	srv := tcpserver.NewTCPServer(cfg, cmp, logger)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("failed to start TCP server: %v", err)
+	}
+	defer srv.Stop()
+
+	conn, err := net.Dial("tcp", getServerAddr(srv))
+	if err != nil {
+		t.Fatalf("failed to dial server: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	send := func(cmd string) string {
+		t.Helper()
+		if _, err := fmt.Fprintf(conn, "%s\n", cmd); err != nil {
+			t.Fatalf("failed to send %q: %v", cmd, err)
+		}
+		resp, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read response to %q: %v", cmd, err)
+		}
+		return strings.TrimSpace(resp)
+	}
+
+	steps := []struct {
+		cmd  string
+		want string
+	}{
+		{"MULTI", "OK"},
+		{"SET a 1", "QUEUED"},
+		{"SET b 2", "QUEUED"},
+		{"GET a", "QUEUED"},
+		{"EXEC", `1) OK; 2) OK; 3) "1"`},
+		{"GET b", `"2"`},
+		// DISCARD отбрасывает очередь
+		{"MULTI", "OK"},
+		{"SET a 100", "QUEUED"},
+		{"DISCARD", "OK"},
+		{"GET a", `"1"`},
+		// Ошибка разбора внутри MULTI отменяет всю транзакцию
+		{"MULTI", "OK"},
+		{"SET a 100", "QUEUED"},
+		{"SET a", "ERROR: SYNTAX SET command requires 2 arguments: key and value"},
+		{"EXEC", "ERROR: EXECABORT Transaction discarded because of previous errors"},
+		{"GET a", `"1"`},
+		{"EXEC", "ERROR: ERR EXEC without MULTI"},
+	}
+	for _, step := range steps {
+		if got := send(step.cmd); got != step.want {
+			t.Errorf("%s: got %q, want %q", step.cmd, got, step.want)
+		}
+	}
+}
+
+// TestTCPServer_WatchAndCAS — оптимистичные блокировки: WATCH отменяет EXEC, если ключ изменил другой клиент
+func TestTCPServer_WatchAndCAS(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+
+	cfg := config.Config{}
+	cfg.Network.Address = "127.0.0.1:0"
+	cfg.Network.MaxConnections = 5
+	cfg.Network.MaxMessageSize = "4KB"
+	cfg.Network.IdleTimeout = 2 * time.Second
+
+	st := engine.NewInMemoryEngine(logger)
+	cmp := compute.NewCompute(parser.NewParser(), st, &wal.NoOpWAL{}, logger)
+	srv := tcpserver.NewTCPServer(cfg, cmp, logger)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("failed to start TCP server: %v", err)
+	}
+	defer srv.Stop()
+
+	dial := func() func(cmd string) string {
+		conn, err := net.Dial("tcp", getServerAddr(srv))
+		if err != nil {
+			t.Fatalf("failed to dial server: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		reader := bufio.NewReader(conn)
+		return func(cmd string) string {
+			t.Helper()
+			if _, err := fmt.Fprintf(conn, "%s\n", cmd); err != nil {
+				t.Fatalf("failed to send %q: %v", cmd, err)
+			}
+			resp, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("failed to read response to %q: %v", cmd, err)
+			}
+			return strings.TrimSpace(resp)
+		}
+	}
+	client1, client2 := dial(), dial()
+
+	expect := func(send func(string) string, cmd, want string) {
+		t.Helper()
+		if got := send(cmd); got != want {
+			t.Errorf("%s: got %q, want %q", cmd, got, want)
+		}
+	}
+
+	// CAS
+	expect(client1, "SET stock 10", "OK")
+	expect(client1, "CAS stock 9 8", "(integer) 0")
+	expect(client1, "CAS stock 10 9", "(integer) 1")
+	expect(client1, "GET stock", `"9"`)
+	expect(client1, "CAS missing 1 2", "ERROR: NOT_FOUND key not found")
+
+	// GETVER меняется при каждом изменении
+	ver1 := client1("GETVER stock")
+	expect(client1, "SET stock 9", "OK")
+	if ver2 := client1("GETVER stock"); ver2 == ver1 {
+		t.Errorf("expected version to change after SET, got %s twice", ver1)
+	}
+	expect(client1, "GETVER missing", "(nil)")
+
+	// WATCH: ключ изменён другим клиентом -> EXEC отменяется
+	expect(client1, "WATCH stock", "OK")
+	expect(client2, "SET stock 100", "OK")
+	expect(client1, "MULTI", "OK")
+	expect(client1, "SET stock 8", "QUEUED")
+	expect(client1, "EXEC", "(nil)")
+	expect(client1, "GET stock", `"100"`)
+
+	// WATCH без конкурирующих изменений -> EXEC проходит
+	expect(client1, "WATCH stock", "OK")
+	expect(client1, "MULTI", "OK")
+	expect(client1, "SET stock 99", "QUEUED")
+	expect(client1, "EXEC", "1) OK")
+	expect(client2, "GET stock", `"99"`)
+
+	// EXEC сбрасывает WATCH: следующая транзакция не зависит от старых ключей
+	expect(client2, "SET stock 1", "OK")
+	expect(client1, "MULTI", "OK")
+	expect(client1, "SET stock 2", "QUEUED")
+	expect(client1, "EXEC", "1) OK")
+}
+
+// TestTCPServer_QuotedValues — значения с пробелами и произвольными байтами: ответ GET
+// можно подставить обратно в команду и получить то же значение
+func TestTCPServer_QuotedValues(t *testing.T) {
+	logger := zap.NewNop()
+
+	cfg := config.Config{}
+	cfg.Network.Address = "127.0.0.1:0"
+	cfg.Network.MaxConnections = 5
+	cfg.Network.MaxMessageSize = "4KB"
+	cfg.Network.IdleTimeout = 2 * time.Second
+
+	cmp := compute.NewCompute(parser.NewParser(), engine.NewInMemoryEngine(logger), &wal.NoOpWAL{}, logger)
+	srv := tcpserver.NewTCPServer(cfg, cmp, logger)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("failed to start TCP server: %v", err)
+	}
+	defer srv.Stop()
+
+	conn, err := net.Dial("tcp", getServerAddr(srv))
+	if err != nil {
+		t.Fatalf("failed to dial server: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	send := func(cmd string) string {
+		t.Helper()
+		if _, err := fmt.Fprintf(conn, "%s\n", cmd); err != nil {
+			t.Fatalf("failed to send %q: %v", cmd, err)
+		}
+		resp, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read response to %q: %v", cmd, err)
+		}
+		return strings.TrimSuffix(resp, "\n")
+	}
+
+	steps := []struct {
+		cmd  string
+		want string
+	}{
+		{`SET greeting "hello world"`, "OK"},
+		{"GET greeting", `"hello world"`},
+		{`SET json '{"a": "b c"}'`, "OK"},
+		{"GET json", `"{\"a\": \"b c\"}"`},
+		{`SET "key with space" "line1\nline2\x00\xff"`, "OK"},
+		{`GET "key with space"`, `"line1\nline2\x00\xff"`},
+		{`SET broken "no end`, "ERROR: SYNTAX unterminated quote in command"},
+	}
+	for _, step := range steps {
+		if got := send(step.cmd); got != step.want {
+			t.Errorf("%s: got %q, want %q", step.cmd, got, step.want)
+		}
+	}
+
+	// Ответ GET – готовый аргумент команды
+	quoted := send(`GET "key with space"`)
+	send("SET copy " + quoted)
+	if got := send("GET copy"); got != quoted {
+		t.Errorf("round trip: got %q, want %q", got, quoted)
+	}
+}
+
+// TestTCPServer_Hash — команды хешей и WRONGTYPE при обращении к ключу другого типа
+func TestTCPServer_Hash(t *testing.T) {
+	logger := zap.NewNop()
+
+	cfg := config.Config{}
+	cfg.Network.Address = "127.0.0.1:0"
+	cfg.Network.MaxConnections = 5
+	cfg.Network.MaxMessageSize = "4KB"
+	cfg.Network.IdleTimeout = 2 * time.Second
+
+	cmp := compute.NewCompute(parser.NewParser(), engine.NewInMemoryEngine(logger), &wal.NoOpWAL{}, logger)
+	srv := tcpserver.NewTCPServer(cfg, cmp, logger)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("failed to start TCP server: %v", err)
+	}
+	defer srv.Stop()
+
+	conn, err := net.Dial("tcp", getServerAddr(srv))
+	if err != nil {
+		t.Fatalf("failed to dial server: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	send := func(cmd string) string {
+		t.Helper()
+		if _, err := fmt.Fprintf(conn, "%s\n", cmd); err != nil {
+			t.Fatalf("failed to send %q: %v", cmd, err)
+		}
+		resp, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read response to %q: %v", cmd, err)
+		}
+		return strings.TrimSuffix(resp, "\n")
+	}
+
+	wrongType := "ERROR: WRONGTYPE Operation against a key holding the wrong kind of value"
+	steps := []struct {
+		cmd  string
+		want string
+	}{
+		{`HSET user:1 name "Ann Lee" age 30`, "(integer) 2"},
+		{"HSET user:1 age 31 city Oslo", "(integer) 1"},
+		{"HGET user:1 name", `"Ann Lee"`},
+		{"HGET user:1 missing", "(nil)"},
+		{"HLEN user:1", "(integer) 3"},
+		{"HGETALL user:1", `1) "age"; 2) "31"; 3) "city"; 4) "Oslo"; 5) "name"; 6) "Ann Lee"`},
+		{"HGETALL missing", "(empty array)"},
+		{"HSET user:1 odd", "ERROR: SYNTAX HSET command requires a key and field value pairs"},
+		{"GET user:1", wrongType},
+		{"CAS user:1 a b", wrongType},
+		{"SET str v", "OK"},
+		{"HSET str f v", wrongType},
+		{"HGETALL str", wrongType},
+		{"HDEL user:1 age city nope", "(integer) 2"},
+		{"HDEL user:1 name", "(integer) 1"},
+		{"HLEN user:1", "(integer) 0"},
+		// SET заменяет значение любого типа
+		{"HSET h f v", "(integer) 1"},
+		{"SET h plain", "OK"},
+		{"GET h", `"plain"`},
+	}
+	for _, step := range steps {
+		if got := send(step.cmd); got != step.want {
+			t.Errorf("%s: got %q, want %q", step.cmd, got, step.want)
+		}
+	}
+}
+
+// TestTCPServer_BlockingPop — BLPOP ждёт вставки из другого соединения дольше idle timeout,
+// отключившийся клиент не забирает элемент, а Stop прерывает ожидание
+func TestTCPServer_BlockingPop(t *testing.T) {
+	logger := zap.NewNop()
+
+	cfg := config.Config{}
+	cfg.Network.Address = "127.0.0.1:0"
+	cfg.Network.MaxConnections = 5
+	cfg.Network.MaxMessageSize = "4KB"
+	cfg.Network.IdleTimeout = 300 * time.Millisecond
+
+	cmp := compute.NewCompute(parser.NewParser(), engine.NewInMemoryEngine(logger), &wal.NoOpWAL{}, logger)
+	srv := tcpserver.NewTCPServer(cfg, cmp, logger)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("failed to start TCP server: %v", err)
+	}
+	stopped := false
+	defer func() {
+		if !stopped {
+			srv.Stop()
+		}
+	}()
+
+	type client struct {
+		conn   net.Conn
+		reader *bufio.Reader
+	}
+	dial := func() client {
+		conn, err := net.Dial("tcp", getServerAddr(srv))
+		if err != nil {
+			t.Fatalf("failed to dial server: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return client{conn, bufio.NewReader(conn)}
+	}
+	send := func(c client, cmd string) {
+		t.Helper()
+		if _, err := fmt.Fprintf(c.conn, "%s\n", cmd); err != nil {
+			t.Fatalf("failed to send %q: %v", cmd, err)
+		}
+	}
+	read := func(c client) string {
+		t.Helper()
+		resp, err := c.reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		return strings.TrimSuffix(resp, "\n")
+	}
+	call := func(c client, cmd string) string {
+		t.Helper()
+		send(c, cmd)
+		return read(c)
+	}
+
+	consumer, producer := dial(), dial()
+
+	if got := call(producer, "RPUSH jobs a b"); got != "(integer) 2" {
+		t.Errorf("RPUSH: got %q", got)
+	}
+	if got := call(consumer, "BLPOP empty jobs 1"); got != `1) "jobs"; 2) "a"` {
+		t.Errorf("BLPOP with data: got %q", got)
+	}
+	if got := call(consumer, "LRANGE jobs 0 -1"); got != `1) "b"` {
+		t.Errorf("LRANGE: got %q", got)
+	}
+	start := time.Now()
+	if got := call(consumer, "BLPOP empty 0.1"); got != "(nil)" {
+		t.Errorf("BLPOP timeout: got %q", got)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("BLPOP returned after %v, want at least 100ms", elapsed)
+	}
+
+	// Ожидание дольше idle timeout: соединение не закрывается, ответ приходит после вставки
+	send(consumer, "BLPOP queue 5")
+	time.Sleep(2 * cfg.Network.IdleTimeout)
+	if got := call(dial(), "LPUSH queue job"); got != "(integer) 1" {
+		t.Errorf("LPUSH: got %q", got)
+	}
+	if got := read(consumer); got != `1) "queue"; 2) "job"` {
+		t.Errorf("woken BLPOP: got %q", got)
+	}
+	if got := call(consumer, "LLEN queue"); got != "(integer) 0" {
+		t.Errorf("LLEN after pop: got %q", got)
+	}
+
+	// Отключившийся клиент не должен забрать элемент
+	gone := dial()
+	send(gone, "BLPOP lost 0")
+	time.Sleep(50 * time.Millisecond)
+	gone.conn.Close()
+	time.Sleep(50 * time.Millisecond)
+	// producer к этому времени закрыт по idle timeout
+	producer = dial()
+	call(producer, "RPUSH lost x")
+	if got := call(producer, "LLEN lost"); got != "(integer) 1" {
+		t.Errorf("element taken by disconnected client: LLEN=%q", got)
+	}
+
+	// Остановка сервера прерывает бесконечное ожидание
+	send(consumer, "BLPOP never 0")
+	time.Sleep(50 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		srv.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		stopped = true
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stop is blocked by parked BLPOP")
+	}
+}
+
+// TestTCPServer_Pipelining — команды, отправленные одной пачкой без ожидания ответов,
+// выполняются по порядку, их записи уходят в WAL общим батчем, а ответы приходят
+// в порядке запросов
+func TestTCPServer_Pipelining(t *testing.T) {
+	logger := zap.NewNop()
+
+	cfg := config.Config{}
+	cfg.Network.Address = "127.0.0.1:0"
+	cfg.Network.MaxConnections = 5
+	cfg.Network.MaxMessageSize = "4KB"
+	cfg.Network.IdleTimeout = 5 * time.Second
+
+	// Батч сбрасывается по таймеру: без конвейера каждая запись ждала бы его целиком
+	wl, err := wal.NewFileWAL(config.WALConfig{
+		FlushingBatchSize:    1000,
+		FlushingBatchTimeout: 50 * time.Millisecond,
+		MaxSegmentSize:       "1MB",
+		DataDirectory:        t.TempDir(),
+	}, 0, logger)
+	if err != nil {
+		t.Fatalf("failed to create WAL: %v", err)
+	}
+	defer wl.Close()
+
+	cmp := compute.NewCompute(parser.NewParser(), engine.NewInMemoryEngine(logger), wl, logger)
+	srv := tcpserver.NewTCPServer(cfg, cmp, logger)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("failed to start TCP server: %v", err)
+	}
+	defer srv.Stop()
+
+	conn, err := net.Dial("tcp", getServerAddr(srv))
+	if err != nil {
+		t.Fatalf("failed to dial server: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	const n = 100
+	var batch strings.Builder
+	var want []string
+	for i := 1; i <= n; i++ {
+		batch.WriteString("INCR counter\nGET counter\n")
+		want = append(want, fmt.Sprintf("(integer) %d", i), fmt.Sprintf("%q", fmt.Sprint(i)))
+	}
+	batch.WriteString("GET missing\n")
+	want = append(want, "(nil)")
+
+	start := time.Now()
+	if _, err := conn.Write([]byte(batch.String())); err != nil {
+		t.Fatalf("failed to send pipeline: %v", err)
+	}
+	for i, w := range want {
+		resp, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read reply %d: %v", i, err)
+		}
+		if got := strings.TrimSuffix(resp, "\n"); got != w {
+			t.Fatalf("reply %d: got %q, want %q", i, got, w)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > n*50*time.Millisecond/4 {
+		t.Errorf("pipeline took %v, writes were not batched", elapsed)
+	}
+	if got := wl.SyncedLSN(); got != n {
+		t.Errorf("synced LSN = %d, want %d", got, n)
+	}
+}
+
+// TestTCPServer_Shutdown — при плавной остановке уже отправленные команды выполняются
+// и получают ответы после fsync, простаивающие соединения закрываются сразу,
+// а новые не принимаются
+func TestTCPServer_Shutdown(t *testing.T) {
+	logger := zap.NewNop()
+
+	cfg := config.Config{}
+	cfg.Network.Address = "127.0.0.1:0"
+	cfg.Network.MaxConnections = 5
+	cfg.Network.MaxMessageSize = "4KB"
+	cfg.Network.IdleTimeout = time.Minute
+
+	// Длинный таймаут батча: ответы на записи придут заметно позже вызова Shutdown
+	wl, err := wal.NewFileWAL(config.WALConfig{
+		FlushingBatchSize:    1000,
+		FlushingBatchTimeout: 200 * time.Millisecond,
+		MaxSegmentSize:       "1MB",
+		DataDirectory:        t.TempDir(),
+	}, 0, logger)
+	if err != nil {
+		t.Fatalf("failed to create WAL: %v", err)
+	}
+	defer wl.Close()
+
+	cmp := compute.NewCompute(parser.NewParser(), engine.NewInMemoryEngine(logger), wl, logger)
+	srv := tcpserver.NewTCPServer(cfg, cmp, logger)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("failed to start TCP server: %v", err)
+	}
+	addr := getServerAddr(srv)
+
+	idle, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial server: %v", err)
+	}
+	defer idle.Close()
+	busy, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial server: %v", err)
+	}
+	defer busy.Close()
+
+	if _, err := busy.Write([]byte("SET a 1\nSET b 2\nGET a\n")); err != nil {
+		t.Fatalf("failed to send commands: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Shutdown took %v, idle connection was not released", elapsed)
+	}
+
+	reader := bufio.NewReader(busy)
+	for _, want := range []string{"OK", "OK", `"1"`} {
+		resp, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reply %q lost on shutdown: %v", want, err)
+		}
+		if got := strings.TrimSuffix(resp, "\n"); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+	if _, err := reader.ReadString('\n'); err == nil {
+		t.Error("connection is still open after Shutdown")
+	}
+	if got := wl.SyncedLSN(); got != 2 {
+		t.Errorf("synced LSN = %d, want 2", got)
+	}
+
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Error("server accepts connections after Shutdown")
+	}
+}
+
+// testHashIterations – дешёвый PBKDF2 для тестов: с 600000 итераций вход под -race
+// занимает секунды и не укладывается в таймауты соединений
+const testHashIterations = 1000
+
+// TestTCPServer_Auth — с включённым ACL команды до AUTH отклоняются с NOAUTH, после –
+// проверяются по правам пользователя; вход действует только для своего соединения
+func TestTCPServer_Auth(t *testing.T) {
+	logger := zap.NewNop()
+
+	cfg := config.Config{}
+	cfg.Network.Address = "127.0.0.1:0"
+	cfg.Network.MaxConnections = 5
+	cfg.Network.MaxMessageSize = "4KB"
+	cfg.Network.IdleTimeout = 2 * time.Second
+
+	writerHash, _ := acl.HashPasswordIterations("w pass", testHashIterations)
+	readerHash, _ := acl.HashPasswordIterations("r", testHashIterations)
+	access, err := acl.New(config.AuthConfig{MinPasswordIterations: testHashIterations, Users: []config.UserConfig{
+		{Name: "writer", PasswordHash: writerHash, Categories: []string{"read", "write"}, Keys: []string{"app:*"}},
+		{Name: "reader", PasswordHash: readerHash, Categories: []string{"read"}, Keys: []string{"*"}},
+	}}, logger)
+	if err != nil {
+		t.Fatalf("acl.New: %v", err)
+	}
+
+	cmp := compute.NewCompute(parser.NewParser(), engine.NewInMemoryEngine(logger), &wal.NoOpWAL{}, logger)
+	srv := tcpserver.NewTCPServer(cfg, cmp, logger)
+	srv.SetACL(access)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("failed to start TCP server: %v", err)
+	}
+	defer srv.Stop()
+
+	dial := func() func(cmd string) string {
+		conn, err := net.Dial("tcp", getServerAddr(srv))
+		if err != nil {
+			t.Fatalf("failed to dial server: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		reader := bufio.NewReader(conn)
+		return func(cmd string) string {
+			t.Helper()
+			if _, err := fmt.Fprintf(conn, "%s\n", cmd); err != nil {
+				t.Fatalf("failed to send %q: %v", cmd, err)
+			}
+			resp, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("failed to read response to %q: %v", cmd, err)
+			}
+			return strings.TrimSuffix(resp, "\n")
+		}
+	}
+
+	writer, reader := dial(), dial()
+	steps := []struct {
+		send func(string) string
+		cmd  string
+		want string
+	}{
+		{writer, "SET app:1 v", "ERROR: NOAUTH Authentication required."},
+		{writer, "AUTH writer wrong", "ERROR: WRONGPASS invalid username-password pair"},
+		{writer, `AUTH writer "w pass"`, "OK"},
+		{writer, "SET app:1 v", "OK"},
+		{writer, "SET other v", "ERROR: NOPERM User writer has no permissions to access the 'other' key"},
+		{writer, "KEYS *", "ERROR: NOPERM User writer has no permissions to run the 'KEYS' command"},
+		// Запрещённая команда внутри MULTI отменяет транзакцию
+		{writer, "MULTI", "OK"},
+		{writer, "SET app:2 v", "QUEUED"},
+		{writer, "DEL other", "ERROR: NOPERM User writer has no permissions to access the 'other' key"},
+		{writer, "EXEC", "ERROR: EXECABORT Transaction discarded because of previous errors"},
+		// Вход одного соединения не действует на другое
+		{reader, "GET app:1", "ERROR: NOAUTH Authentication required."},
+		{reader, "AUTH reader r", "OK"},
+		{reader, "GET app:1", `"v"`},
+		{reader, "GET app:2", "(nil)"},
+		{reader, "DEL app:1", "ERROR: NOPERM User reader has no permissions to run the 'DEL' command"},
+	}
+	for _, step := range steps {
+		if got := step.send(step.cmd); got != step.want {
+			t.Errorf("%s: got %q, want %q", step.cmd, got, step.want)
+		}
+	}
+}
+
+// TestTCPServer_TLS — команды по mutual TLS; клиент без сертификата не обслуживается
+func TestTCPServer_TLS(t *testing.T) {
+	logger := zap.NewNop()
+
+	dir := t.TempDir()
+	ca, err := tlstest.NewCA(dir, "ca")
+	if err != nil {
+		t.Fatalf("NewCA: %v", err)
+	}
+	serverCert, serverKey, err := ca.Issue(dir, "server")
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	clientCert, clientKey, err := ca.Issue(dir, "client")
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	cfg := config.Config{}
+	cfg.Network.Address = "127.0.0.1:0"
+	cfg.Network.MaxConnections = 5
+	cfg.Network.MaxMessageSize = "4KB"
+	cfg.Network.IdleTimeout = 2 * time.Second
+	cfg.Network.TLS = config.TLSConfig{
+		CertFile: serverCert, KeyFile: serverKey, CAFile: ca.CertFile, RequireClientCert: true,
+	}
+
+	cmp := compute.NewCompute(parser.NewParser(), engine.NewInMemoryEngine(logger), &wal.NoOpWAL{}, logger)
+	srv := tcpserver.NewTCPServer(cfg, cmp, logger)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("failed to start TCP server: %v", err)
+	}
+	defer srv.Stop()
+
+	send := func(opts tlsutil.ClientOptions, cmd string) (string, error) {
+		tlsCfg, err := tlsutil.NewClientConfig(opts)
+		if err != nil {
+			t.Fatalf("NewClientConfig: %v", err)
+		}
+		conn, err := tls.Dial("tcp", getServerAddr(srv), tlsCfg)
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err := fmt.Fprintf(conn, "%s\n", cmd); err != nil {
+			return "", err
+		}
+		resp, err := bufio.NewReader(conn).ReadString('\n')
+		return strings.TrimSuffix(resp, "\n"), err
+	}
+
+	withCert := tlsutil.ClientOptions{CAFile: ca.CertFile, CertFile: clientCert, KeyFile: clientKey}
+	if got, err := send(withCert, "SET k v"); err != nil || got != "OK" {
+		t.Fatalf("SET over TLS: got %q, %v", got, err)
+	}
+	if got, err := send(withCert, "GET k"); err != nil || got != `"v"` {
+		t.Fatalf("GET over TLS: got %q, %v", got, err)
+	}
+	if _, err := send(tlsutil.ClientOptions{CAFile: ca.CertFile}, "GET k"); err == nil {
+		t.Error("client without certificate: expected error")
+	}
+
+	// Клиент без TLS не получает ответа на команду
+	conn, err := net.Dial("tcp", getServerAddr(srv))
+	if err != nil {
+		t.Fatalf("failed to dial server: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	fmt.Fprintf(conn, "GET k\n")
+	if resp, err := bufio.NewReader(conn).ReadString('\n'); err == nil && strings.Contains(resp, "v") {
+		t.Errorf("plain TCP client got a reply: %q", resp)
+	}
+}
+
+// TestTCPServer_MaxMemory — при noeviction записи сверх max_memory отклоняются, удаления проходят
+func TestTCPServer_MaxMemory(t *testing.T) {
+	logger := zap.NewNop()
+
+	cfg := config.Config{}
+	cfg.Network.Address = "127.0.0.1:0"
+	cfg.Network.MaxConnections = 5
+	cfg.Network.MaxMessageSize = "4KB"
+	cfg.Network.IdleTimeout = 2 * time.Second
+
+	eng := engine.NewInMemoryEngine(logger)
+	cmp := compute.NewCompute(parser.NewParser(), eng, &wal.NoOpWAL{}, logger)
+	evictor, err := engine.NewEvictor(eng, config.MemoryConfig{MaxMemory: "1KB", EvictionPolicy: config.EvictNoEviction}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmp.SetEvictor(evictor)
+	srv := tcpserver.NewTCPServer(cfg, cmp, logger)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("failed to start TCP server: %v", err)
+	}
+	defer srv.Stop()
+
+	conn, err := net.Dial("tcp", getServerAddr(srv))
+	if err != nil {
+		t.Fatalf("failed to dial server: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	send := func(cmd string) string {
+		t.Helper()
+		if _, err := fmt.Fprintf(conn, "%s\n", cmd); err != nil {
+			t.Fatalf("failed to send %q: %v", cmd, err)
+		}
+		resp, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read response to %q: %v", cmd, err)
+		}
+		return strings.TrimSuffix(resp, "\n")
+	}
+
+	// Запись, на которой память превысила лимит, проходит; следующие отклоняются
+	value := strings.Repeat("v", 600)
+	for _, key := range []string{"a", "b"} {
+		if got := send("SET " + key + " " + value); got != "OK" {
+			t.Fatalf("SET %s: %s", key, got)
+		}
+	}
+	oom := "ERROR: OOM command not allowed when used memory > 'max_memory'"
+	for _, cmd := range []string{"SET c 1", "LPUSH l x", "MSET d 1 e 2"} {
+		if got := send(cmd); got != oom {
+			t.Errorf("%s: got %q, want OOM", cmd, got)
+		}
+	}
+	send("MULTI")
+	send("SET c 1")
+	if got := send("EXEC"); got != oom {
+		t.Errorf("EXEC: got %q, want OOM", got)
+	}
+	if got := send("GET a"); got != `"`+value+`"` {
+		t.Errorf("GET a: got %q", got)
+	}
+	// Удаление освобождает память, и запись снова проходит
+	if got := send("DEL a"); got != "(integer) 1" {
+		t.Errorf("DEL a: got %q", got)
+	}
+	if got := send("SET c 1"); got != "OK" {
+		t.Errorf("SET after DEL: got %q", got)
+	}
+}
+
+// TestTCPServer_WALFailure — после ошибки WAL запись отключается (fail-stop): модифицирующие
+// команды и снимки отклоняются с MISCONF, чтение работает
+func TestTCPServer_WALFailure(t *testing.T) {
+	logger := zap.NewNop()
+
+	cfg := config.Config{}
+	cfg.Network.Address = "127.0.0.1:0"
+	cfg.Network.MaxConnections = 5
+	cfg.Network.MaxMessageSize = "4KB"
+	cfg.Network.IdleTimeout = 2 * time.Second
+
+	// Каталог WAL удаляется: первая же ротация сегмента ломает WAL
+	dir := filepath.Join(t.TempDir(), "wal")
+	wl, err := wal.NewFileWAL(config.WALConfig{
+		FlushingBatchSize:    1,
+		FlushingBatchTimeout: 10 * time.Millisecond,
+		MaxSegmentSize:       "16",
+		DataDirectory:        dir,
+	}, 0, logger)
+	if err != nil {
+		t.Fatalf("failed to create WAL: %v", err)
+	}
+	defer wl.Close()
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	cmp := compute.NewCompute(parser.NewParser(), engine.NewInMemoryEngine(logger), wl, logger)
+	srv := tcpserver.NewTCPServer(cfg, cmp, logger)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("failed to start TCP server: %v", err)
+	}
+	defer srv.Stop()
+
+	conn, err := net.Dial("tcp", getServerAddr(srv))
+	if err != nil {
+		t.Fatalf("failed to dial server: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	send := func(cmd string) string {
+		t.Helper()
+		if _, err := fmt.Fprintf(conn, "%s\n", cmd); err != nil {
+			t.Fatalf("failed to send %q: %v", cmd, err)
+		}
+		resp, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read response to %q: %v", cmd, err)
+		}
+		return strings.TrimSuffix(resp, "\n")
+	}
+
+	if got := send("SET a 1"); got != "OK" {
+		t.Fatalf("SET a: %s", got)
+	}
+	misconf := "ERROR: MISCONF "
+	for _, cmd := range []string{"SET b 2", "DEL a", "MSET c 1 d 2"} {
+		if got := send(cmd); !strings.HasPrefix(got, misconf) {
+			t.Errorf("%s: got %q, want MISCONF", cmd, got)
+		}
+	}
+	send("MULTI")
+	send("SET b 2")
+	if got := send("EXEC"); !strings.HasPrefix(got, misconf) {
+		t.Errorf("EXEC: got %q, want MISCONF", got)
+	}
+	if got := send("GET a"); got != `"1"` {
+		t.Errorf("GET a: got %q", got)
+	}
+	if got := send("GET b"); got != "(nil)" {
+		t.Errorf("GET b: got %q", got)
+	}
+	if _, _, err := cmp.Snapshot(); err == nil {
+		t.Error("expected Snapshot to fail after a WAL error")
+	}
+}
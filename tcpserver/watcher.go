@@ -0,0 +1,57 @@
+package tcpserver
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+
+	"imkvdb/compute"
+)
+
+// ConnWatcher – compute.BlockWatcher для соединения, команды которого читаются через reader.
+//
+// Пока команда ждёт (BLPOP), соединение не считается простаивающим: idle timeout снимается,
+// а отдельная горутина делает reader.Peek, чтобы заметить отключение клиента – иначе
+// элемент, пришедший после отключения, был бы извлечён из списка и потерян. Если клиент
+// прислал следующую команду, Peek просто возвращается и она дождётся своей очереди.
+// Ожидание прерывается и при закрытии quit (остановка сервера).
+// stop прерывает Peek и заново выставляет idle timeout перед ответом клиенту.
+func ConnWatcher(conn net.Conn, reader *bufio.Reader, quit <-chan struct{}, idleTimeout time.Duration) compute.BlockWatcher {
+	return func() (<-chan struct{}, func()) {
+		cancel := make(chan struct{})
+		var once sync.Once
+		cancelWait := func() { once.Do(func() { close(cancel) }) }
+
+		_ = conn.SetDeadline(time.Time{})
+		peekDone := make(chan struct{})
+		go func() {
+			defer close(peekDone)
+			if _, err := reader.Peek(1); err != nil {
+				cancelWait()
+			}
+		}()
+
+		stopQuit := make(chan struct{})
+		go func() {
+			select {
+			case <-quit:
+				cancelWait()
+			case <-stopQuit:
+			}
+		}()
+
+		return cancel, func() {
+			close(stopQuit)
+			// Дедлайн в прошлом будит Peek; ошибка таймаута не остаётся в reader,
+			// следующее чтение пойдёт как обычно
+			_ = conn.SetReadDeadline(time.Now())
+			<-peekDone
+			if idleTimeout > 0 {
+				_ = conn.SetDeadline(time.Now().Add(idleTimeout))
+			} else {
+				_ = conn.SetDeadline(time.Time{})
+			}
+		}
+	}
+}
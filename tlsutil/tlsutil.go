@@ -0,0 +1,226 @@
+// Package tlsutil – TLS для серверов и клиентов по config.TLSConfig: сертификат сервера,
+// CA для проверки сертификатов клиентов (mutual TLS) и перечитывание файлов без перезапуска.
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"imkvdb/config"
+)
+
+// reloadCheckInterval – не чаще, чем раз в этот период, при новом соединении
+// проверяется, не изменились ли файлы сертификатов
+const reloadCheckInterval = time.Second
+
+// Reloader держит загруженные сертификат и CA и перечитывает их, когда файлы меняются
+// (например, после ротации сертификата): новые соединения получают новый сертификат,
+// уже установленные продолжают работать со старым. Если новые файлы не загрузились
+// (например, сертификат уже заменён, а ключ ещё нет), остаётся прежняя версия.
+type Reloader struct {
+	cfg    config.TLSConfig
+	logger *zap.Logger
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	pool      *x509.CertPool // nil – CA не задан
+	stamps    []fileStamp
+	lastCheck time.Time
+	now       func() time.Time // источник времени (подменяется в тестах)
+}
+
+// fileStamp – время изменения и размер файла на момент загрузки
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewReloader загружает файлы из cfg; без cert_file TLS не используется – ошибка
+func NewReloader(cfg config.TLSConfig, logger *zap.Logger) (*Reloader, error) {
+	if !cfg.Enabled() {
+		return nil, errors.New("tls: cert_file is not set")
+	}
+	r := &Reloader{cfg: cfg, logger: logger, now: time.Now}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	r.lastCheck = r.now()
+	return r, nil
+}
+
+// files – файлы, за изменениями которых следим
+func (r *Reloader) files() []string {
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.CAFile != "" {
+		files = append(files, r.cfg.CAFile)
+	}
+	return files
+}
+
+// load читает сертификат, ключ и CA; вызывается под mu (или до начала работы)
+func (r *Reloader) load() error {
+	stamps, err := statFiles(r.files())
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("tls: load certificate: %w", err)
+	}
+	var pool *x509.CertPool
+	if r.cfg.CAFile != "" {
+		if pool, err = LoadCertPool(r.cfg.CAFile); err != nil {
+			return err
+		}
+	}
+	r.cert, r.pool, r.stamps = &cert, pool, stamps
+	return nil
+}
+
+// current возвращает сертификат и CA, перед этим перечитав изменившиеся файлы
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now := r.now(); now.Sub(r.lastCheck) >= reloadCheckInterval {
+		r.lastCheck = now
+		stamps, err := statFiles(r.files())
+		if err == nil && !sameStamps(stamps, r.stamps) {
+			if err := r.load(); err != nil {
+				r.logger.Error("failed to reload TLS certificates, keeping previous ones", zap.Error(err))
+			} else {
+				r.logger.Info("TLS certificates reloaded", zap.String("cert_file", r.cfg.CertFile))
+			}
+		}
+	}
+	return r.cert, r.pool
+}
+
+// ServerConfig – настройки TLS для listener: сертификат и CA берутся при каждом
+// рукопожатии, поэтому ротация файлов подхватывается без перезапуска
+func (r *Reloader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.current()
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				ClientCAs:    pool,
+			}
+			switch {
+			case r.cfg.RequireClientCert:
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			case pool != nil:
+				cfg.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// ClientConfig – настройки TLS для исходящего соединения узла (реплика -> лидер):
+// свой сертификат предъявляется лидеру, сертификат лидера проверяется по CA.
+// Берёт текущие версии файлов, поэтому вызывается на каждое подключение.
+func (r *Reloader) ClientConfig() *tls.Config {
+	cert, pool := r.current()
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*cert},
+		RootCAs:      pool,
+	}
+}
+
+// ClientOptions – TLS клиента (cmd/client)
+type ClientOptions struct {
+	CAFile   string // CA для проверки сервера; пусто – системные корневые сертификаты
+	CertFile string // сертификат клиента для mutual TLS; пусто – не предъявлять
+	KeyFile  string
+	// ServerName – имя в сертификате сервера; пусто – хост из адреса подключения
+	ServerName string
+}
+
+// NewClientConfig – настройки TLS клиента по opts
+func NewClientConfig(opts ClientOptions) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: opts.ServerName}
+	if opts.CAFile != "" {
+		pool, err := LoadCertPool(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// LoadCertPool читает PEM-файл с одним или несколькими сертификатами CA
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("tls: read CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("tls: no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+func statFiles(paths []string) ([]fileStamp, error) {
+	stamps := make([]fileStamp, len(paths))
+	for i, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+		stamps[i] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+	return stamps, nil
+}
+
+func sameStamps(a, b []fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].modTime.Equal(b[i].modTime) || a[i].size != b[i].size {
+			return false
+		}
+	}
+	return true
+}
+
+// Listen слушает addr; если TLS включён, соединения принимаются по TLS
+// с сертификатами из cfg (см. Reloader)
+func Listen(addr string, cfg config.TLSConfig, logger *zap.Logger) (net.Listener, error) {
+	var serverCfg *tls.Config
+	if cfg.Enabled() {
+		r, err := NewReloader(cfg, logger)
+		if err != nil {
+			return nil, err
+		}
+		serverCfg = r.ServerConfig()
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if serverCfg != nil {
+		ln = tls.NewListener(ln, serverCfg)
+	}
+	return ln, nil
+}
@@ -0,0 +1,147 @@
+package tlsutil
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"imkvdb/config"
+	"imkvdb/tlsutil/tlstest"
+)
+
+func TestListen_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := tlstest.NewCA(dir, "ca")
+	if err != nil {
+		t.Fatalf("NewCA: %v", err)
+	}
+	serverCert, serverKey, err := ca.Issue(dir, "server")
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	clientCert, clientKey, err := ca.Issue(dir, "client")
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	ln, err := Listen("127.0.0.1:0", config.TLSConfig{
+		CertFile: serverCert, KeyFile: serverKey, CAFile: ca.CertFile, RequireClientCert: true,
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+	// Эхо-сервер
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	roundTrip := func(opts ClientOptions) error {
+		cfg, err := NewClientConfig(opts)
+		if err != nil {
+			t.Fatalf("NewClientConfig: %v", err)
+		}
+		conn, err := tls.Dial("tcp", ln.Addr().String(), cfg)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			return err
+		}
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return err
+		}
+		if !bytes.Equal(buf, []byte("ping")) {
+			t.Fatalf("echo: got %q", buf)
+		}
+		return nil
+	}
+
+	if err := roundTrip(ClientOptions{CAFile: ca.CertFile, CertFile: clientCert, KeyFile: clientKey}); err != nil {
+		t.Errorf("client with certificate: %v", err)
+	}
+	// Без сертификата клиента сервер обрывает рукопожатие
+	if err := roundTrip(ClientOptions{CAFile: ca.CertFile}); err == nil {
+		t.Error("client without certificate: expected error")
+	}
+	// Сертификат сервера, подписанный чужим CA, клиент не принимает
+	other, err := tlstest.NewCA(t.TempDir(), "other")
+	if err != nil {
+		t.Fatalf("NewCA: %v", err)
+	}
+	if err := roundTrip(ClientOptions{CAFile: other.CertFile, CertFile: clientCert, KeyFile: clientKey}); err == nil {
+		t.Error("untrusted server certificate: expected error")
+	}
+}
+
+func TestReloader_Reload(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := tlstest.NewCA(dir, "ca")
+	if err != nil {
+		t.Fatalf("NewCA: %v", err)
+	}
+	certFile, keyFile, err := ca.Issue(dir, "server")
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	r, err := NewReloader(config.TLSConfig{CertFile: certFile, KeyFile: keyFile}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewReloader: %v", err)
+	}
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	first, _ := r.current()
+
+	// touch выставляет файлам новое время изменения: в пределах одного тика
+	// часов файловой системы перезапись иначе может остаться незамеченной
+	mtime := time.Now()
+	touch := func() {
+		mtime = mtime.Add(time.Minute)
+		for _, path := range []string{certFile, keyFile} {
+			if err := os.Chtimes(path, mtime, mtime); err != nil {
+				t.Fatalf("Chtimes: %v", err)
+			}
+		}
+	}
+
+	// Ротация: до истечения reloadCheckInterval файлы не перечитываются
+	if _, _, err := ca.Issue(dir, "server"); err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	touch()
+	if cert, _ := r.current(); cert != first {
+		t.Fatal("certificate reloaded before reloadCheckInterval")
+	}
+	now = now.Add(reloadCheckInterval)
+	second, _ := r.current()
+	if second == first || bytes.Equal(second.Certificate[0], first.Certificate[0]) {
+		t.Fatal("certificate was not reloaded after rotation")
+	}
+
+	// Битый ключ: остаётся прежний сертификат
+	if err := os.WriteFile(keyFile, []byte("garbage"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	touch()
+	now = now.Add(reloadCheckInterval)
+	if cert, _ := r.current(); cert != second {
+		t.Fatal("broken key file replaced the working certificate")
+	}
+}
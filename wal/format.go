@@ -0,0 +1,226 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Бинарный формат сегмента (версия 3):
+//
+//	заголовок: magic "IMKVWAL" | версия (1 байт) | base LSN (uint64 LE) – LSN первой записи сегмента
+//	запись:    длина payload (uint32 LE) | CRC32C payload (uint32 LE) | payload
+//	payload:   LSN (uvarint) | тело
+//	тело:      op (1 байт) | key (uvarint длина + байты) |
+//	           value (uvarint длина + байты) | expireAt (varint, unix-мс) | version (uvarint) |
+//	           args (uvarint число + строки как value) |
+//	           для OpBatch: число операций (uvarint) и их тела подряд
+//
+// Версия 1 не содержит полей version и args, версия 2 – поля args; такие сегменты читаются как есть.
+//
+// Ключи и значения хранятся как есть, поэтому пробелы, переводы строк и двоичные данные
+// переживают запись без искажений. Обрезанная при падении запись ловится по длине или CRC.
+const (
+	segmentMagic      = "IMKVWAL"
+	segmentVersion    = byte(3)
+	segmentHeaderSize = len(segmentMagic) + 1 + 8
+	recordHeaderSize  = 8
+
+	// maxRecordSize – защита от мусорной длины в повреждённом заголовке записи
+	maxRecordSize = 256 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errTornRecord – запись обрезана или повреждена (обычно – недописанный при падении хвост)
+var errTornRecord = errors.New("torn or corrupted WAL record")
+
+// encodeSegmentHeader – заголовок нового сегмента
+func encodeSegmentHeader(baseLSN uint64) []byte {
+	buf := make([]byte, 0, segmentHeaderSize)
+	buf = append(buf, segmentMagic...)
+	buf = append(buf, segmentVersion)
+	return binary.LittleEndian.AppendUint64(buf, baseLSN)
+}
+
+// segmentHeader – разобранный заголовок бинарного сегмента
+type segmentHeader struct {
+	version byte
+	baseLSN uint64
+}
+
+// decodeSegmentHeader разбирает заголовок; ok=false – это не бинарный сегмент (старый текстовый формат)
+func decodeSegmentHeader(header []byte) (hdr segmentHeader, ok bool, err error) {
+	if len(header) < segmentHeaderSize || string(header[:len(segmentMagic)]) != segmentMagic {
+		return hdr, false, nil
+	}
+	hdr.version = header[len(segmentMagic)]
+	if hdr.version < 1 || hdr.version > segmentVersion {
+		return hdr, true, fmt.Errorf("unsupported WAL segment version %d", hdr.version)
+	}
+	hdr.baseLSN = binary.LittleEndian.Uint64(header[len(segmentMagic)+1:])
+	return hdr, true, nil
+}
+
+// appendRecord дописывает в buf запись в бинарном формате
+func appendRecord(buf []byte, r Record) []byte {
+	payload := make([]byte, 0, 32+len(r.Key)+len(r.Value))
+	payload = binary.AppendUvarint(payload, r.LSN)
+	payload = appendRecordBody(payload, r)
+
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(payload)))
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.Checksum(payload, crcTable))
+	return append(buf, payload...)
+}
+
+// appendRecordBody дописывает тело записи (всё, кроме LSN)
+func appendRecordBody(buf []byte, r Record) []byte {
+	buf = append(buf, byte(r.Op))
+	buf = binary.AppendUvarint(buf, uint64(len(r.Key)))
+	buf = append(buf, r.Key...)
+	buf = binary.AppendUvarint(buf, uint64(len(r.Value)))
+	buf = append(buf, r.Value...)
+	buf = binary.AppendVarint(buf, r.ExpireAt)
+	buf = binary.AppendUvarint(buf, r.Version)
+	buf = binary.AppendUvarint(buf, uint64(len(r.Args)))
+	for _, arg := range r.Args {
+		buf = binary.AppendUvarint(buf, uint64(len(arg)))
+		buf = append(buf, arg...)
+	}
+	if r.Op == OpBatch {
+		buf = binary.AppendUvarint(buf, uint64(len(r.Batch)))
+		for _, op := range r.Batch {
+			buf = appendRecordBody(buf, op)
+		}
+	}
+	return buf
+}
+
+// readRecord читает одну запись сегмента версии version. io.EOF – сегмент закончился
+// ровно на границе записи, errTornRecord – запись обрезана или не сходится CRC.
+func readRecord(r *bufio.Reader, version byte) (Record, int, error) {
+	var header [recordHeaderSize]byte
+	if n, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF {
+			return Record{}, 0, io.EOF
+		}
+		if err == io.ErrUnexpectedEOF {
+			return Record{}, n, errTornRecord
+		}
+		return Record{}, n, err
+	}
+	size := binary.LittleEndian.Uint32(header[:4])
+	if size > maxRecordSize {
+		return Record{}, recordHeaderSize, errTornRecord
+	}
+	payload := make([]byte, size)
+	if n, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return Record{}, recordHeaderSize + n, errTornRecord
+		}
+		return Record{}, recordHeaderSize + n, err
+	}
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(header[4:]) {
+		return Record{}, recordHeaderSize + int(size), errTornRecord
+	}
+	rec, err := decodePayload(payload, version)
+	if err != nil {
+		return Record{}, recordHeaderSize + int(size), err
+	}
+	return rec, recordHeaderSize + int(size), nil
+}
+
+// errMalformed – payload с верной CRC, но неразборчивым содержимым (ошибка кодирования)
+var errMalformed = errors.New("malformed WAL record payload")
+
+// decodePayload разбирает payload записи с уже проверенной CRC
+func decodePayload(p []byte, version byte) (Record, error) {
+	lsn, n := binary.Uvarint(p)
+	if n <= 0 {
+		return Record{}, errMalformed
+	}
+	rec, rest, err := decodeRecordBody(p[n:], version)
+	if err != nil {
+		return Record{}, err
+	}
+	if len(rest) != 0 {
+		return Record{}, errMalformed
+	}
+	rec.LSN = lsn
+	return rec, nil
+}
+
+// decodeRecordBody разбирает тело записи и возвращает непрочитанный остаток
+func decodeRecordBody(p []byte, version byte) (Record, []byte, error) {
+	var rec Record
+	if len(p) == 0 {
+		return rec, nil, errMalformed
+	}
+	rec.Op = OperationType(p[0])
+	p = p[1:]
+
+	readBytes := func() (string, bool) {
+		l, n := binary.Uvarint(p)
+		if n <= 0 || uint64(len(p)-n) < l {
+			return "", false
+		}
+		s := string(p[n : n+int(l)])
+		p = p[n+int(l):]
+		return s, true
+	}
+	var ok bool
+	if rec.Key, ok = readBytes(); !ok {
+		return rec, nil, errMalformed
+	}
+	if rec.Value, ok = readBytes(); !ok {
+		return rec, nil, errMalformed
+	}
+	expireAt, n := binary.Varint(p)
+	if n <= 0 {
+		return rec, nil, errMalformed
+	}
+	rec.ExpireAt = expireAt
+	p = p[n:]
+	if version >= 2 {
+		if rec.Version, n = binary.Uvarint(p); n <= 0 {
+			return rec, nil, errMalformed
+		}
+		p = p[n:]
+	}
+	if version >= 3 {
+		count, n := binary.Uvarint(p)
+		if n <= 0 || count > uint64(len(p)) {
+			return rec, nil, errMalformed
+		}
+		p = p[n:]
+		if count > 0 {
+			rec.Args = make([]string, count)
+		}
+		for i := range rec.Args {
+			if rec.Args[i], ok = readBytes(); !ok {
+				return rec, nil, errMalformed
+			}
+		}
+	}
+
+	if rec.Op == OpBatch {
+		count, n := binary.Uvarint(p)
+		if n <= 0 || count > uint64(len(p)) {
+			return rec, nil, errMalformed
+		}
+		p = p[n:]
+		rec.Batch = make([]Record, 0, count)
+		for i := uint64(0); i < count; i++ {
+			op, rest, err := decodeRecordBody(p, version)
+			if err != nil {
+				return rec, nil, err
+			}
+			rec.Batch = append(rec.Batch, op)
+			p = rest
+		}
+	}
+	return rec, p, nil
+}
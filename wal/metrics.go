@@ -0,0 +1,15 @@
+package wal
+
+import "imkvdb/metrics"
+
+var (
+	batchRecords = metrics.NewHistogram("imkvdb_wal_batch_records",
+		"Records written to the WAL per flushed batch",
+		[]float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000})
+	batchBytes    = metrics.NewCounter("imkvdb_wal_written_bytes_total", "Bytes written to WAL segments")
+	fsyncDuration = metrics.NewHistogram("imkvdb_wal_fsync_duration_seconds",
+		"Latency of fsync of a WAL batch", nil)
+	segmentRotations = metrics.NewCounter("imkvdb_wal_segment_rotations_total",
+		"WAL segments closed because max_segment_size was reached")
+	walErrors = metrics.NewCounter("imkvdb_wal_errors_total", "Failed WAL batch writes, fsyncs and rotations")
+)
@@ -0,0 +1,84 @@
+package wal
+
+import (
+	"bufio"
+	"errors"
+	"path/filepath"
+	"sort"
+)
+
+// ErrLSNUnavailable – записей сразу после запрошенного LSN в сегментах уже нет
+// (сегменты убраны после снимка), догнать можно только полной синхронизацией
+var ErrLSNUnavailable = errors.New("requested LSN is no longer available in WAL")
+
+// errStopReading – внутренний сигнал остановить чтение сегментов
+var errStopReading = errors.New("stop reading")
+
+// AppendRecord дописывает в buf запись в том же бинарном формате, что и в сегменте
+// (длина, CRC32C, payload). Используется для передачи записей по сети.
+func AppendRecord(buf []byte, rec Record) []byte {
+	return appendRecord(buf, rec)
+}
+
+// ReadRecord читает запись, записанную AppendRecord
+func ReadRecord(r *bufio.Reader) (Record, error) {
+	rec, _, err := readRecord(r, segmentVersion)
+	return rec, err
+}
+
+// ReadRecords читает из сегментов каталога dir записи с afterLSN < LSN <= uptoLSN
+// по порядку и передаёт их в fn, но не больше limit штук (limit <= 0 – без ограничения).
+// uptoLSN должен быть не больше FileWAL.SyncedLSN: записи после него могут быть ещё недописаны.
+// Если первая нужная запись уже удалена вместе с сегментом, возвращает ErrLSNUnavailable.
+func ReadRecords(dir string, afterLSN, uptoLSN uint64, limit int, fn func(Record) error) error {
+	if uptoLSN <= afterLSN {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "wal_segment_*.log"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	last, sent := afterLSN, 0
+	for i, f := range files {
+		// Сегмент целиком до afterLSN, если следующий начинается не позже afterLSN+1
+		if i+1 < len(files) {
+			if hdr, ok, err := readSegmentHeader(files[i+1]); err == nil && ok && hdr.baseLSN > 0 && hdr.baseLSN <= afterLSN+1 {
+				continue
+			}
+		}
+		err := readSegment(f, false, nil, func(rec Record) error {
+			if rec.LSN <= last {
+				return nil
+			}
+			if rec.LSN != last+1 {
+				// Разрыв в нумерации: начало нужного диапазона уже удалено
+				return ErrLSNUnavailable
+			}
+			if rec.LSN > uptoLSN || (limit > 0 && sent >= limit) {
+				return errStopReading
+			}
+			if err := fn(rec); err != nil {
+				return err
+			}
+			last = rec.LSN
+			sent++
+			return nil
+		})
+		if errors.Is(err, errStopReading) {
+			return nil
+		}
+		if errors.Is(err, errTornRecord) && last >= uptoLSN {
+			// Хвост текущего сегмента ещё дописывается – нужные записи уже прочитаны
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if last < uptoLSN {
+		return ErrLSNUnavailable
+	}
+	return nil
+}
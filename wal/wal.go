@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
@@ -17,17 +19,56 @@ type OperationType int
 const (
 	OpSet OperationType = iota
 	OpDel
+	OpExpire
+	OpPersist
+	// OpBatch – группа операций, которая пишется одной записью и применяется атомарно
+	// (MULTI/EXEC, MSET/MDEL)
+	OpBatch
+	// OpHSet – запись полей хеша (Args – пары поле/значение)
+	OpHSet
+	// OpHDel – удаление полей хеша (Args – имена полей)
+	OpHDel
+	// OpLPush, OpRPush – вставка в начало / конец списка (Args – значения)
+	OpLPush
+	OpRPush
+	// OpLPop, OpRPop – извлечение элемента из начала / конца списка
+	OpLPop
+	OpRPop
+	// OpZAdd – добавление в сортированное множество (Args – пары счёт/элемент,
+	// счёт в виде strconv.FormatFloat(s, 'g', -1, 64))
+	OpZAdd
+	// OpZRem – удаление из сортированного множества (Args – элементы)
+	OpZRem
+	// OpSAdd, OpSRem – добавление в множество / удаление из него (Args – элементы)
+	OpSAdd
+	OpSRem
 )
 
 type Record struct {
 	Op    OperationType
 	Key   string
 	Value string
+	// Args – аргументы операций над коллекциями (поля хеша, элементы списка и т.п.)
+	Args []string
+	// ExpireAt – абсолютное время истечения ключа в unix-миллисекундах (0 – без TTL).
+	// Храним абсолютное время, чтобы при реплее истёкшие ключи не "оживали".
+	ExpireAt int64
+	// Version – версия ключа после операции (восстанавливается при реплее)
+	Version uint64
+	// Batch – операции группы для OpBatch (у вложенных записей LSN не заполняется)
+	Batch []Record
 	// LSN присваивается внутри самой WAL-системы
 	LSN uint64
 }
 type WAL interface {
 	WriteAndWait(rec Record) error
+	// Append ставит запись в очередь и сразу возвращает канал, в который придёт результат fsync.
+	// LSN назначаются в порядке вызовов Append.
+	Append(rec Record) <-chan error
+	// LastLSN – LSN последней поставленной в очередь записи
+	LastLSN() uint64
+	// Err – ошибка записи на диск, после которой WAL больше не принимает записи; nil – исправен
+	Err() error
 	Close() error
 }
 
@@ -38,6 +79,17 @@ func (n *NoOpWAL) WriteAndWait(_ Record) error {
 	// Ничего не делаем
 	return nil
 }
+func (n *NoOpWAL) Append(_ Record) <-chan error {
+	done := make(chan error, 1)
+	done <- nil
+	return done
+}
+func (n *NoOpWAL) LastLSN() uint64 {
+	return 0
+}
+func (n *NoOpWAL) Err() error {
+	return nil
+}
 func (n *NoOpWAL) Close() error {
 	return nil
 }
@@ -49,8 +101,18 @@ type FileWAL struct {
 
 	mu          sync.Mutex
 	currentFile *os.File
+	currentPath string
 	currentSize int64
-	nextLSN     uint64
+	// appendMu – LSN назначается в Append и запись передаётся батчеру под одной блокировкой,
+	// поэтому записи приходят к нему в порядке LSN
+	appendMu sync.Mutex
+	// lastLSN назначается в Append под appendMu, читается атомарно из LastLSN
+	lastLSN atomic.Uint64
+	// syncedLSN – LSN последней записи, прошедшей fsync (до него записи можно отдавать репликам)
+	syncedLSN atomic.Uint64
+	// failure – первая ошибка write/fsync/rotate. После неё батчи на диск не пишутся: запись
+	// после пропуска в логе применилась бы при реплее к данным без пропущенных записей.
+	failure atomic.Pointer[error]
 
 	// Батч (очередь), мьютекс/канал
 	batchCh      chan walRequest
@@ -67,8 +129,9 @@ type walRequest struct {
 	done chan error // чтобы вернуть ошибку/ОК тому, кто вызвал WriteAndWait
 }
 
-// NewFileWAL - создает FileWAL + запускает goroutine для батчирования
-func NewFileWAL(cfg config.WALConfig, logger *zap.Logger) (*FileWAL, error) {
+// NewFileWAL - создает FileWAL + запускает goroutine для батчирования.
+// lastLSN – LSN последней уже существующей записи (результат ReplayWAL), нумерация продолжится с него.
+func NewFileWAL(cfg config.WALConfig, lastLSN uint64, logger *zap.Logger) (*FileWAL, error) {
 	if cfg.DataDirectory == "" {
 		return nil, fmt.Errorf("wal directory is not set")
 	}
@@ -89,6 +152,8 @@ func NewFileWAL(cfg config.WALConfig, logger *zap.Logger) (*FileWAL, error) {
 
 		maxSegmentBytes: maxSize,
 	}
+	fw.lastLSN.Store(lastLSN)
+	fw.syncedLSN.Store(lastLSN)
 	// Создадим директорию, если не существует
 	if err := os.MkdirAll(fw.dir, 0755); err != nil {
 		return nil, err
@@ -108,13 +173,47 @@ func NewFileWAL(cfg config.WALConfig, logger *zap.Logger) (*FileWAL, error) {
 
 // WriteAndWait добавляет запись в очередь и блокируется до тех пор, пока запись не будет зафлашена
 func (fw *FileWAL) WriteAndWait(rec Record) error {
+	return <-fw.Append(rec)
+}
+
+// Append добавляет запись в очередь; к возврату из метода LSN записи уже назначен,
+// поэтому LastLSN, прочитанный после Append (снимок под compute.writeMu), учитывает запись
+func (fw *FileWAL) Append(rec Record) <-chan error {
 	doneCh := make(chan error, 1)
+	fw.appendMu.Lock()
+	defer fw.appendMu.Unlock()
+	rec.LSN = fw.lastLSN.Add(1)
 	fw.batchCh <- walRequest{
 		rec:  rec,
 		done: doneCh,
 	}
-	// Подумать о триггере-флашере
-	return <-doneCh
+	return doneCh
+}
+
+// LastLSN возвращает LSN последней поставленной в очередь записи
+func (fw *FileWAL) LastLSN() uint64 {
+	return fw.lastLSN.Load()
+}
+
+// Err возвращает ошибку, после которой WAL отклоняет все записи (см. failure)
+func (fw *FileWAL) Err() error {
+	if err := fw.failure.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// fail запоминает первую ошибку записи
+func (fw *FileWAL) fail(err error) {
+	walErrors.Inc()
+	if fw.failure.CompareAndSwap(nil, &err) {
+		fw.logger.Error("WAL write failed, further records are rejected", zap.Error(err))
+	}
+}
+
+// SyncedLSN возвращает LSN последней записи, которая уже на диске (после fsync)
+func (fw *FileWAL) SyncedLSN() uint64 {
+	return fw.syncedLSN.Load()
 }
 
 // runBatcher - основной цикл, который собирает записи и флашит
@@ -153,47 +252,60 @@ func (fw *FileWAL) runBatcher() {
 	}
 }
 
-// flushBatch - пишет все записи батча на диск (одним write) + fsync + завершает walRequest
+// flushBatch - пишет все записи батча на диск (одним write) + fsync + завершает walRequest.
+// После первой ошибки (см. failure) батчи сразу завершаются ею.
 func (fw *FileWAL) flushBatch(batch []walRequest) {
 	fw.mu.Lock()
 	defer fw.mu.Unlock()
 
-	// Готовим буфер строк
+	if err := fw.Err(); err != nil {
+		for _, r := range batch {
+			r.done <- err
+		}
+		return
+	}
+
+	// Готовим буфер записей в бинарном формате (см. format.go)
 	lines := make([]byte, 0, 256*len(batch))
 	for i := range batch {
-		fw.nextLSN++
-		batch[i].rec.LSN = fw.nextLSN
-
-		line := encodeRecord(batch[i].rec) // например: "LSN=1 SET key val\n"
-		lines = append(lines, line...)
+		lines = appendRecord(lines, batch[i].rec)
 	}
 
 	// Пишем в файл
+	batchRecords.Observe(float64(len(batch)))
 	n, err := fw.currentFile.Write(lines)
+	batchBytes.Add(float64(n))
 	if err != nil {
 		// Всем возвращаем ошибку
+		err = fmt.Errorf("wal write error: %w", err)
+		fw.fail(err)
 		for _, r := range batch {
-			r.done <- fmt.Errorf("wal write error: %w", err)
+			r.done <- err
 		}
 		return
 	}
 	fw.currentSize += int64(n)
 
 	// fsync
-	if err := fw.currentFile.Sync(); err != nil {
+	start := time.Now()
+	err = fw.currentFile.Sync()
+	fsyncDuration.ObserveDuration(time.Since(start))
+	if err != nil {
+		err = fmt.Errorf("wal fsync error: %w", err)
+		fw.fail(err)
 		for _, r := range batch {
-			r.done <- fmt.Errorf("wal fsync error: %w", err)
+			r.done <- err
 		}
 		return
 	}
+	fw.syncedLSN.Store(batch[len(batch)-1].rec.LSN)
 
-	// Если превысили лимит сегмента -> rotate
+	// Если превысили лимит сегмента -> rotate. Записи батча уже на диске, поэтому
+	// ошибка ротации отклоняет только следующие записи.
 	if fw.currentSize >= int64(fw.maxSegmentBytes) {
+		segmentRotations.Inc()
 		if err := fw.rotateSegment(); err != nil {
-			for _, r := range batch {
-				r.done <- fmt.Errorf("wal rotate error: %w", err)
-			}
-			return
+			fw.fail(fmt.Errorf("wal rotate error: %w", err))
 		}
 	}
 
@@ -217,23 +329,69 @@ func (fw *FileWAL) rotateSegment() error {
 	if err != nil {
 		return err
 	}
+	// Заголовок с версией формата и LSN первой записи сегмента. Батч перед ротацией уже
+	// на диске, а lastLSN может учитывать записи, ещё не дошедшие до батчера.
+	n, err := f.Write(encodeSegmentHeader(fw.syncedLSN.Load() + 1))
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
 	fw.currentFile = f
-	fw.currentSize = 0
+	fw.currentPath = path
+	fw.currentSize = int64(n)
 
 	fw.logger.Info("Opened new WAL segment", zap.String("file", path))
 	return nil
 }
 
-// encodeRecord - преобразует структуру в строку для WAL
-func encodeRecord(r Record) []byte {
-	// например: "LSN=1 SET key val\n"
-	var opStr string
-	if r.Op == OpSet {
-		opStr = "SET"
-	} else {
-		opStr = "DEL"
+// RemoveSegmentsUpTo удаляет (или переносит в archive_directory) сегменты,
+// все записи которых имеют LSN <= lsn, т.е. уже покрыты снимком. Текущий сегмент не трогаем.
+// Возвращает количество убранных сегментов.
+func (fw *FileWAL) RemoveSegmentsUpTo(lsn uint64) (int, error) {
+	fw.mu.Lock()
+	current := fw.currentPath
+	fw.mu.Unlock()
+
+	files, err := filepath.Glob(filepath.Join(fw.dir, "wal_segment_*.log"))
+	if err != nil {
+		return 0, err
+	}
+	sort.Strings(files)
+
+	if fw.cfg.ArchiveDirectory != "" {
+		if err := os.MkdirAll(fw.cfg.ArchiveDirectory, 0755); err != nil {
+			return 0, err
+		}
+	}
+
+	removed := 0
+	for i, f := range files {
+		if f == current || i+1 == len(files) {
+			// Сегменты идут по возрастанию LSN: дальше только более новые
+			break
+		}
+		last, err := segmentLastLSN(f, files[i+1])
+		if err != nil {
+			return removed, fmt.Errorf("read segment %s: %w", f, err)
+		}
+		if last > lsn {
+			break
+		}
+		if fw.cfg.ArchiveDirectory != "" {
+			err = os.Rename(f, filepath.Join(fw.cfg.ArchiveDirectory, filepath.Base(f)))
+		} else {
+			err = os.Remove(f)
+		}
+		if err != nil {
+			return removed, err
+		}
+		removed++
+		fw.logger.Info("WAL segment covered by snapshot removed",
+			zap.String("file", f),
+			zap.Uint64("segment_last_lsn", last),
+		)
 	}
-	return []byte(fmt.Sprintf("LSN=%d %s %s %s\n", r.LSN, opStr, r.Key, r.Value))
+	return removed, nil
 }
 
 // Close закрывает WAL
@@ -4,38 +4,226 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
-	"go.uber.org/zap"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"imkvdb/storage"
 )
 
-// Replayer — тот, кто умеет применять команды из WAL (Set/Del).
+// Replayer — тот, кто умеет применять команды из WAL.
+// Каждая запись (и группа OpBatch целиком) применяется внутри одного Atomic,
+// чтобы изменение и версия ключа появлялись одновременно.
 type Replayer interface {
-	Set(key, value string) error
-	Del(key string) bool
+	Atomic(fn func(tx storage.Tx) error) error
 }
 
 // ReplayWAL читает все *.log файлы в каталоге WAL
-// и последовательно применяет операции
-func ReplayWAL(dir string, replayer Replayer, logger *zap.Logger) error {
+// и последовательно применяет операции с LSN > afterLSN (более ранние уже учтены в снимке).
+// Возвращает LSN последней записи (или afterLSN, если новых записей нет).
+//
+// Недописанный при падении хвост последнего сегмента обрезается; повреждение
+// в середине WAL – ошибка. Сегменты старого текстового формата читаются как раньше.
+func ReplayWAL(dir string, afterLSN uint64, replayer Replayer, logger *zap.Logger) (uint64, error) {
 	files, err := filepath.Glob(filepath.Join(dir, "wal_segment_*.log"))
 	if err != nil {
-		return err
+		return afterLSN, err
 	}
 	sort.Strings(files) // по имени (в нашем случае по времени), чтобы идти от старого к новому
 
-	for _, f := range files {
+	lastLSN := afterLSN
+	for i, f := range files {
 		logger.Info("Replaying WAL segment", zap.String("file", f))
-		if err := replayFile(f, replayer); err != nil {
-			return fmt.Errorf("replay file %s error: %w", f, err)
+		isLast := i == len(files)-1
+		err := readSegment(f, isLast, logger, func(rec Record) error {
+			if rec.LSN <= lastLSN {
+				return nil // уже применено (покрыто снимком)
+			}
+			if err := ApplyRecord(rec, replayer); err != nil {
+				return fmt.Errorf("apply record LSN=%d error: %w", rec.LSN, err)
+			}
+			lastLSN = rec.LSN
+			return nil
+		})
+		if err != nil {
+			return lastLSN, fmt.Errorf("replay file %s error: %w", f, err)
+		}
+	}
+	return lastLSN, nil
+}
+
+// ApplyRecord применяет одну запись WAL (используется при реплее и на реплике)
+func ApplyRecord(rec Record, replayer Replayer) error {
+	return replayer.Atomic(func(tx storage.Tx) error {
+		if rec.Op != OpBatch {
+			return applyOp(rec, tx)
+		}
+		for _, op := range rec.Batch {
+			if err := applyOp(op, tx); err != nil {
+				return err
+			}
 		}
+		return nil
+	})
+}
+
+// applyOp применяет одиночную операцию и восстанавливает записанную версию ключа
+func applyOp(rec Record, replayer storage.Tx) error {
+	if err := applyOpData(rec, replayer); err != nil {
+		return err
+	}
+	if rec.Version != 0 {
+		replayer.SetVersion(rec.Key, rec.Version)
 	}
 	return nil
 }
 
-func replayFile(path string, replayer Replayer) (err error) {
+// applyOpData применяет изменение данных из записи
+func applyOpData(rec Record, replayer storage.Tx) error {
+	switch rec.Op {
+	case OpSet:
+		if err := replayer.Set(rec.Key, rec.Value); err != nil {
+			return err
+		}
+		if rec.ExpireAt != 0 {
+			// Если время уже прошло, Expire сразу удалит ключ
+			replayer.Expire(rec.Key, time.UnixMilli(rec.ExpireAt))
+		}
+		return nil
+	case OpDel:
+		replayer.Del(rec.Key)
+		return nil
+	case OpExpire:
+		replayer.Expire(rec.Key, time.UnixMilli(rec.ExpireAt))
+		return nil
+	case OpPersist:
+		replayer.Persist(rec.Key)
+		return nil
+	case OpHSet:
+		if _, err := replayer.HSet(rec.Key, rec.Args); err != nil {
+			return err
+		}
+		if rec.ExpireAt != 0 {
+			// Хеш целиком из снимка (полная синхронизация реплики) приходит вместе с TTL
+			replayer.Expire(rec.Key, time.UnixMilli(rec.ExpireAt))
+		}
+		return nil
+	case OpHDel:
+		_, err := replayer.HDel(rec.Key, rec.Args)
+		return err
+	case OpLPush:
+		_, err := replayer.LPush(rec.Key, rec.Args)
+		return err
+	case OpRPush:
+		if _, err := replayer.RPush(rec.Key, rec.Args); err != nil {
+			return err
+		}
+		if rec.ExpireAt != 0 {
+			// Список целиком из снимка (полная синхронизация реплики) приходит вместе с TTL
+			replayer.Expire(rec.Key, time.UnixMilli(rec.ExpireAt))
+		}
+		return nil
+	case OpLPop:
+		_, _, err := replayer.LPop(rec.Key)
+		return err
+	case OpRPop:
+		_, _, err := replayer.RPop(rec.Key)
+		return err
+	case OpZAdd:
+		members, err := ScoredMembers(rec.Args)
+		if err != nil {
+			return err
+		}
+		if _, err := replayer.ZAdd(rec.Key, members); err != nil {
+			return err
+		}
+		if rec.ExpireAt != 0 {
+			// Множество целиком из снимка (полная синхронизация реплики) приходит вместе с TTL
+			replayer.Expire(rec.Key, time.UnixMilli(rec.ExpireAt))
+		}
+		return nil
+	case OpZRem:
+		_, err := replayer.ZRem(rec.Key, rec.Args)
+		return err
+	case OpSAdd:
+		if _, err := replayer.SAdd(rec.Key, rec.Args); err != nil {
+			return err
+		}
+		if rec.ExpireAt != 0 {
+			// Множество целиком из снимка (полная синхронизация реплики) приходит вместе с TTL
+			replayer.Expire(rec.Key, time.UnixMilli(rec.ExpireAt))
+		}
+		return nil
+	case OpSRem:
+		_, err := replayer.SRem(rec.Key, rec.Args)
+		return err
+	case OpBatch:
+		// Вложенная группа: команда, которая пишется несколькими операциями (MSET, SINTERSTORE и т.п.),
+		// внутри MULTI/EXEC
+		for _, op := range rec.Batch {
+			if err := applyOp(op, replayer); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown op: %d", rec.Op)
+	}
+}
+
+// ScoredMembers разбирает аргументы OpZAdd (пары счёт/элемент)
+func ScoredMembers(args []string) ([]storage.ScoredMember, error) {
+	members := make([]storage.ScoredMember, 0, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		score, err := strconv.ParseFloat(args[i], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid score %q: %w", args[i], err)
+		}
+		members = append(members, storage.ScoredMember{Member: args[i+1], Score: score})
+	}
+	return members, nil
+}
+
+// segmentLastLSN возвращает LSN последней записи сегмента (0 – сегмент пуст).
+// Если у следующего сегмента есть бинарный заголовок, хватает его base LSN – файл целиком не читаем.
+func segmentLastLSN(path, nextPath string) (uint64, error) {
+	if nextPath != "" {
+		if hdr, ok, err := readSegmentHeader(nextPath); err == nil && ok && hdr.baseLSN > 0 {
+			return hdr.baseLSN - 1, nil
+		}
+	}
+	var last uint64
+	err := readSegment(path, false, nil, func(rec Record) error {
+		last = rec.LSN
+		return nil
+	})
+	return last, err
+}
+
+// readSegmentHeader читает заголовок сегмента; ok=false – текстовый сегмент
+func readSegmentHeader(path string) (segmentHeader, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return segmentHeader{}, false, err
+	}
+	defer f.Close()
+
+	header := make([]byte, segmentHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return segmentHeader{}, false, nil
+	}
+	return decodeSegmentHeader(header)
+}
+
+// readSegment читает сегмент любого формата и передаёт записи в fn.
+// repairTail=true разрешает обрезать повреждённый хвост файла (только для последнего сегмента).
+func readSegment(path string, repairTail bool, logger *zap.Logger, fn func(Record) error) (err error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return err
@@ -51,35 +239,113 @@ func replayFile(path string, replayer Replayer) (err error) {
 		}
 	}()
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if err := applyLine(line, replayer); err != nil {
-			return fmt.Errorf("apply line %q error: %w", line, err)
+	reader := bufio.NewReader(file)
+	header, err := reader.Peek(segmentHeaderSize)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if len(header) == 0 {
+		return nil // пустой сегмент
+	}
+	hdr, binaryFormat, err := decodeSegmentHeader(header)
+	if err != nil {
+		return err
+	}
+	if !binaryFormat {
+		return readTextSegment(reader, fn)
+	}
+	if _, err := reader.Discard(segmentHeaderSize); err != nil {
+		return err
+	}
+
+	offset := int64(segmentHeaderSize)
+	for {
+		rec, n, err := readRecord(reader, hdr.version)
+		if err == io.EOF {
+			return nil
+		}
+		if errors.Is(err, errTornRecord) {
+			if !repairTail {
+				return fmt.Errorf("offset %d: %w", offset, err)
+			}
+			// Всё, что после последней целой записи, – недописанный при падении хвост
+			if logger != nil {
+				logger.Warn("truncating torn WAL tail",
+					zap.String("file", path),
+					zap.Int64("offset", offset),
+				)
+			}
+			return os.Truncate(path, offset)
 		}
+		if err != nil {
+			return fmt.Errorf("offset %d: %w", offset, err)
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+		offset += int64(n)
 	}
-	return scanner.Err()
 }
 
-// applyLine парсит строку вида "LSN=3 SET key1 value1" и вызывает compute
-func applyLine(line string, replayer Replayer) error {
-	// Простой разбор: уберём "LSN=3 " в начале
-	// Или используем regexp: ^LSN=(\d+)\s+(SET|DEL)\s+(\S+)\s+(.*)$
-	r := regexp.MustCompile(`^LSN=\d+\s+(SET|DEL)\s+(\S+)\s+(.*)$`)
-	m := r.FindStringSubmatch(line)
-	if len(m) != 4 {
-		return fmt.Errorf("invalid WAL line format")
-	}
-	cmdType := m[1]
-	key := m[2]
-	val := m[3] // для DEL тоже что-то может быть
-	switch cmdType {
+// walLineRe — строка старого текстового формата: "LSN=3 SET key1 value1"
+var walLineRe = regexp.MustCompile(`^LSN=(\d+)\s+(SET|SETEX|DEL|EXPIRE|PERSIST)\s+(\S+)\s+(.*)$`)
+
+// readTextSegment читает сегмент старого текстового формата (путь миграции).
+// Читаем через bufio.Reader, а не Scanner, чтобы не упираться в лимит 64KB на строку.
+// Последняя строка без '\n' считается недописанной и пропускается.
+func readTextSegment(reader *bufio.Reader, fn func(Record) error) error {
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rec, err := parseTextLine(strings.TrimSuffix(line, "\n"))
+		if err != nil {
+			return fmt.Errorf("line %q: %w", line, err)
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// parseTextLine разбирает строку текстового формата в Record
+func parseTextLine(line string) (Record, error) {
+	m := walLineRe.FindStringSubmatch(line)
+	if len(m) != 5 {
+		return Record{}, fmt.Errorf("invalid WAL line format")
+	}
+	lsn, err := strconv.ParseUint(m[1], 10, 64)
+	if err != nil {
+		return Record{}, fmt.Errorf("invalid LSN: %w", err)
+	}
+	rec := Record{LSN: lsn, Key: m[3]}
+	val := m[4] // для DEL тоже что-то может быть
+	switch m[2] {
 	case "SET":
-		return replayer.Set(key, val)
+		rec.Op = OpSet
+		rec.Value = val
+	case "SETEX":
+		// "SETEX key <expireAtMs> value"
+		atStr, value, _ := strings.Cut(val, " ")
+		if rec.ExpireAt, err = strconv.ParseInt(atStr, 10, 64); err != nil {
+			return Record{}, fmt.Errorf("invalid expire time %q: %w", atStr, err)
+		}
+		rec.Op = OpSet
+		rec.Value = value
 	case "DEL":
-		replayer.Del(key)
-		return nil
-	default:
-		return fmt.Errorf("unknown op: %s", cmdType)
+		rec.Op = OpDel
+	case "EXPIRE":
+		atStr := strings.TrimSpace(val)
+		if rec.ExpireAt, err = strconv.ParseInt(atStr, 10, 64); err != nil {
+			return Record{}, fmt.Errorf("invalid expire time %q: %w", atStr, err)
+		}
+		rec.Op = OpExpire
+	case "PERSIST":
+		rec.Op = OpPersist
 	}
+	return rec, nil
 }
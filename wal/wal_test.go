@@ -1,13 +1,19 @@
 package wal_test
 
 import (
+	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"imkvdb/config"
+	"imkvdb/storage"
+	"imkvdb/storage/engine"
 	"imkvdb/wal"
 
 	"go.uber.org/zap"
@@ -29,7 +35,7 @@ func TestFileWAL_BasicBatch(t *testing.T) {
 		MaxSegmentSize:       "10MB",
 		DataDirectory:        dir,
 	}
-	w, err := wal.NewFileWAL(cfg, logger)
+	w, err := wal.NewFileWAL(cfg, 0, logger)
 	if err != nil {
 		t.Fatalf("failed to create WAL: %v", err)
 	}
@@ -66,3 +72,563 @@ func TestFileWAL_BasicBatch(t *testing.T) {
 		t.Errorf("expected wal file to have >0 size after flush, got %d", info.Size())
 	}
 }
+
+// После ошибки записи WAL отклоняет все следующие записи, чтобы в логе не было пропусков.
+// Ошибку вызывает ротация в удалённый каталог: записи батча к этому моменту уже на диске.
+func TestFileWAL_FailStop(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "wal")
+	w, err := wal.NewFileWAL(config.WALConfig{
+		Enabled:              true,
+		FlushingBatchSize:    1,
+		FlushingBatchTimeout: 50 * time.Millisecond,
+		MaxSegmentSize:       "16",
+		DataDirectory:        dir,
+	}, 0, zap.NewNop())
+	if err != nil {
+		t.Fatalf("failed to create WAL: %v", err)
+	}
+	defer w.Close()
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.WriteAndWait(wal.Record{Op: wal.OpSet, Key: "k1", Value: "v1"}); err != nil {
+		t.Fatalf("record before the failed rotation: %v", err)
+	}
+	if w.Err() == nil {
+		t.Fatal("expected Err after the failed rotation")
+	}
+	if err := w.WriteAndWait(wal.Record{Op: wal.OpSet, Key: "k2", Value: "v2"}); err == nil {
+		t.Error("expected records after the failure to be rejected")
+	}
+	if got := w.SyncedLSN(); got != 1 {
+		t.Errorf("SyncedLSN = %d, want 1", got)
+	}
+}
+
+func TestReplayWAL_Expire(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+
+	dir, err := ioutil.TempDir("", "wal_replay_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := config.WALConfig{
+		Enabled:              true,
+		FlushingBatchSize:    1,
+		FlushingBatchTimeout: 10 * time.Millisecond,
+		MaxSegmentSize:       "10MB",
+		DataDirectory:        dir,
+	}
+	w, err := wal.NewFileWAL(cfg, 0, logger)
+	if err != nil {
+		t.Fatalf("failed to create WAL: %v", err)
+	}
+
+	past := time.Now().Add(-time.Minute).UnixMilli()
+	future := time.Now().Add(time.Hour).UnixMilli()
+	records := []wal.Record{
+		{Op: wal.OpSet, Key: "dead", Value: "v1", ExpireAt: past},
+		{Op: wal.OpSet, Key: "alive", Value: "v2", ExpireAt: future},
+		{Op: wal.OpSet, Key: "expired_later", Value: "v3"},
+		{Op: wal.OpExpire, Key: "expired_later", ExpireAt: past},
+		{Op: wal.OpSet, Key: "persisted", Value: "v4", ExpireAt: future},
+		{Op: wal.OpPersist, Key: "persisted"},
+	}
+	for _, rec := range records {
+		if err := w.WriteAndWait(rec); err != nil {
+			t.Fatalf("WriteAndWait error: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+
+	eng := engine.NewInMemoryEngine(logger)
+	if _, err := wal.ReplayWAL(dir, 0, eng, logger); err != nil {
+		t.Fatalf("ReplayWAL error: %v", err)
+	}
+
+	// Истёкшие ключи не должны "ожить" после реплея
+	for _, key := range []string{"dead", "expired_later"} {
+		if _, ok, _ := eng.Get(key); ok {
+			t.Errorf("expected %q to stay expired after replay", key)
+		}
+	}
+	if val, ok, _ := eng.Get("alive"); !ok || val != "v2" {
+		t.Errorf("got alive=%q, found=%v, want v2", val, ok)
+	}
+	if at, ok := eng.ExpireTime("alive"); !ok || at.UnixMilli() != future {
+		t.Errorf("got alive expire time %v, want %d", at, future)
+	}
+	if at, ok := eng.ExpireTime("persisted"); !ok || !at.IsZero() {
+		t.Errorf("expected persisted key without TTL, got %v, found=%v", at, ok)
+	}
+}
+
+func TestReplayWAL_BinarySafeValues(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	dir := t.TempDir()
+
+	cfg := config.WALConfig{
+		Enabled:              true,
+		FlushingBatchSize:    1,
+		FlushingBatchTimeout: 10 * time.Millisecond,
+		MaxSegmentSize:       "10MB",
+		DataDirectory:        dir,
+	}
+	w, err := wal.NewFileWAL(cfg, 0, logger)
+	if err != nil {
+		t.Fatalf("failed to create WAL: %v", err)
+	}
+	values := map[string]string{
+		"spaces":  "hello big world",
+		"newline": "line1\nline2\r\n",
+		"binary":  "\x00\xff\x01LSN=1 SET x y",
+		"large":   strings.Repeat("x", 100*1024), // больше лимита bufio.Scanner
+		"empty":   "",
+	}
+	for key, val := range values {
+		if err := w.WriteAndWait(wal.Record{Op: wal.OpSet, Key: key, Value: val}); err != nil {
+			t.Fatalf("WriteAndWait error: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	eng := engine.NewInMemoryEngine(zap.NewNop())
+	last, err := wal.ReplayWAL(dir, 0, eng, logger)
+	if err != nil {
+		t.Fatalf("ReplayWAL error: %v", err)
+	}
+	if last != uint64(len(values)) {
+		t.Errorf("got last LSN %d, want %d", last, len(values))
+	}
+	for key, want := range values {
+		if got, ok, _ := eng.Get(key); !ok || got != want {
+			t.Errorf("key %q: got %q (found=%v), want %q", key, got, ok, want)
+		}
+	}
+}
+
+func TestReplayWAL_TornTail(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	dir := t.TempDir()
+
+	cfg := config.WALConfig{
+		Enabled:              true,
+		FlushingBatchSize:    1,
+		FlushingBatchTimeout: 10 * time.Millisecond,
+		MaxSegmentSize:       "10MB",
+		DataDirectory:        dir,
+	}
+	w, err := wal.NewFileWAL(cfg, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"k1", "k2", "k3"} {
+		if err := w.WriteAndWait(wal.Record{Op: wal.OpSet, Key: key, Value: "v"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = w.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "wal_segment_*.log"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 segment, got %d", len(files))
+	}
+	// Имитируем падение посреди записи: отрезаем последние байты
+	info, _ := os.Stat(files[0])
+	if err := os.Truncate(files[0], info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	eng := engine.NewInMemoryEngine(zap.NewNop())
+	last, err := wal.ReplayWAL(dir, 0, eng, logger)
+	if err != nil {
+		t.Fatalf("expected torn tail to be cut cleanly, got error: %v", err)
+	}
+	if last != 2 {
+		t.Errorf("got last LSN %d, want 2", last)
+	}
+	if _, ok, _ := eng.Get("k3"); ok {
+		t.Error("expected torn record k3 to be dropped")
+	}
+
+	// После обрезки WAL продолжает работать: дописываем и снова читаем
+	w, err = wal.NewFileWAL(cfg, last, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteAndWait(wal.Record{Op: wal.OpSet, Key: "k4", Value: "v"}); err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Close()
+
+	eng = engine.NewInMemoryEngine(zap.NewNop())
+	if last, err = wal.ReplayWAL(dir, 0, eng, logger); err != nil || last != 3 {
+		t.Fatalf("second replay: last=%d, err=%v", last, err)
+	}
+	if _, ok, _ := eng.Get("k4"); !ok {
+		t.Error("expected k4 after restart")
+	}
+}
+
+func TestReplayWAL_CorruptionInTheMiddle(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	dir := t.TempDir()
+
+	cfg := config.WALConfig{
+		Enabled:              true,
+		FlushingBatchSize:    1,
+		FlushingBatchTimeout: 10 * time.Millisecond,
+		MaxSegmentSize:       "10MB",
+		DataDirectory:        dir,
+	}
+	w, err := wal.NewFileWAL(cfg, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = w.WriteAndWait(wal.Record{Op: wal.OpSet, Key: "k1", Value: "value1"})
+	_ = w.Close()
+	// Второй сегмент, чтобы первый не был последним
+	w, err = wal.NewFileWAL(cfg, 1, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = w.WriteAndWait(wal.Record{Op: wal.OpSet, Key: "k2", Value: "value2"})
+	_ = w.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "wal_segment_*.log"))
+	data, _ := os.ReadFile(files[0])
+	data[len(data)-2] ^= 0xFF // портим значение -> не сойдётся CRC
+	if err := os.WriteFile(files[0], data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := wal.ReplayWAL(dir, 0, engine.NewInMemoryEngine(zap.NewNop()), logger); err == nil {
+		t.Fatal("expected corruption in a non-last segment to fail replay")
+	}
+}
+
+func TestReplayWAL_LegacyTextSegment(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	dir := t.TempDir()
+
+	// Сегмент в старом текстовом формате, за ним – новый бинарный
+	future := time.Now().Add(time.Hour).UnixMilli()
+	legacy := "LSN=1 SET k1 v1\n" +
+		"LSN=2 SET k2 some value\n" +
+		fmt.Sprintf("LSN=3 SETEX k3 %d v3\n", future) +
+		"LSN=4 DEL k1 \n" +
+		"LSN=5 SET k5 torn" // недописанная строка
+	if err := os.WriteFile(filepath.Join(dir, "wal_segment_1000000000000000000.log"), []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.WALConfig{
+		Enabled:              true,
+		FlushingBatchSize:    1,
+		FlushingBatchTimeout: 10 * time.Millisecond,
+		MaxSegmentSize:       "10MB",
+		DataDirectory:        dir,
+	}
+	eng := engine.NewInMemoryEngine(zap.NewNop())
+	last, err := wal.ReplayWAL(dir, 0, eng, logger)
+	if err != nil || last != 4 {
+		t.Fatalf("legacy replay: last=%d, err=%v", last, err)
+	}
+
+	w, err := wal.NewFileWAL(cfg, last, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = w.WriteAndWait(wal.Record{Op: wal.OpSet, Key: "k6", Value: "v6"})
+	_ = w.Close()
+
+	eng = engine.NewInMemoryEngine(zap.NewNop())
+	if last, err = wal.ReplayWAL(dir, 0, eng, logger); err != nil || last != 5 {
+		t.Fatalf("mixed replay: last=%d, err=%v", last, err)
+	}
+	if _, ok, _ := eng.Get("k1"); ok {
+		t.Error("expected k1 to be deleted")
+	}
+	if got, _, _ := eng.Get("k2"); got != "some value" {
+		t.Errorf("got k2=%q, want %q", got, "some value")
+	}
+	if at, ok := eng.ExpireTime("k3"); !ok || at.UnixMilli() != future {
+		t.Errorf("got k3 expire %v (found=%v), want %d", at, ok, future)
+	}
+	if got, _, _ := eng.Get("k6"); got != "v6" {
+		t.Errorf("got k6=%q, want v6", got)
+	}
+}
+
+func TestReplayWAL_Batch(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	dir := t.TempDir()
+
+	cfg := config.WALConfig{
+		Enabled:              true,
+		FlushingBatchSize:    1,
+		FlushingBatchTimeout: 10 * time.Millisecond,
+		MaxSegmentSize:       "10MB",
+		DataDirectory:        dir,
+	}
+	w, err := wal.NewFileWAL(cfg, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = w.WriteAndWait(wal.Record{Op: wal.OpSet, Key: "stock:a", Value: "10"})
+	batch := wal.Record{Op: wal.OpBatch, Batch: []wal.Record{
+		{Op: wal.OpSet, Key: "stock:a", Value: "9"},
+		{Op: wal.OpSet, Key: "stock:b", Value: "1", ExpireAt: time.Now().Add(time.Hour).UnixMilli()},
+		{Op: wal.OpDel, Key: "reserved"},
+	}}
+	if err := w.WriteAndWait(batch); err != nil {
+		t.Fatal(err)
+	}
+	// Второй батч обрываем на середине: он не должен примениться даже частично
+	if err := w.WriteAndWait(wal.Record{Op: wal.OpBatch, Batch: []wal.Record{
+		{Op: wal.OpSet, Key: "stock:a", Value: "8"},
+		{Op: wal.OpSet, Key: "stock:c", Value: "1"},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "wal_segment_*.log"))
+	info, _ := os.Stat(files[0])
+	if err := os.Truncate(files[0], info.Size()-5); err != nil {
+		t.Fatal(err)
+	}
+
+	eng := engine.NewInMemoryEngine(zap.NewNop())
+	last, err := wal.ReplayWAL(dir, 0, eng, logger)
+	if err != nil {
+		t.Fatalf("ReplayWAL error: %v", err)
+	}
+	if last != 2 {
+		t.Errorf("got last LSN %d, want 2", last)
+	}
+	if got, _, _ := eng.Get("stock:a"); got != "9" {
+		t.Errorf("got stock:a=%q, want 9", got)
+	}
+	if got, _, _ := eng.Get("stock:b"); got != "1" {
+		t.Errorf("got stock:b=%q, want 1", got)
+	}
+	if _, ok, _ := eng.Get("stock:c"); ok {
+		t.Error("expected torn batch to be dropped entirely")
+	}
+}
+
+func TestReplayWAL_Hash(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	dir := t.TempDir()
+
+	cfg := config.WALConfig{
+		Enabled:              true,
+		FlushingBatchSize:    1,
+		FlushingBatchTimeout: 10 * time.Millisecond,
+		MaxSegmentSize:       "10MB",
+		DataDirectory:        dir,
+	}
+	w, err := wal.NewFileWAL(cfg, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []wal.Record{
+		{Op: wal.OpHSet, Key: "user:1", Args: []string{"name", "Ann Lee", "bio", "line1\nline2\x00"}},
+		{Op: wal.OpHSet, Key: "user:1", Args: []string{"name", "Ann"}, Version: 5},
+		{Op: wal.OpHDel, Key: "user:1", Args: []string{"bio"}, Version: 6},
+		{Op: wal.OpBatch, Batch: []wal.Record{
+			{Op: wal.OpHSet, Key: "tmp", Args: []string{"f", "v"}},
+			{Op: wal.OpHDel, Key: "tmp", Args: []string{"f"}},
+		}},
+	}
+	for _, rec := range records {
+		if err := w.WriteAndWait(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = w.Close()
+
+	eng := engine.NewInMemoryEngine(zap.NewNop())
+	if _, err := wal.ReplayWAL(dir, 0, eng, logger); err != nil {
+		t.Fatalf("ReplayWAL error: %v", err)
+	}
+	pairs, err := eng.HGetAll("user:1")
+	if err != nil || strings.Join(pairs, "|") != "name|Ann" {
+		t.Errorf("got user:1=%q (err=%v), want [name Ann]", pairs, err)
+	}
+	if v := eng.Version("user:1"); v != 6 {
+		t.Errorf("got version %d, want 6", v)
+	}
+	if n, _ := eng.HLen("tmp"); n != 0 {
+		t.Error("expected tmp hash to be deleted")
+	}
+}
+
+func TestReplayWAL_List(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	dir := t.TempDir()
+
+	cfg := config.WALConfig{
+		Enabled:              true,
+		FlushingBatchSize:    1,
+		FlushingBatchTimeout: 10 * time.Millisecond,
+		MaxSegmentSize:       "10MB",
+		DataDirectory:        dir,
+	}
+	w, err := wal.NewFileWAL(cfg, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range []wal.Record{
+		{Op: wal.OpRPush, Key: "q", Args: []string{"b", "c", "d"}},
+		{Op: wal.OpLPush, Key: "q", Args: []string{"a"}},
+		{Op: wal.OpLPop, Key: "q"},
+		{Op: wal.OpRPop, Key: "q"},
+	} {
+		if err := w.WriteAndWait(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = w.Close()
+
+	eng := engine.NewInMemoryEngine(zap.NewNop())
+	if _, err := wal.ReplayWAL(dir, 0, eng, logger); err != nil {
+		t.Fatalf("ReplayWAL error: %v", err)
+	}
+	if items, _ := eng.LRange("q", 0, -1); strings.Join(items, ",") != "b,c" {
+		t.Errorf("got q=%q, want [b c]", items)
+	}
+}
+
+func TestReplayWAL_ZSet(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	dir := t.TempDir()
+
+	cfg := config.WALConfig{
+		Enabled:              true,
+		FlushingBatchSize:    1,
+		FlushingBatchTimeout: 10 * time.Millisecond,
+		MaxSegmentSize:       "10MB",
+		DataDirectory:        dir,
+	}
+	w, err := wal.NewFileWAL(cfg, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range []wal.Record{
+		{Op: wal.OpZAdd, Key: "z", Args: []string{"1.5", "a", "-inf", "b", "1e300", "c"}},
+		{Op: wal.OpZAdd, Key: "z", Args: []string{"0.1", "c"}},
+		{Op: wal.OpZRem, Key: "z", Args: []string{"a"}},
+	} {
+		if err := w.WriteAndWait(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = w.Close()
+
+	eng := engine.NewInMemoryEngine(zap.NewNop())
+	if _, err := wal.ReplayWAL(dir, 0, eng, logger); err != nil {
+		t.Fatalf("ReplayWAL error: %v", err)
+	}
+	members, _ := eng.ZRange("z", 0, -1)
+	want := []storage.ScoredMember{{Member: "b", Score: math.Inf(-1)}, {Member: "c", Score: 0.1}}
+	if !reflect.DeepEqual(members, want) {
+		t.Errorf("got z=%v, want %v", members, want)
+	}
+}
+
+func TestReplayWAL_Set(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	dir := t.TempDir()
+
+	cfg := config.WALConfig{
+		Enabled:              true,
+		FlushingBatchSize:    1,
+		FlushingBatchTimeout: 10 * time.Millisecond,
+		MaxSegmentSize:       "10MB",
+		DataDirectory:        dir,
+	}
+	w, err := wal.NewFileWAL(cfg, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range []wal.Record{
+		{Op: wal.OpSAdd, Key: "s", Args: []string{"a", "b", "c"}},
+		{Op: wal.OpSRem, Key: "s", Args: []string{"b"}},
+		{Op: wal.OpSet, Key: "dst", Value: "string"},
+		// SINTERSTORE внутри MULTI/EXEC: вложенная группа
+		{Op: wal.OpBatch, Batch: []wal.Record{
+			{Op: wal.OpSAdd, Key: "s", Args: []string{"d"}},
+			{Op: wal.OpBatch, Batch: []wal.Record{
+				{Op: wal.OpDel, Key: "dst"},
+				{Op: wal.OpSAdd, Key: "dst", Args: []string{"a", "d"}, Version: 9},
+			}},
+		}},
+	} {
+		if err := w.WriteAndWait(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = w.Close()
+
+	eng := engine.NewInMemoryEngine(zap.NewNop())
+	if _, err := wal.ReplayWAL(dir, 0, eng, logger); err != nil {
+		t.Fatalf("ReplayWAL error: %v", err)
+	}
+	if members, _ := eng.SMembers("s"); strings.Join(members, ",") != "a,c,d" {
+		t.Errorf("got s=%q, want [a c d]", members)
+	}
+	if members, _ := eng.SMembers("dst"); strings.Join(members, ",") != "a,d" {
+		t.Errorf("got dst=%q, want [a d]", members)
+	}
+	if v := eng.Version("dst"); v != 9 {
+		t.Errorf("got dst version %d, want 9", v)
+	}
+}
+
+func TestReplayWAL_Versions(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	dir := t.TempDir()
+
+	cfg := config.WALConfig{
+		Enabled:              true,
+		FlushingBatchSize:    1,
+		FlushingBatchTimeout: 10 * time.Millisecond,
+		MaxSegmentSize:       "10MB",
+		DataDirectory:        dir,
+	}
+	w, err := wal.NewFileWAL(cfg, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = w.WriteAndWait(wal.Record{Op: wal.OpSet, Key: "a", Value: "1", Version: 7})
+	_ = w.WriteAndWait(wal.Record{Op: wal.OpBatch, Batch: []wal.Record{
+		{Op: wal.OpSet, Key: "a", Value: "2", Version: 9},
+		{Op: wal.OpSet, Key: "b", Value: "1", Version: 10},
+	}})
+	_ = w.Close()
+
+	eng := engine.NewInMemoryEngine(zap.NewNop())
+	if _, err := wal.ReplayWAL(dir, 0, eng, logger); err != nil {
+		t.Fatalf("ReplayWAL error: %v", err)
+	}
+	if v := eng.Version("a"); v != 9 {
+		t.Errorf("got version of a=%d, want 9", v)
+	}
+	if v := eng.Version("b"); v != 10 {
+		t.Errorf("got version of b=%d, want 10", v)
+	}
+	// Новые изменения продолжают нумерацию после восстановленных версий
+	_ = eng.Set("c", "1")
+	if v := eng.Version("c"); v != 11 {
+		t.Errorf("got version of c=%d, want 11", v)
+	}
+}